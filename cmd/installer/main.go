@@ -26,8 +26,9 @@ func main() {
 	namespace := flag.String("namespace", "kube-system", "Namespace in which all Kubernetes resources will be created.")
 	prefix := flag.String("name", "network-resources-injector", "Prefix added to the names of all created resources.")
 	failurePolicy := flag.String("failure-policy", "Fail", "K8 admission controller failure policy to handle unrecognized errors and timeout errors")
+	enableValidation := flag.Bool("enable-validation", false, "Register validating webhooks which check pod resources and net-attach-def annotations.")
 	flag.Parse()
 
 	glog.Info("starting webhook installation")
-	installer.Install(*namespace, *prefix, *failurePolicy)
+	installer.Install(*namespace, *prefix, *failurePolicy, *enableValidation)
 }
@@ -21,15 +21,22 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/restmapper"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/ownerref"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/resourcemappings"
 	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/webhook"
@@ -64,6 +71,15 @@ func main() {
 			"See https://pkg.go.dev/crypto/tls#CurveID for values supported for each Go version. "+
 			"If empty, uses Go runtime defaults.")
 
+	netAttachDefNamespaces := flag.String("net-attach-def-namespaces", "",
+		"Comma-separated list of namespaces in which net-attach-defs are watched. If empty, net-attach-defs in all namespaces are watched.")
+	apiTimeout := flag.Duration("api-timeout", webhook.DefaultAPITimeout,
+		"Timeout of API server calls made while processing an admission request.")
+	netAttachDefMetadataOnly := flag.Bool("net-attach-def-metadata-only", false,
+		"Watch only metadata of net-attach-defs, which reduces memory used by the net-attach-def cache.")
+	netAttachDefMaxStaleness := flag.Duration("net-attach-def-max-staleness", 0,
+		"Maximum age of cached net-attach-defs while the watch of net-attach-defs fails. Older net-attach-defs are retrieved from the API server and the cache is reported as stale in logs and metrics. If zero, cached net-attach-defs are always used.")
+
 	// do initialization of control switches flags
 	controlSwitches := controlswitches.SetupControlSwitchesFlags()
 
@@ -72,7 +88,15 @@ func main() {
 
 	// initialize all control switches structures
 	controlSwitches.InitControlSwitches()
-	glog.Infof("controlSwitches: %+v", *controlSwitches)
+	glog.Infof("controlSwitches: %s", controlSwitches.GetAllFeaturesState())
+
+	if !controlSwitches.IsValid() {
+		glog.Fatalf("invalid control switches configuration")
+	}
+
+	if *netAttachDefMetadataOnly && len(controlSwitches.GetResourceConfigPaths()) > 0 {
+		glog.Fatalf("resource name config paths can't be used when only metadata of net-attach-defs is watched")
+	}
 
 	if !isValidPort(*port) {
 		glog.Fatalf("invalid port number. Choose between 1024 and 65535")
@@ -98,22 +122,6 @@ func main() {
 		glog.Fatalf("Invalid health check port number. Choose between 1024 and 65535")
 	} else if *healthCheckPort == *port {
 		glog.Fatalf("Health check port should be different from port")
-	} else {
-		go func() {
-			addr := fmt.Sprintf("%s:%d", *address, *healthCheckPort)
-			mux := http.NewServeMux()
-
-			mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-			})
-			mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-			})
-			err := http.ListenAndServe(addr, mux)
-			if err != nil {
-				glog.Fatalf("error starting health check server: %v", err)
-			}
-		}()
 	}
 
 	glog.Infof("starting mutating admission controller for network resources injection")
@@ -148,36 +156,73 @@ func main() {
 	/* init API client */
 	clientset := webhook.SetupInClusterClient()
 
-	// initialize webhook with controlSwitches
-	webhook.SetControlSwitches(controlSwitches)
-
 	// initialize webhook with cache
-	netAnnotationCache := netcache.Create()
+	netAnnotationCache := netcache.Create(netcache.Options{
+		Namespaces:   splitNamespaces(*netAttachDefNamespaces),
+		MetadataOnly: *netAttachDefMetadataOnly,
+		MaxStaleness: *netAttachDefMaxStaleness,
+	})
 	netAnnotationCache.Start()
-	webhook.SetNetAttachDefCache(netAnnotationCache)
-
-	userInjections := userdefinedinjections.CreateUserInjectionsStructure()
-	webhook.SetUserInjectionStructure(userInjections)
+	if err := netcache.RegisterMetrics(prometheus.DefaultRegisterer, netAnnotationCache); err != nil {
+		glog.Fatalf("error registering net-attach-def cache metrics: %v", err)
+	}
 
 	go func() {
-		/* register handlers */
-		var httpServer *http.Server
+		addr := fmt.Sprintf("%s:%d", *address, *healthCheckPort)
+		mux := http.NewServeMux()
 
-		http.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path != "/mutate" {
-				http.NotFound(w, r)
-				return
-			}
-			if r.Method != http.MethodPost {
-				http.Error(w, "Invalid HTTP verb requested", http.StatusMethodNotAllowed)
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			/* stale net-attach-defs are retrieved from the API server, staleness is reported by metrics and logs */
+			if stats := netAnnotationCache.Stats(); !stats.Synced {
+				http.Error(w, fmt.Sprintf("net-attach-def cache is not synced, staleness %v", stats.Staleness),
+					http.StatusServiceUnavailable)
 				return
 			}
-			webhook.MutateHandler(w, r)
+			w.WriteHeader(http.StatusOK)
 		})
+		mux.Handle("/metrics", promhttp.Handler())
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			glog.Fatalf("error starting health check server: %v", err)
+		}
+	}()
+
+	// owners of unknown kinds are mapped to their resources through discovery
+	ownerResolver := ownerref.Create(webhook.SetupInClusterMetadataClient(),
+		restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())))
+	ownerResolver.Start()
+
+	userInjections := userdefinedinjections.CreateUserInjectionsStructure()
+	resourceMappings := resourcemappings.CreateResourceNameMappingsStructure()
+
+	wh, err := webhook.New(webhook.Options{
+		Client:            clientset,
+		NetAttachDefCache: netAnnotationCache,
+		OwnerResolver:     ownerResolver,
+		Config:            webhook.NewConfigProvider(controlSwitches, userInjections, resourceMappings),
+		APITimeout:        *apiTimeout,
+	})
+	if err != nil {
+		glog.Fatalf("error creating webhook: %v", err)
+	}
+
+	// templates created for the deviceClassName annotation are not owned by any pod
+	webhook.NewResourceClaimTemplateCollector(clientset).Start()
+
+	if controlSwitches.GetMissingNetAttachDefAction() == controlswitches.MissingNetAttachDefActionGate {
+		webhook.NewSchedulingGateController(wh, netAnnotationCache).Start()
+	}
+
+	go func() {
+		var httpServer *http.Server
 
 		/* start serving */
 		httpServer = &http.Server{
 			Addr:              fmt.Sprintf("%s:%d", *address, *port),
+			Handler:           wh,
 			ReadTimeout:       5 * time.Second,
 			WriteTimeout:      10 * time.Second,
 			MaxHeaderBytes:    1 << 20,
@@ -272,6 +317,7 @@ func main() {
 			// to be called each time when map is present or not (in that case to restore default values)
 			controlSwitches.ProcessControlSwitchesConfigMap(cm)
 			userInjections.SetUserDefinedInjections(cm)
+			resourceMappings.SetResourceNameMappings(cm)
 		}
 	}
 
@@ -285,3 +331,13 @@ func isValidPort(port int) bool {
 	}
 	return true
 }
+
+func splitNamespaces(namespaces string) []string {
+	var result []string
+	for _, namespace := range strings.Split(namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			result = append(result, namespace)
+		}
+	}
+	return result
+}
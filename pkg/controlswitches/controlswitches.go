@@ -18,10 +18,14 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"path"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/golang/glog"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
 )
@@ -34,8 +38,107 @@ const (
 	enableHugePageDownAPIKey = "enableHugePageDownApi"
 	// enableHonorExistingResourcesKey feature name
 	enableHonorExistingResourcesKey = "enableHonorExistingResources"
+	// enableInitContainersKey feature name
+	enableInitContainersKey = "enableInitContainers"
+	// enableNetworkResourcesMapKey feature name
+	enableNetworkResourcesMapKey = "enableNetworkResourcesMap"
+	// lookupFailurePoliciesKey per namespace overrides of the net-attach-def lookup failure policy
+	lookupFailurePoliciesKey = "lookupFailurePolicies"
 )
 
+// actions taken by the validating webhook when pod resources don't match its networks
+const (
+	PodValidationActionDeny = "deny"
+	PodValidationActionWarn = "warn"
+)
+
+// modes of handling net-attach-defs with more than one of the resource name keys
+const (
+	// ResourceNameKeysModeAll requests resources of all present keys
+	ResourceNameKeysModeAll = "all"
+	// ResourceNameKeysModeFirstMatch requests resource of the first present key in the order of the flag
+	ResourceNameKeysModeFirstMatch = "first-match"
+	// ResourceNameKeysModeErrorOnMultiple refuses net-attach-defs with more than one present key
+	ResourceNameKeysModeErrorOnMultiple = "error-on-multiple"
+)
+
+// modes of injecting resource claims of net-attach-defs which reference DRA devices
+const (
+	// DRAInjectionModeAdditional requests resource claims in addition to the extended resources
+	DRAInjectionModeAdditional = "additional"
+	// DRAInjectionModeReplace requests only resource claims, extended resources of such net-attach-defs are ignored
+	DRAInjectionModeReplace = "replace"
+)
+
+// places where network resources are injected into pods with pod-level resources
+const (
+	// PodLevelResourcesModeContainer injects all resources in the first container
+	PodLevelResourcesModeContainer = "container"
+	// PodLevelResourcesModePod injects resources supported at pod level, cpu, memory and hugepages, only into the
+	// pod-level resources, extended resources are still injected in the first container
+	PodLevelResourcesModePod = "pod"
+	// PodLevelResourcesModeBoth injects resources supported at pod level into the first container and the pod-level resources
+	PodLevelResourcesModeBoth = "both"
+)
+
+// actions taken by the mutating webhook when a pod references net-attach-defs which don't exist
+const (
+	// MissingNetAttachDefActionDeny denies the pod
+	MissingNetAttachDefActionDeny = "deny"
+	// MissingNetAttachDefActionGate admits the pod with a scheduling gate, it is deleted once the net-attach-defs exist
+	// so that its controller creates it again
+	MissingNetAttachDefActionGate = "gate"
+)
+
+// policies of the mutating webhook when a net-attach-def can't be retrieved, e.g. because of an API server timeout
+const (
+	// LookupFailurePolicyDeny denies the pod
+	LookupFailurePolicyDeny = "deny"
+	// LookupFailurePolicyAdmit admits the pod with a warning, the network is not injected
+	LookupFailurePolicyAdmit = "admit"
+	// LookupFailurePolicyUseCached injects the network from the last known net-attach-def, the pod is denied when there is none
+	LookupFailurePolicyUseCached = "use-cached"
+)
+
+// actions taken by the mutating webhook when a pod with network resources won't have Guaranteed QoS
+const (
+	// GuaranteedQoSActionIgnore doesn't check QoS class of the pod
+	GuaranteedQoSActionIgnore = "ignore"
+	// GuaranteedQoSActionWarn admits the pod with a warning
+	GuaranteedQoSActionWarn = "warn"
+	// GuaranteedQoSActionDeny denies the pod
+	GuaranteedQoSActionDeny = "deny"
+)
+
+// containers in which the Downward API volume is mounted
+const (
+	// DownwardAPIContainersAll mounts the volume in all containers, init containers included when enabled
+	DownwardAPIContainersAll = "all"
+	// DownwardAPIContainersResources mounts the volume only in the container which gets the network resources
+	DownwardAPIContainersResources = "resources"
+)
+
+// how hugepages are exposed to containers when the Downward API injection of hugepages is enabled
+const (
+	// HugepagesDownwardAPIModeFiles projects hugepages as files of the Downward API volume
+	HugepagesDownwardAPIModeFiles = "files"
+	// HugepagesDownwardAPIModeEnv sets hugepages in env vars of the containers
+	HugepagesDownwardAPIModeEnv = "env"
+	// HugepagesDownwardAPIModeBoth projects hugepages as files and sets them in env vars
+	HugepagesDownwardAPIModeBoth = "both"
+)
+
+// items projected by the Downward API volume
+const (
+	DownwardAPIItemLabels              = "labels"
+	DownwardAPIItemAnnotations         = "annotations"
+	DownwardAPIItemHugepages           = "hugepages"
+	DownwardAPIItemNetworkResourcesMap = "network-resources-map"
+)
+
+var downwardAPIItems = []string{DownwardAPIItemLabels, DownwardAPIItemAnnotations, DownwardAPIItemHugepages,
+	DownwardAPIItemNetworkResourcesMap}
+
 // controlSwitchesStates - depicts possible feature states
 type controlSwitchesStates struct {
 	active  bool
@@ -54,13 +157,32 @@ func (state *controlSwitchesStates) setActiveState(value bool) {
 
 type ControlSwitches struct {
 	// pointers to command line arguments
-	injectHugepageDownAPI *bool
-	resourceNameKeysFlag  *string
-	resourcesHonorFlag    *bool
-
-	configuration    map[string]controlSwitchesStates
-	resourceNameKeys []string
-	isValid          bool
+	injectHugepageDownAPI     *bool
+	resourceNameKeysFlag      *string
+	resourcesHonorFlag        *bool
+	initContainersFlag        *bool
+	networkResourcesMapFlag   *bool
+	podValidationAction       *string
+	resourceConfigPathsFlag   *string
+	resourceNameKeysMode      *string
+	draInjectionMode          *string
+	podLevelResourcesMode     *string
+	missingNetAttachDefAction *string
+	lookupFailurePolicy       *string
+	guaranteedQoSAction       *string
+	downwardAPIVolumeName     *string
+	downwardAPIMountPath      *string
+	downwardAPIContainers     *string
+	downwardAPIItemsFlag      *string
+	hugepagesDownwardAPIMode  *string
+
+	configuration         map[string]controlSwitchesStates
+	lookupFailurePolicies map[string]string
+	policiesMutex         sync.RWMutex
+	resourceNameKeys      []string
+	resourceConfigPaths   []string
+	downwardAPIItems      []string
+	isValid               bool
 }
 
 // SetupControlSwitchesFlags - setup all control switches flags that can be set as command line NRI arguments
@@ -71,6 +193,37 @@ func SetupControlSwitchesFlags() *ControlSwitches {
 	initFlags.injectHugepageDownAPI = flag.Bool("injectHugepageDownApi", false, "Enable hugepage requests and limits into Downward API.")
 	initFlags.resourceNameKeysFlag = flag.String("network-resource-name-keys", "k8s.v1.cni.cncf.io/resourceName", "comma separated resource name keys --network-resource-name-keys.")
 	initFlags.resourcesHonorFlag = flag.Bool("honor-resources", false, "Honor the existing requested resources requests & limits --honor-resources")
+	initFlags.initContainersFlag = flag.Bool("inject-init-containers", false,
+		"Mount Downward API volume and expose hugepages also in init and sidecar containers --inject-init-containers")
+	initFlags.networkResourcesMapFlag = flag.Bool("inject-network-resources-map", false,
+		"Expose the map of pod networks to their resource names and device plugin env vars --inject-network-resources-map")
+	initFlags.podValidationAction = flag.String("pod-validation-action", PodValidationActionDeny,
+		"Action of the validating webhook when pod resources don't match its networks: deny or warn --pod-validation-action")
+	initFlags.resourceConfigPathsFlag = flag.String("network-resource-name-config-paths", "",
+		"comma separated dot paths of the resource name in the net-attach-def CNI config, used when annotations don't define it --network-resource-name-config-paths")
+	initFlags.resourceNameKeysMode = flag.String("network-resource-name-keys-mode", ResourceNameKeysModeAll,
+		"Handling of net-attach-defs with more than one of the resource name keys: all, first-match or error-on-multiple --network-resource-name-keys-mode")
+	initFlags.draInjectionMode = flag.String("dra-injection-mode", DRAInjectionModeAdditional,
+		"Injection of resource claims of net-attach-defs referencing DRA devices: additional or replace --dra-injection-mode")
+	initFlags.podLevelResourcesMode = flag.String("pod-level-resources-mode", PodLevelResourcesModeContainer,
+		"Injection of network resources into pods with pod-level resources: container, pod or both, extended resources are always injected in the container --pod-level-resources-mode")
+	initFlags.missingNetAttachDefAction = flag.String("missing-net-attach-def-action", MissingNetAttachDefActionDeny,
+		"Action when a pod references net-attach-defs which don't exist: deny or gate. Gated pods are deleted once the net-attach-defs exist "+
+			"to be created again by their controller, pods without a controller stay gated and have to be created again --missing-net-attach-def-action")
+	initFlags.lookupFailurePolicy = flag.String("net-attach-def-lookup-failure-policy", LookupFailurePolicyDeny,
+		"Policy when a net-attach-def can't be retrieved: deny, admit or use-cached --net-attach-def-lookup-failure-policy")
+	initFlags.guaranteedQoSAction = flag.String("guaranteed-qos-action", GuaranteedQoSActionIgnore,
+		"Action when a pod requesting network resources won't have Guaranteed QoS: ignore, warn or deny --guaranteed-qos-action")
+	initFlags.hugepagesDownwardAPIMode = flag.String("hugepages-downward-api-mode", HugepagesDownwardAPIModeFiles,
+		"How hugepages are exposed when injectHugepageDownApi is enabled: files, env or both --hugepages-downward-api-mode")
+	initFlags.downwardAPIVolumeName = flag.String("downward-api-volume-name", types.DownwardAPIVolumeName,
+		"Name of the injected Downward API volume --downward-api-volume-name")
+	initFlags.downwardAPIMountPath = flag.String("downward-api-mount-path", types.DownwardAPIMountPath,
+		"Path at which the Downward API volume is mounted --downward-api-mount-path")
+	initFlags.downwardAPIContainers = flag.String("downward-api-containers", DownwardAPIContainersAll,
+		"Containers in which the Downward API volume is mounted: all or resources --downward-api-containers")
+	initFlags.downwardAPIItemsFlag = flag.String("downward-api-items", strings.Join(downwardAPIItems, ","),
+		"Comma separated items projected by the Downward API volume: labels, annotations, hugepages and network-resources-map --downward-api-items")
 
 	return &initFlags
 }
@@ -85,9 +238,137 @@ func (switches *ControlSwitches) InitControlSwitches() {
 	state = controlSwitchesStates{initial: *switches.resourcesHonorFlag, active: *switches.resourcesHonorFlag}
 	switches.configuration[enableHonorExistingResourcesKey] = state
 
+	state = controlSwitchesStates{initial: *switches.initContainersFlag, active: *switches.initContainersFlag}
+	switches.configuration[enableInitContainersKey] = state
+
+	state = controlSwitchesStates{initial: *switches.networkResourcesMapFlag, active: *switches.networkResourcesMapFlag}
+	switches.configuration[enableNetworkResourcesMapKey] = state
+
 	switches.resourceNameKeys = setResourceNameKeys(*switches.resourceNameKeysFlag)
+	switches.resourceConfigPaths = setResourceConfigPaths(*switches.resourceConfigPathsFlag)
 
 	switches.isValid = true
+
+	if action := *switches.podValidationAction; action != PodValidationActionDeny && action != PodValidationActionWarn {
+		glog.Errorf("invalid pod validation action %q, expected %s or %s", action, PodValidationActionDeny, PodValidationActionWarn)
+		switches.isValid = false
+	}
+
+	switch mode := *switches.resourceNameKeysMode; mode {
+	case ResourceNameKeysModeAll, ResourceNameKeysModeFirstMatch, ResourceNameKeysModeErrorOnMultiple:
+	default:
+		glog.Errorf("invalid resource name keys mode %q, expected %s, %s or %s", mode,
+			ResourceNameKeysModeAll, ResourceNameKeysModeFirstMatch, ResourceNameKeysModeErrorOnMultiple)
+		switches.isValid = false
+	}
+
+	if mode := *switches.draInjectionMode; mode != DRAInjectionModeAdditional && mode != DRAInjectionModeReplace {
+		glog.Errorf("invalid DRA injection mode %q, expected %s or %s", mode, DRAInjectionModeAdditional, DRAInjectionModeReplace)
+		switches.isValid = false
+	}
+
+	switch mode := *switches.podLevelResourcesMode; mode {
+	case PodLevelResourcesModeContainer, PodLevelResourcesModePod, PodLevelResourcesModeBoth:
+	default:
+		glog.Errorf("invalid pod-level resources mode %q, expected %s, %s or %s", mode,
+			PodLevelResourcesModeContainer, PodLevelResourcesModePod, PodLevelResourcesModeBoth)
+		switches.isValid = false
+	}
+
+	if action := *switches.missingNetAttachDefAction; action != MissingNetAttachDefActionDeny && action != MissingNetAttachDefActionGate {
+		glog.Errorf("invalid missing net-attach-def action %q, expected %s or %s", action, MissingNetAttachDefActionDeny,
+			MissingNetAttachDefActionGate)
+		switches.isValid = false
+	}
+
+	if !isValidLookupFailurePolicy(*switches.lookupFailurePolicy) {
+		glog.Errorf("invalid net-attach-def lookup failure policy %q, expected %s, %s or %s", *switches.lookupFailurePolicy,
+			LookupFailurePolicyDeny, LookupFailurePolicyAdmit, LookupFailurePolicyUseCached)
+		switches.isValid = false
+	}
+
+	if !IsValidGuaranteedQoSAction(*switches.guaranteedQoSAction) {
+		glog.Errorf("invalid Guaranteed QoS action %q, expected %s, %s or %s", *switches.guaranteedQoSAction,
+			GuaranteedQoSActionIgnore, GuaranteedQoSActionWarn, GuaranteedQoSActionDeny)
+		switches.isValid = false
+	}
+
+	switch mode := *switches.hugepagesDownwardAPIMode; mode {
+	case HugepagesDownwardAPIModeFiles, HugepagesDownwardAPIModeEnv, HugepagesDownwardAPIModeBoth:
+	default:
+		glog.Errorf("invalid hugepages Downward API mode %q, expected %s, %s or %s", mode,
+			HugepagesDownwardAPIModeFiles, HugepagesDownwardAPIModeEnv, HugepagesDownwardAPIModeBoth)
+		switches.isValid = false
+	}
+
+	if err := ValidateDownwardAPIVolumeName(*switches.downwardAPIVolumeName); err != nil {
+		glog.Error(err)
+		switches.isValid = false
+	}
+
+	if err := ValidateDownwardAPIMountPath(*switches.downwardAPIMountPath); err != nil {
+		glog.Error(err)
+		switches.isValid = false
+	}
+
+	if containers := *switches.downwardAPIContainers; containers != DownwardAPIContainersAll && containers != DownwardAPIContainersResources {
+		glog.Errorf("invalid Downward API containers %q, expected %s or %s", containers, DownwardAPIContainersAll,
+			DownwardAPIContainersResources)
+		switches.isValid = false
+	}
+
+	items, err := ParseDownwardAPIItems(*switches.downwardAPIItemsFlag)
+	if err != nil {
+		glog.Error(err)
+		switches.isValid = false
+	}
+	switches.downwardAPIItems = items
+}
+
+// ValidateDownwardAPIVolumeName returns error when the name can't be used as a volume name, also used to validate
+// the name requested by pods
+func ValidateDownwardAPIVolumeName(name string) error {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid Downward API volume name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ValidateDownwardAPIMountPath returns error when the path isn't a clean absolute path, also used to validate the
+// path requested by pods
+func ValidateDownwardAPIMountPath(mountPath string) error {
+	if !path.IsAbs(mountPath) || path.Clean(mountPath) != mountPath || mountPath == "/" {
+		return fmt.Errorf("invalid Downward API mount path %q, expected clean absolute path", mountPath)
+	}
+	return nil
+}
+
+// ParseDownwardAPIItems returns the items of the comma separated list, also used to parse the items requested by
+// pods. Empty list means no items.
+func ParseDownwardAPIItems(value string) ([]string, error) {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if !slices.Contains(downwardAPIItems, item) {
+			return nil, fmt.Errorf("invalid Downward API item %q, expected %s", item, strings.Join(downwardAPIItems, ", "))
+		}
+		if !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func isValidLookupFailurePolicy(policy string) bool {
+	return policy == LookupFailurePolicyDeny || policy == LookupFailurePolicyAdmit || policy == LookupFailurePolicyUseCached
+}
+
+// IsValidGuaranteedQoSAction returns true for the actions of the Guaranteed QoS check, also used to validate
+// the action requested by net-attach-defs
+func IsValidGuaranteedQoSAction(action string) bool {
+	return action == GuaranteedQoSActionIgnore || action == GuaranteedQoSActionWarn || action == GuaranteedQoSActionDeny
 }
 
 // setResourceNameKeys extracts resources from a string and add them to resourceNameKeys array
@@ -102,10 +383,66 @@ func setResourceNameKeys(keys string) []string {
 	return resourceNameKeys
 }
 
+// setResourceConfigPaths extracts non-empty JSON paths from a comma separated string
+func setResourceConfigPaths(paths string) []string {
+	var resourceConfigPaths []string
+
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			resourceConfigPaths = append(resourceConfigPaths, path)
+		}
+	}
+
+	return resourceConfigPaths
+}
+
 func (switches *ControlSwitches) GetResourceNameKeys() []string {
 	return switches.resourceNameKeys
 }
 
+// GetResourceConfigPaths returns JSON paths of the resource name in the net-attach-def CNI config,
+// empty when resource names are read only from annotations
+func (switches *ControlSwitches) GetResourceConfigPaths() []string {
+	return switches.resourceConfigPaths
+}
+
+// GetResourceNameKeysMode returns how net-attach-defs with more than one of the resource name keys are handled
+func (switches *ControlSwitches) GetResourceNameKeysMode() string {
+	return *switches.resourceNameKeysMode
+}
+
+// GetDRAInjectionMode returns if resource claims replace extended resources of net-attach-defs referencing DRA devices
+func (switches *ControlSwitches) GetDRAInjectionMode() string {
+	return *switches.draInjectionMode
+}
+
+// GetPodLevelResourcesMode returns where network resources are injected into pods with pod-level resources
+func (switches *ControlSwitches) GetPodLevelResourcesMode() string {
+	return *switches.podLevelResourcesMode
+}
+
+// GetMissingNetAttachDefAction returns if pods referencing net-attach-defs which don't exist are denied or gated
+func (switches *ControlSwitches) GetMissingNetAttachDefAction() string {
+	return *switches.missingNetAttachDefAction
+}
+
+// GetLookupFailurePolicy returns policy for net-attach-defs which can't be retrieved, the policy set for the
+// namespace in the ConfigMap overrides the command line argument
+func (switches *ControlSwitches) GetLookupFailurePolicy(namespace string) string {
+	switches.policiesMutex.RLock()
+	defer switches.policiesMutex.RUnlock()
+	if policy, exists := switches.lookupFailurePolicies[namespace]; exists {
+		return policy
+	}
+	return *switches.lookupFailurePolicy
+}
+
+// GetGuaranteedQoSAction returns action for pods requesting network resources which won't have Guaranteed QoS,
+// net-attach-defs may request a stricter action
+func (switches *ControlSwitches) GetGuaranteedQoSAction() string {
+	return *switches.guaranteedQoSAction
+}
+
 func (switches *ControlSwitches) IsHugePagedownAPIEnabled() bool {
 	return switches.configuration[enableHugePageDownAPIKey].active
 }
@@ -114,11 +451,51 @@ func (switches *ControlSwitches) IsHonorExistingResourcesEnabled() bool {
 	return switches.configuration[enableHonorExistingResourcesKey].active
 }
 
+// IsInitContainersEnabled returns true when Downward API volume and hugepages are injected also in init containers
+func (switches *ControlSwitches) IsInitContainersEnabled() bool {
+	return switches.configuration[enableInitContainersKey].active
+}
+
+// IsNetworkResourcesMapEnabled returns true when the map of pod networks to their resources is injected
+func (switches *ControlSwitches) IsNetworkResourcesMapEnabled() bool {
+	return switches.configuration[enableNetworkResourcesMapKey].active
+}
+
+// GetHugepagesDownwardAPIMode returns if hugepages are exposed as files of the Downward API volume, env vars or both
+func (switches *ControlSwitches) GetHugepagesDownwardAPIMode() string {
+	return *switches.hugepagesDownwardAPIMode
+}
+
+// GetDownwardAPIVolumeName returns name of the injected Downward API volume
+func (switches *ControlSwitches) GetDownwardAPIVolumeName() string {
+	return *switches.downwardAPIVolumeName
+}
+
+// GetDownwardAPIMountPath returns path at which the Downward API volume is mounted
+func (switches *ControlSwitches) GetDownwardAPIMountPath() string {
+	return *switches.downwardAPIMountPath
+}
+
+// GetDownwardAPIContainers returns in which containers the Downward API volume is mounted, all or resources
+func (switches *ControlSwitches) GetDownwardAPIContainers() string {
+	return *switches.downwardAPIContainers
+}
+
+// GetDownwardAPIItems returns items projected by the Downward API volume
+func (switches *ControlSwitches) GetDownwardAPIItems() []string {
+	return switches.downwardAPIItems
+}
+
+// GetPodValidationAction returns action of the validating webhook, deny or warn
+func (switches *ControlSwitches) GetPodValidationAction() string {
+	return *switches.podValidationAction
+}
+
 func (switches *ControlSwitches) IsResourcesNameEnabled() bool {
 	return len(*switches.resourceNameKeysFlag) > 0
 }
 
-// IsValid returns true when ControlSwitches structure was initialized, false otherwise
+// IsValid returns true when ControlSwitches structure was initialized with valid values, false otherwise
 func (switches *ControlSwitches) IsValid() bool {
 	return switches.isValid
 }
@@ -129,7 +506,26 @@ func (switches *ControlSwitches) GetAllFeaturesState() string {
 
 	output = fmt.Sprintf("HugePageInject: %t", switches.IsHugePagedownAPIEnabled())
 	output = output + " / " + fmt.Sprintf("HonorExistingResources: %t", switches.IsHonorExistingResourcesEnabled())
+	output = output + " / " + fmt.Sprintf("InitContainers: %t", switches.IsInitContainersEnabled())
+	output = output + " / " + fmt.Sprintf("NetworkResourcesMap: %t", switches.IsNetworkResourcesMapEnabled())
 	output = output + " / " + fmt.Sprintf("EnableResourceNames: %t", switches.IsResourcesNameEnabled())
+	output = output + " / " + fmt.Sprintf("ResourceNameKeysMode: %s", switches.GetResourceNameKeysMode())
+	output = output + " / " + fmt.Sprintf("PodValidationAction: %s", switches.GetPodValidationAction())
+	output = output + " / " + fmt.Sprintf("ResourceConfigPaths: %v", switches.GetResourceConfigPaths())
+	output = output + " / " + fmt.Sprintf("DRAInjectionMode: %s", switches.GetDRAInjectionMode())
+	output = output + " / " + fmt.Sprintf("PodLevelResourcesMode: %s", switches.GetPodLevelResourcesMode())
+	output = output + " / " + fmt.Sprintf("MissingNetAttachDefAction: %s", switches.GetMissingNetAttachDefAction())
+	output = output + " / " + fmt.Sprintf("LookupFailurePolicy: %s", *switches.lookupFailurePolicy)
+	output = output + " / " + fmt.Sprintf("GuaranteedQoSAction: %s", switches.GetGuaranteedQoSAction())
+	output = output + " / " + fmt.Sprintf("HugepagesDownwardAPIMode: %s", switches.GetHugepagesDownwardAPIMode())
+	output = output + " / " + fmt.Sprintf("DownwardAPIVolume: %s at %s in %s containers with items %v",
+		switches.GetDownwardAPIVolumeName(), switches.GetDownwardAPIMountPath(), switches.GetDownwardAPIContainers(),
+		switches.GetDownwardAPIItems())
+	switches.policiesMutex.RLock()
+	if len(switches.lookupFailurePolicies) > 0 {
+		output = output + " / " + fmt.Sprintf("NamespaceLookupFailurePolicies: %v", switches.lookupFailurePolicies)
+	}
+	switches.policiesMutex.RUnlock()
 
 	return output
 }
@@ -143,6 +539,14 @@ func (switches *ControlSwitches) setAllFeaturesToInitialState() {
 	state = switches.configuration[enableHonorExistingResourcesKey]
 	state.setActiveToInitialState()
 	switches.configuration[enableHonorExistingResourcesKey] = state
+
+	state = switches.configuration[enableInitContainersKey]
+	state.setActiveToInitialState()
+	switches.configuration[enableInitContainersKey] = state
+
+	state = switches.configuration[enableNetworkResourcesMapKey]
+	state.setActiveToInitialState()
+	switches.configuration[enableNetworkResourcesMapKey] = state
 }
 
 // setFeatureToState set given feature to the state defined in the map object
@@ -162,6 +566,12 @@ func (switches *ControlSwitches) setFeatureToState(featureName string, switchObj
 // :param controlSwitchesCm - Kubernetes ConfigMap with control switches definition
 func (switches *ControlSwitches) ProcessControlSwitchesConfigMap(controlSwitchesCm *corev1.ConfigMap) {
 	var err error
+	/* per namespace policies are replaced on every reload, they are removed with the ConfigMap or its key */
+	var rawPolicies json.RawMessage
+	defer func() {
+		switches.setLookupFailurePolicies(rawPolicies)
+	}()
+
 	if v, fileExists := controlSwitchesCm.Data[types.ConfigMapMainFileKey]; fileExists {
 		var obj map[string]json.RawMessage
 
@@ -171,6 +581,8 @@ func (switches *ControlSwitches) ProcessControlSwitchesConfigMap(controlSwitches
 			return
 		}
 
+		rawPolicies = obj[lookupFailurePoliciesKey]
+
 		if controlSwitches, mainExists := obj[controlSwitchesMainKey]; mainExists {
 			var switchObj map[string]bool
 
@@ -182,6 +594,8 @@ func (switches *ControlSwitches) ProcessControlSwitchesConfigMap(controlSwitches
 
 			switches.setFeatureToState(enableHugePageDownAPIKey, switchObj)
 			switches.setFeatureToState(enableHonorExistingResourcesKey, switchObj)
+			switches.setFeatureToState(enableInitContainersKey, switchObj)
+			switches.setFeatureToState(enableNetworkResourcesMapKey, switchObj)
 		} else {
 			glog.Warningf("Map does not contains [%s]", controlSwitchesMainKey)
 		}
@@ -189,3 +603,26 @@ func (switches *ControlSwitches) ProcessControlSwitchesConfigMap(controlSwitches
 		glog.Warningf("Map does not contains [%s]", types.ConfigMapMainFileKey)
 	}
 }
+
+// setLookupFailurePolicies sets per namespace lookup failure policies from the ConfigMap, invalid policies are
+// skipped and all overrides are removed when the key is missing
+func (switches *ControlSwitches) setLookupFailurePolicies(rawPolicies json.RawMessage) {
+	policies := make(map[string]string)
+	if rawPolicies != nil {
+		var policiesObj map[string]string
+		if err := json.Unmarshal(rawPolicies, &policiesObj); err != nil {
+			glog.Warningf("Unable to unmarshal [%s] from configmap, err: %v", lookupFailurePoliciesKey, err)
+		}
+		for namespace, policy := range policiesObj {
+			if !isValidLookupFailurePolicy(policy) {
+				glog.Errorf("invalid net-attach-def lookup failure policy %q of namespace %s", policy, namespace)
+				continue
+			}
+			policies[namespace] = policy
+		}
+	}
+
+	switches.policiesMutex.Lock()
+	defer switches.policiesMutex.Unlock()
+	switches.lookupFailurePolicies = policies
+}
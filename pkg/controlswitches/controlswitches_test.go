@@ -18,6 +18,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -219,6 +220,380 @@ var _ = Describe("Verify controlswitches package", func() {
 		})
 	})
 
+	Describe("Pod validation action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to deny", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetPodValidationAction()).Should(Equal(PodValidationActionDeny))
+		})
+
+		It("Accepts warn", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetPodValidationActionUnitTests(PodValidationActionWarn)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetPodValidationAction()).Should(Equal(PodValidationActionWarn))
+		})
+
+		It("Rejects unknown action", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetPodValidationActionUnitTests("ignore")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("Resource name keys mode", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to all", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetResourceNameKeysMode()).Should(Equal(ResourceNameKeysModeAll))
+			Expect(structure.GetAllFeaturesState()).Should(ContainSubstring("ResourceNameKeysMode: all"))
+		})
+
+		It("Accepts first-match", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetResourceNameKeysModeUnitTests(ResourceNameKeysModeFirstMatch)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetResourceNameKeysMode()).Should(Equal(ResourceNameKeysModeFirstMatch))
+		})
+
+		It("Rejects unknown mode", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetResourceNameKeysModeUnitTests("last-match")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("DRA injection mode", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to additional", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetDRAInjectionMode()).Should(Equal(DRAInjectionModeAdditional))
+		})
+
+		It("Accepts replace", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetDRAInjectionModeUnitTests(DRAInjectionModeReplace)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetDRAInjectionMode()).Should(Equal(DRAInjectionModeReplace))
+		})
+
+		It("Rejects unknown mode", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetDRAInjectionModeUnitTests("both")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("Pod-level resources mode", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to container", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetPodLevelResourcesMode()).Should(Equal(PodLevelResourcesModeContainer))
+		})
+
+		It("Accepts both", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetPodLevelResourcesModeUnitTests(PodLevelResourcesModeBoth)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetPodLevelResourcesMode()).Should(Equal(PodLevelResourcesModeBoth))
+		})
+
+		It("Rejects unknown mode", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetPodLevelResourcesModeUnitTests("node")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("Init containers", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Disabled by default", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsInitContainersEnabled()).Should(Equal(false))
+		})
+
+		It("Toggled by config map and reset to initial state", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetInitContainersUnitTests(true)
+			structure.InitControlSwitches()
+			Expect(structure.IsInitContainersEnabled()).Should(Equal(true))
+
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"features": {"enableInitContainers": false}}`},
+			})
+			Expect(structure.IsInitContainersEnabled()).Should(Equal(false))
+			Expect(structure.configuration[enableInitContainersKey].initial).Should(Equal(true))
+
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"features": {}}`},
+			})
+			Expect(structure.IsInitContainersEnabled()).Should(Equal(true))
+		})
+	})
+
+	Describe("Network resources map", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Disabled by default", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsNetworkResourcesMapEnabled()).Should(Equal(false))
+		})
+
+		It("Toggled by config map", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"features": {"enableNetworkResourcesMap": true}}`},
+			})
+			Expect(structure.IsNetworkResourcesMapEnabled()).Should(Equal(true))
+			Expect(structure.configuration[enableNetworkResourcesMapKey].initial).Should(Equal(false))
+		})
+	})
+
+	Describe("Missing net-attach-def action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to deny", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetMissingNetAttachDefAction()).Should(Equal(MissingNetAttachDefActionDeny))
+		})
+
+		It("Accepts gate", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetMissingNetAttachDefActionUnitTests(MissingNetAttachDefActionGate)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetMissingNetAttachDefAction()).Should(Equal(MissingNetAttachDefActionGate))
+		})
+
+		It("Rejects unknown action", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetMissingNetAttachDefActionUnitTests("retry")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("Net-attach-def lookup failure policy", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to deny", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetLookupFailurePolicy("default")).Should(Equal(LookupFailurePolicyDeny))
+		})
+
+		It("Rejects unknown policy", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetLookupFailurePolicyUnitTests("retry")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+
+		It("Overridden per namespace by config map", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetLookupFailurePolicyUnitTests(LookupFailurePolicyUseCached)
+			structure.InitControlSwitches()
+
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"lookupFailurePolicies": {"edge": "admit", "core": "deny", "lab": "ignore"}}`},
+			})
+			Expect(structure.GetLookupFailurePolicy("edge")).Should(Equal(LookupFailurePolicyAdmit))
+			Expect(structure.GetLookupFailurePolicy("core")).Should(Equal(LookupFailurePolicyDeny))
+			Expect(structure.GetLookupFailurePolicy("lab")).Should(Equal(LookupFailurePolicyUseCached))
+			Expect(structure.GetLookupFailurePolicy("default")).Should(Equal(LookupFailurePolicyUseCached))
+
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"features": {}}`},
+			})
+			Expect(structure.GetLookupFailurePolicy("edge")).Should(Equal(LookupFailurePolicyUseCached))
+		})
+
+		It("Restored when config map is removed", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+				Data: map[string]string{"config.json": `{"lookupFailurePolicies": {"edge": "admit"}}`},
+			})
+			Expect(structure.GetLookupFailurePolicy("edge")).Should(Equal(LookupFailurePolicyAdmit))
+
+			/* the API client returns an empty ConfigMap when it is not found */
+			structure.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{})
+			Expect(structure.GetLookupFailurePolicy("edge")).Should(Equal(LookupFailurePolicyDeny))
+		})
+	})
+
+	Describe("Guaranteed QoS action", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to ignore", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetGuaranteedQoSAction()).Should(Equal(GuaranteedQoSActionIgnore))
+		})
+
+		It("Accepts warn", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetGuaranteedQoSActionUnitTests(GuaranteedQoSActionWarn)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetGuaranteedQoSAction()).Should(Equal(GuaranteedQoSActionWarn))
+		})
+
+		It("Rejects unknown action", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetGuaranteedQoSActionUnitTests("enforce")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("Hugepages Downward API mode", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to files", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetHugepagesDownwardAPIMode()).Should(Equal(HugepagesDownwardAPIModeFiles))
+		})
+
+		It("Accepts env", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetHugepagesDownwardAPIModeUnitTests(HugepagesDownwardAPIModeEnv)
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetHugepagesDownwardAPIMode()).Should(Equal(HugepagesDownwardAPIModeEnv))
+		})
+
+		It("Rejects unknown mode", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetHugepagesDownwardAPIModeUnitTests("volume")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(false))
+		})
+	})
+
+	Describe("Downward API volume", func() {
+		AfterEach(func() {
+			structure = nil
+		})
+
+		It("Defaults to podnetinfo volume with all items in all containers", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetDownwardAPIVolumeName()).Should(Equal("podnetinfo"))
+			Expect(structure.GetDownwardAPIMountPath()).Should(Equal("/etc/podnetinfo"))
+			Expect(structure.GetDownwardAPIContainers()).Should(Equal(DownwardAPIContainersAll))
+			Expect(structure.GetDownwardAPIItems()).Should(Equal([]string{DownwardAPIItemLabels, DownwardAPIItemAnnotations,
+				DownwardAPIItemHugepages, DownwardAPIItemNetworkResourcesMap}))
+		})
+
+		It("Accepts custom volume", func() {
+			structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure.SetDownwardAPIUnitTests("nri-info", "/var/run/nri", DownwardAPIContainersResources, "hugepages, labels")
+			structure.InitControlSwitches()
+
+			Expect(structure.IsValid()).Should(Equal(true))
+			Expect(structure.GetDownwardAPIVolumeName()).Should(Equal("nri-info"))
+			Expect(structure.GetDownwardAPIMountPath()).Should(Equal("/var/run/nri"))
+			Expect(structure.GetDownwardAPIContainers()).Should(Equal(DownwardAPIContainersResources))
+			Expect(structure.GetDownwardAPIItems()).Should(Equal([]string{DownwardAPIItemHugepages, DownwardAPIItemLabels}))
+		})
+
+		DescribeTable("Rejects invalid values",
+			func(volumeName, mountPath, containers, items string) {
+				structure = SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+				structure.SetDownwardAPIUnitTests(volumeName, mountPath, containers, items)
+				structure.InitControlSwitches()
+
+				Expect(structure.IsValid()).Should(Equal(false))
+			},
+			Entry("volume name", "Pod_Net_Info", "/etc/podnetinfo", DownwardAPIContainersAll, "labels"),
+			Entry("relative mount path", "podnetinfo", "etc/podnetinfo", DownwardAPIContainersAll, "labels"),
+			Entry("root mount path", "podnetinfo", "/", DownwardAPIContainersAll, "labels"),
+			Entry("unclean mount path", "podnetinfo", "/etc/../podnetinfo", DownwardAPIContainersAll, "labels"),
+			Entry("containers", "podnetinfo", "/etc/podnetinfo", "first", "labels"),
+			Entry("items", "podnetinfo", "/etc/podnetinfo", DownwardAPIContainersAll, "labels,env"),
+		)
+	})
+
 	Describe("Process Control Switches config map", func() {
 		Context("Map without [features]", func() {
 			BeforeEach(func() {
@@ -20,12 +20,112 @@
 
 package controlswitches
 
+import (
+	"strings"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
 func SetupControlSwitchesUnitTests(downAPI, honor *bool, name *string) *ControlSwitches {
 	var initFlags ControlSwitches
 
 	initFlags.injectHugepageDownAPI = downAPI
 	initFlags.resourceNameKeysFlag = name
 	initFlags.resourcesHonorFlag = honor
+	initContainers := false
+	initFlags.initContainersFlag = &initContainers
+	networkResourcesMap := false
+	initFlags.networkResourcesMapFlag = &networkResourcesMap
+
+	podValidationAction := PodValidationActionDeny
+	initFlags.podValidationAction = &podValidationAction
+	resourceConfigPaths := ""
+	initFlags.resourceConfigPathsFlag = &resourceConfigPaths
+	resourceNameKeysMode := ResourceNameKeysModeAll
+	initFlags.resourceNameKeysMode = &resourceNameKeysMode
+	draInjectionMode := DRAInjectionModeAdditional
+	initFlags.draInjectionMode = &draInjectionMode
+	podLevelResourcesMode := PodLevelResourcesModeContainer
+	initFlags.podLevelResourcesMode = &podLevelResourcesMode
+	missingNetAttachDefAction := MissingNetAttachDefActionDeny
+	initFlags.missingNetAttachDefAction = &missingNetAttachDefAction
+	lookupFailurePolicy := LookupFailurePolicyDeny
+	initFlags.lookupFailurePolicy = &lookupFailurePolicy
+	guaranteedQoSAction := GuaranteedQoSActionIgnore
+	initFlags.guaranteedQoSAction = &guaranteedQoSAction
+	hugepagesDownwardAPIMode := HugepagesDownwardAPIModeFiles
+	initFlags.hugepagesDownwardAPIMode = &hugepagesDownwardAPIMode
+	initFlags.SetDownwardAPIUnitTests(types.DownwardAPIVolumeName, types.DownwardAPIMountPath, DownwardAPIContainersAll,
+		strings.Join(downwardAPIItems, ","))
 
 	return &initFlags
 }
+
+// SetPodValidationActionUnitTests sets action of the validating webhook, the value is checked by InitControlSwitches
+func (switches *ControlSwitches) SetPodValidationActionUnitTests(action string) {
+	switches.podValidationAction = &action
+}
+
+// SetResourceConfigPathsUnitTests sets comma separated JSON paths of the resource name in the CNI config,
+// to be called before InitControlSwitches
+func (switches *ControlSwitches) SetResourceConfigPathsUnitTests(paths string) {
+	switches.resourceConfigPathsFlag = &paths
+}
+
+// SetResourceNameKeysModeUnitTests sets handling of multiple resource name keys, the value is checked by InitControlSwitches
+func (switches *ControlSwitches) SetResourceNameKeysModeUnitTests(mode string) {
+	switches.resourceNameKeysMode = &mode
+}
+
+// SetDRAInjectionModeUnitTests sets injection mode of resource claims, the value is checked by InitControlSwitches
+func (switches *ControlSwitches) SetDRAInjectionModeUnitTests(mode string) {
+	switches.draInjectionMode = &mode
+}
+
+// SetPodLevelResourcesModeUnitTests sets injection mode of pods with pod-level resources, the value is checked by InitControlSwitches
+func (switches *ControlSwitches) SetPodLevelResourcesModeUnitTests(mode string) {
+	switches.podLevelResourcesMode = &mode
+}
+
+// SetInitContainersUnitTests sets initial state of the init containers feature, to be called before InitControlSwitches
+func (switches *ControlSwitches) SetInitContainersUnitTests(enabled bool) {
+	switches.initContainersFlag = &enabled
+}
+
+// SetNetworkResourcesMapUnitTests sets initial state of the network resources map feature, to be called before
+// InitControlSwitches
+func (switches *ControlSwitches) SetNetworkResourcesMapUnitTests(enabled bool) {
+	switches.networkResourcesMapFlag = &enabled
+}
+
+// SetMissingNetAttachDefActionUnitTests sets action for pods referencing missing net-attach-defs, the value is checked
+// by InitControlSwitches
+func (switches *ControlSwitches) SetMissingNetAttachDefActionUnitTests(action string) {
+	switches.missingNetAttachDefAction = &action
+}
+
+// SetLookupFailurePolicyUnitTests sets default policy for net-attach-defs which can't be retrieved, the value is
+// checked by InitControlSwitches
+func (switches *ControlSwitches) SetLookupFailurePolicyUnitTests(policy string) {
+	switches.lookupFailurePolicy = &policy
+}
+
+// SetGuaranteedQoSActionUnitTests sets action for pods which won't have Guaranteed QoS, the value is checked by
+// InitControlSwitches
+func (switches *ControlSwitches) SetGuaranteedQoSActionUnitTests(action string) {
+	switches.guaranteedQoSAction = &action
+}
+
+// SetHugepagesDownwardAPIModeUnitTests sets how hugepages are exposed, the value is checked by InitControlSwitches
+func (switches *ControlSwitches) SetHugepagesDownwardAPIModeUnitTests(mode string) {
+	switches.hugepagesDownwardAPIMode = &mode
+}
+
+// SetDownwardAPIUnitTests sets name, mount path, containers and comma separated items of the Downward API volume,
+// the values are checked by InitControlSwitches
+func (switches *ControlSwitches) SetDownwardAPIUnitTests(volumeName, mountPath, containers, items string) {
+	switches.downwardAPIVolumeName = &volumeName
+	switches.downwardAPIMountPath = &mountPath
+	switches.downwardAPIContainers = &containers
+	switches.downwardAPIItemsFlag = &items
+}
@@ -102,37 +102,73 @@ func createMutatingWebhookConfiguration(certificate []byte, failurePolicyStr str
 	configName := strings.Join([]string{prefix, "mutating-config"}, "-")
 	serviceName := strings.Join([]string{prefix, "service"}, "-")
 	removeMutatingWebhookIfExists(configName)
-	var failurePolicy arv1.FailurePolicyType
-	if strings.EqualFold(strings.TrimSpace(failurePolicyStr), "Ignore") {
-		failurePolicy = arv1.Ignore
-	} else if strings.EqualFold(strings.TrimSpace(failurePolicyStr), "Fail") {
-		failurePolicy = arv1.Fail
-	} else {
-		return errors.New("unknown failure policy type")
+	failurePolicy, err := parseFailurePolicy(failurePolicyStr)
+	if err != nil {
+		return err
 	}
-	sideEffects := arv1.SideEffectClassNone
+	/* templates of resource claims are created for pods referencing DRA device classes, but never on dry run */
+	sideEffects := arv1.SideEffectClassNoneOnDryRun
 	path := "/mutate"
-	namespaces := []string{"kube-system"}
-	if namespace != "kube-system" {
-		namespaces = append(namespaces, namespace)
-	}
-	namespaceSelector := metav1.LabelSelector{
-		MatchExpressions: []metav1.LabelSelectorRequirement{
+	namespaceSelector := getNamespaceSelector()
+	configuration := &arv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: configName,
+			Labels: map[string]string{
+				"app": prefix,
+			},
+		},
+		Webhooks: []arv1.MutatingWebhook{
 			{
-				Key:      "kubernetes.io/metadata.name",
-				Operator: metav1.LabelSelectorOpNotIn,
-				Values:   namespaces,
+				Name: configName + ".k8s.cni.cncf.io",
+				ClientConfig: arv1.WebhookClientConfig{
+					CABundle: certificate,
+					Service: &arv1.ServiceReference{
+						Namespace: namespace,
+						Name:      serviceName,
+						Path:      &path,
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				AdmissionReviewVersions: []string{"v1"},
+				SideEffects:             &sideEffects,
+				NamespaceSelector:       &namespaceSelector,
+				Rules: []arv1.RuleWithOperations{
+					{
+						Operations: []arv1.OperationType{arv1.Create},
+						Rule: arv1.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"pods"},
+						},
+					},
+				},
 			},
 		},
 	}
-	configuration := &arv1.MutatingWebhookConfiguration{
+	_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Create(context.TODO(), configuration, metav1.CreateOptions{})
+	return err
+}
+
+func createValidatingWebhookConfiguration(certificate []byte, failurePolicyStr string) error {
+	configName := strings.Join([]string{prefix, "validating-config"}, "-")
+	serviceName := strings.Join([]string{prefix, "service"}, "-")
+	removeValidatingWebhookIfExists(configName)
+	failurePolicy, err := parseFailurePolicy(failurePolicyStr)
+	if err != nil {
+		return err
+	}
+	sideEffects := arv1.SideEffectClassNone
+	path := "/validate"
+	netAttachDefPath := "/validate-net-attach-def"
+	namespaceSelector := getNamespaceSelector()
+	configuration := &arv1.ValidatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: configName,
 			Labels: map[string]string{
 				"app": prefix,
 			},
 		},
-		Webhooks: []arv1.MutatingWebhook{
+		Webhooks: []arv1.ValidatingWebhook{
 			{
 				Name: configName + ".k8s.cni.cncf.io",
 				ClientConfig: arv1.WebhookClientConfig{
@@ -158,12 +194,63 @@ func createMutatingWebhookConfiguration(certificate []byte, failurePolicyStr str
 					},
 				},
 			},
+			{
+				Name: strings.Join([]string{prefix, "net-attach-def-validating-config"}, "-") + ".k8s.cni.cncf.io",
+				ClientConfig: arv1.WebhookClientConfig{
+					CABundle: certificate,
+					Service: &arv1.ServiceReference{
+						Namespace: namespace,
+						Name:      serviceName,
+						Path:      &netAttachDefPath,
+					},
+				},
+				FailurePolicy:           &failurePolicy,
+				AdmissionReviewVersions: []string{"v1"},
+				SideEffects:             &sideEffects,
+				// net-attach-defs are referenced across namespaces, so those in excluded namespaces are validated as well
+				Rules: []arv1.RuleWithOperations{
+					{
+						Operations: []arv1.OperationType{arv1.Create, arv1.Update},
+						Rule: arv1.Rule{
+							APIGroups:   []string{"k8s.cni.cncf.io"},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"network-attachment-definitions"},
+						},
+					},
+				},
+			},
 		},
 	}
-	_, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Create(context.TODO(), configuration, metav1.CreateOptions{})
+	_, err = clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Create(context.TODO(), configuration, metav1.CreateOptions{})
 	return err
 }
 
+func parseFailurePolicy(failurePolicyStr string) (arv1.FailurePolicyType, error) {
+	if strings.EqualFold(strings.TrimSpace(failurePolicyStr), "Ignore") {
+		return arv1.Ignore, nil
+	} else if strings.EqualFold(strings.TrimSpace(failurePolicyStr), "Fail") {
+		return arv1.Fail, nil
+	}
+	return "", errors.New("unknown failure policy type")
+}
+
+// getNamespaceSelector excludes kube-system and the namespace of the injector from the webhooks
+func getNamespaceSelector() metav1.LabelSelector {
+	namespaces := []string{"kube-system"}
+	if namespace != "kube-system" {
+		namespaces = append(namespaces, namespace)
+	}
+	return metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{
+				Key:      "kubernetes.io/metadata.name",
+				Operator: metav1.LabelSelectorOpNotIn,
+				Values:   namespaces,
+			},
+		},
+	}
+}
+
 func createService() error {
 	serviceName := strings.Join([]string{prefix, "service"}, "-")
 	removeServiceIfExists(serviceName)
@@ -214,8 +301,20 @@ func removeMutatingWebhookIfExists(configName string) {
 	}
 }
 
-// Install creates resources required by mutating admission webhook
-func Install(k8sNamespace, namePrefix, failurePolicy string) {
+func removeValidatingWebhookIfExists(configName string) {
+	config, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), configName, metav1.GetOptions{})
+	if config != nil && err == nil {
+		glog.Infof("validating webhook %s already exists, removing it first", configName)
+		err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(context.TODO(), configName, metav1.DeleteOptions{})
+		if err != nil {
+			glog.Errorf("error trying to remove validating webhook configuration: %s", err)
+		}
+		glog.Infof("validating webhook configuration %s removed", configName)
+	}
+}
+
+// Install creates resources required by mutating admission webhook and optionally by validating admission webhook
+func Install(k8sNamespace, namePrefix, failurePolicy string, enableValidation bool) {
 	/* setup Kubernetes API client */
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -276,6 +375,14 @@ func Install(k8sNamespace, namePrefix, failurePolicy string) {
 	}
 	glog.Infof("mutating webhook configuration successfully created")
 
+	if enableValidation {
+		err = createValidatingWebhookConfiguration(caCertificate, failurePolicy)
+		if err != nil {
+			glog.Fatalf("error creating validating webhook configuration: %s", err)
+		}
+		glog.Infof("validating webhook configuration successfully created")
+	}
+
 	/* create service */
 	err = createService()
 	if err != nil {
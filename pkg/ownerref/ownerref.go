@@ -0,0 +1,194 @@
+package ownerref
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	uidIndex = "uid"
+	// apiLookupTimeout limits the API server lookup of a single owner, the admission request has to be answered
+	// before the webhook timeout of the API server
+	apiLookupTimeout = 3 * time.Second
+	// apiLookupCacheSize and apiLookupCacheTTL bound the cache of owners retrieved from the API server, the
+	// namespace of an object never changes so entries only expire to free memory
+	apiLookupCacheSize = 1024
+	apiLookupCacheTTL  = 10 * time.Minute
+	// forbiddenResourceTTL is how long resources which the service account may not list are not listed again
+	forbiddenResourceTTL = time.Minute
+)
+
+// builtInOwners resources of the built-in pod controllers which are watched by the informers
+var builtInOwners = map[schema.GroupKind]schema.GroupVersionResource{
+	{Group: "apps", Kind: "ReplicaSet"}:        {Group: "apps", Version: "v1", Resource: "replicasets"},
+	{Group: "apps", Kind: "DaemonSet"}:         {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	{Group: "apps", Kind: "StatefulSet"}:       {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	{Group: "", Kind: "ReplicationController"}: {Group: "", Version: "v1", Resource: "replicationcontrollers"},
+	{Group: "batch", Kind: "Job"}:              {Group: "batch", Version: "v1", Resource: "jobs"},
+}
+
+// OwnerResolverService resolves namespaces of the objects referenced by owner references
+type OwnerResolverService interface {
+	Start()
+	Stop()
+	GetNamespace(ctx context.Context, ownerRef metav1.OwnerReference) (string, error)
+}
+
+// OwnerResolver keeps metadata informers of the built-in pod controllers indexed by UID, owners of other kinds
+// are looked up through the metadata client
+type OwnerResolver struct {
+	factory        metadatainformer.SharedInformerFactory
+	indexers       map[schema.GroupKind]cache.Indexer
+	metadataClient metadata.Interface
+	mapper         meta.RESTMapper
+	// namespaces namespaces of the owners retrieved from the API server indexed by UID
+	namespaces *utilcache.LRUExpireCache
+	// forbidden resources which the service account may not list
+	forbidden *utilcache.LRUExpireCache
+	stopper   chan struct{}
+}
+
+// Create returns resolver which has to be started before use, the informers keep only metadata of the owners.
+// The mapper is used for owner kinds which are not watched by the informers.
+func Create(metadataClient metadata.Interface, mapper meta.RESTMapper) OwnerResolverService {
+	factory := metadatainformer.NewSharedInformerFactory(metadataClient, 0)
+	indexers := make(map[schema.GroupKind]cache.Indexer, len(builtInOwners))
+	for groupKind, resource := range builtInOwners {
+		informer := factory.ForResource(resource).Informer()
+		if err := informer.AddIndexers(cache.Indexers{uidIndex: indexByUID}); err != nil {
+			glog.Fatalf("error adding UID index to %s informer: %v", groupKind, err)
+		}
+		indexers[groupKind] = informer.GetIndexer()
+	}
+
+	return &OwnerResolver{
+		factory:        factory,
+		indexers:       indexers,
+		metadataClient: metadataClient,
+		mapper:         mapper,
+		namespaces:     utilcache.NewLRUExpireCache(apiLookupCacheSize),
+		forbidden:      utilcache.NewLRUExpireCache(len(builtInOwners) + 16),
+		stopper:        make(chan struct{}),
+	}
+}
+
+func indexByUID(obj interface{}) ([]string, error) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(object.GetUID())}, nil
+}
+
+// Start starts the informers and waits until their caches are synced
+func (r *OwnerResolver) Start() {
+	glog.Infof("starting owner reference informers")
+	r.factory.Start(r.stopper)
+	for informerType, synced := range r.factory.WaitForCacheSync(r.stopper) {
+		if !synced {
+			glog.Errorf("cache of %v informer is not synced", informerType)
+		}
+	}
+}
+
+// Stop teardown the informers
+func (r *OwnerResolver) Stop() {
+	close(r.stopper)
+	r.factory.Shutdown()
+}
+
+// GetNamespace returns namespace of the owner, owners which are not in the informer caches yet, e.g. because
+// they were created right before the pod, and owners of other kinds are retrieved from the API server. Owners
+// of other kinds are listed cluster wide, the lookup fails with Forbidden error when the service account isn't
+// allowed to list them, such resources are not listed again for a while
+func (r *OwnerResolver) GetNamespace(ctx context.Context, ownerRef metav1.OwnerReference) (string, error) {
+	groupVersion, err := schema.ParseGroupVersion(ownerRef.APIVersion)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid API version of owner %s %s", ownerRef.Kind, ownerRef.Name)
+	}
+	groupKind := schema.GroupKind{Group: groupVersion.Group, Kind: ownerRef.Kind}
+
+	if indexer, exists := r.indexers[groupKind]; exists {
+		objects, err := indexer.ByIndex(uidIndex, string(ownerRef.UID))
+		if err != nil {
+			return "", err
+		}
+		for _, obj := range objects {
+			if object, err := meta.Accessor(obj); err == nil && object.GetName() == ownerRef.Name {
+				return object.GetNamespace(), nil
+			}
+		}
+		glog.Infof("owner %s %s not found in the informer cache, retrieving it from api server", ownerRef.Kind, ownerRef.Name)
+	}
+
+	return r.getNamespaceFromAPI(ctx, groupVersion.WithKind(ownerRef.Kind), ownerRef)
+}
+
+func (r *OwnerResolver) getNamespaceFromAPI(ctx context.Context, gvk schema.GroupVersionKind, ownerRef metav1.OwnerReference) (string, error) {
+	if r.metadataClient == nil || r.mapper == nil {
+		return "", fmt.Errorf("owner kind %s is not supported", gvk.Kind)
+	}
+	if namespace, exists := r.namespaces.Get(ownerRef.UID); exists {
+		return namespace.(string), nil
+	}
+
+	mapping, err := r.getRESTMapping(gvk)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not map owner kind %s", gvk)
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return "", fmt.Errorf("owner %s %s is not namespaced", gvk.Kind, ownerRef.Name)
+	}
+
+	if _, forbidden := r.forbidden.Get(mapping.Resource); forbidden {
+		return "", apierrors.NewForbidden(mapping.Resource.GroupResource(), ownerRef.Name,
+			fmt.Errorf("listing %s was recently forbidden", mapping.Resource))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, apiLookupTimeout)
+	defer cancel()
+	/* owners can't be listed by UID, name is the most selective field supported by every resource */
+	owners, err := r.metadataClient.Resource(mapping.Resource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", ownerRef.Name).String(),
+	})
+	if apierrors.IsForbidden(err) {
+		r.forbidden.Add(mapping.Resource, struct{}{}, forbiddenResourceTTL)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "could not list %s", mapping.Resource)
+	}
+	for _, owner := range owners.Items {
+		if owner.UID == ownerRef.UID {
+			r.namespaces.Add(ownerRef.UID, owner.Namespace, apiLookupCacheTTL)
+			return owner.Namespace, nil
+		}
+	}
+
+	return "", fmt.Errorf("owner %s %s with UID %s not found", gvk.Kind, ownerRef.Name, ownerRef.UID)
+}
+
+// getRESTMapping refreshes discovery information once when the kind is unknown, e.g. because its CRD
+// was installed after the mapper was populated
+func (r *OwnerResolver) getRESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		if resettable, ok := r.mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	return mapping, err
+}
@@ -0,0 +1,13 @@
+package ownerref
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOwnerRef(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OwnerRef Suite")
+}
@@ -0,0 +1,121 @@
+package ownerref
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Owner resolver", func() {
+	var resolver OwnerResolverService
+
+	fooGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Foo"}
+	barGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Bar"}
+
+	newPartialObjectMetadata := func(gvk schema.GroupVersionKind, namespace, name, uid string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind},
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: k8stypes.UID(uid)},
+		}
+	}
+
+	BeforeEach(func() {
+		scheme := metadatafake.NewTestScheme()
+		Expect(metav1.AddMetaToScheme(scheme)).To(Succeed())
+		metadataClient := metadatafake.NewSimpleMetadataClient(scheme,
+			newPartialObjectMetadata(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), "ns1", "rs", "rs-uid"),
+			newPartialObjectMetadata(batchv1.SchemeGroupVersion.WithKind("Job"), "ns2", "job", "job-uid"),
+			newPartialObjectMetadata(fooGVK, "ns3", "foo", "foo-uid"),
+			newPartialObjectMetadata(fooGVK, "ns4", "foo", "other-foo-uid"),
+			newPartialObjectMetadata(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), "ns5", "new-rs", "new-rs-uid"),
+		)
+
+		mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{fooGVK.GroupVersion(), appsv1.SchemeGroupVersion})
+		mapper.Add(fooGVK, meta.RESTScopeNamespace)
+		mapper.Add(barGVK, meta.RESTScopeRoot)
+		mapper.Add(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), meta.RESTScopeNamespace)
+
+		resolver = Create(metadataClient, mapper)
+		resolver.Start()
+	})
+
+	AfterEach(func() {
+		resolver.Stop()
+	})
+
+	expectNamespace := func(ownerRef metav1.OwnerReference, expectedNamespace string, shouldFail bool) {
+		namespace, err := resolver.GetNamespace(context.Background(), ownerRef)
+		if shouldFail {
+			Expect(err).To(HaveOccurred())
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		Expect(namespace).To(Equal(expectedNamespace))
+	}
+
+	It("should find built-in owners in the informer cache", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs", UID: "rs-uid"}, "ns1", false)
+	})
+
+	It("should find jobs in the informer cache", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: "job", UID: "job-uid"}, "ns2", false)
+	})
+
+	It("should fall back to the API server when built-in owner is not cached yet", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "new-rs", UID: "new-rs-uid"}, "ns5", false)
+	})
+
+	It("should find owners of other kinds by name and UID", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Foo", Name: "foo", UID: "foo-uid"}, "ns3", false)
+		expectNamespace(metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Foo", Name: "foo", UID: "other-foo-uid"}, "ns4", false)
+	})
+
+	It("should cache owners retrieved from the API server", func() {
+		ownerRef := metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Foo", Name: "foo", UID: "foo-uid"}
+		expectNamespace(ownerRef, "ns3", false)
+		resolver.(*OwnerResolver).metadataClient = metadatafake.NewSimpleMetadataClient(metadatafake.NewTestScheme())
+		expectNamespace(ownerRef, "ns3", false)
+	})
+
+	It("should not list forbidden resources again", func() {
+		lists := 0
+		metadataClient := metadatafake.NewSimpleMetadataClient(metadatafake.NewTestScheme())
+		metadataClient.PrependReactor("list", "foos", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lists++
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "example.com", Resource: "foos"}, "", errors.New("no RBAC"))
+		})
+		resolver.(*OwnerResolver).metadataClient = metadataClient
+
+		ownerRef := metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Foo", Name: "foo", UID: "foo-uid"}
+		for i := 0; i < 2; i++ {
+			_, err := resolver.GetNamespace(context.Background(), ownerRef)
+			Expect(apierrors.IsForbidden(errors.Cause(err))).To(BeTrue())
+		}
+		Expect(lists).To(Equal(1))
+	})
+
+	It("should fail when owner with the UID doesn't exist", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Foo", Name: "foo", UID: "unknown-uid"}, "", true)
+	})
+
+	It("should fail for unknown kinds", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Baz", Name: "baz", UID: "baz-uid"}, "", true)
+	})
+
+	It("should fail for cluster scoped owners", func() {
+		expectNamespace(metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Bar", Name: "bar", UID: "bar-uid"}, "", true)
+	})
+})
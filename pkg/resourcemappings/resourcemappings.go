@@ -0,0 +1,148 @@
+package resourcemappings
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+const (
+	resourceNameMappingsMainKey = "resource-name-mappings"
+)
+
+// ResourceNameMapping rewrites resource name found on net-attach-defs to one or more resource names
+type ResourceNameMapping struct {
+	// From resource name used by net-attach-defs
+	From string `json:"from"`
+	// To resource names requested instead, every one of them with the count of the From resource
+	To []string `json:"to"`
+	// Namespaces of pods to which the mapping applies, all namespaces when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// ResourceNameMappings resource name mappings loaded from the NRI ConfigMap
+type ResourceNameMappings struct {
+	sync.Mutex
+	Mappings []ResourceNameMapping
+}
+
+// CreateResourceNameMappingsStructure returns empty ResourceNameMappings structure
+func CreateResourceNameMappingsStructure() *ResourceNameMappings {
+	return &ResourceNameMappings{}
+}
+
+// SetResourceNameMappings replaces resource name mappings with those defined in the ConfigMap
+func (resourceMappings *ResourceNameMappings) SetResourceNameMappings(mappingsCm *corev1.ConfigMap) {
+	var mappings []ResourceNameMapping
+
+	if v, fileExists := mappingsCm.Data[types.ConfigMapMainFileKey]; fileExists {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(v), &obj); err != nil {
+			glog.Warningf("Error during json unmarshal of main: %v", err)
+			return
+		}
+
+		if rawMappings, mainExists := obj[resourceNameMappingsMainKey]; mainExists {
+			var mappingsObj []ResourceNameMapping
+			if err := json.Unmarshal(rawMappings, &mappingsObj); err != nil {
+				glog.Warningf("Error during json unmarshal of resource name mappings: %v", err)
+				return
+			}
+			for _, mapping := range mappingsObj {
+				if !isValidMapping(mapping) {
+					glog.Errorf("Invalid resource name mapping: %+v, from and to resource names are required", mapping)
+					continue
+				}
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+
+	// lock for writing
+	resourceMappings.Lock()
+	defer resourceMappings.Unlock()
+
+	if !reflect.DeepEqual(resourceMappings.Mappings, mappings) {
+		glog.Infof("Setting resource name mappings: %+v", mappings)
+		resourceMappings.Mappings = mappings
+	}
+}
+
+func isValidMapping(mapping ResourceNameMapping) bool {
+	if strings.TrimSpace(mapping.From) == "" || len(mapping.To) == 0 {
+		return false
+	}
+	for _, to := range mapping.To {
+		if strings.TrimSpace(to) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply rewrites the resource requests of a pod in the given namespace. Mappings scoped to the namespace take
+// precedence over mappings for all namespaces. Resource names are mapped only once, mapped names are not mapped again.
+func (resourceMappings *ResourceNameMappings) Apply(namespace string, resourceRequests map[string]int64) map[string]int64 {
+	// lock for reading
+	resourceMappings.Lock()
+	defer resourceMappings.Unlock()
+
+	if len(resourceMappings.Mappings) == 0 {
+		return resourceRequests
+	}
+
+	mappedRequests := make(map[string]int64, len(resourceRequests))
+	for resourceName, count := range resourceRequests {
+		mapping := resourceMappings.find(namespace, resourceName)
+		if mapping == nil {
+			mappedRequests[resourceName] += count
+			continue
+		}
+		glog.Infof("resource '%s' is mapped to %v for namespace %s", resourceName, mapping.To, namespace)
+		for _, to := range mapping.To {
+			mappedRequests[to] += count
+		}
+	}
+	return mappedRequests
+}
+
+func (resourceMappings *ResourceNameMappings) find(namespace, resourceName string) *ResourceNameMapping {
+	var global *ResourceNameMapping
+	for i, mapping := range resourceMappings.Mappings {
+		if mapping.From != resourceName {
+			continue
+		}
+		if len(mapping.Namespaces) == 0 {
+			if global == nil {
+				global = &resourceMappings.Mappings[i]
+			}
+		} else if slices.Contains(mapping.Namespaces, namespace) {
+			return &resourceMappings.Mappings[i]
+		}
+	}
+	return global
+}
+
+// GetMappedResourceNames returns all resource names which mappings rewrite resources to
+func (resourceMappings *ResourceNameMappings) GetMappedResourceNames() []string {
+	// lock for reading
+	resourceMappings.Lock()
+	defer resourceMappings.Unlock()
+
+	var resourceNames []string
+	for _, mapping := range resourceMappings.Mappings {
+		for _, to := range mapping.To {
+			if !slices.Contains(resourceNames, to) {
+				resourceNames = append(resourceNames, to)
+			}
+		}
+	}
+	return resourceNames
+}
@@ -0,0 +1,13 @@
+package resourcemappings
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestResourceMappings(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ResourceMappings Suite")
+}
@@ -0,0 +1,103 @@
+package resourcemappings
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResourceNameMappings", func() {
+	DescribeTable("Set resource name mappings",
+		func(config string, out []ResourceNameMapping) {
+			mappings := CreateResourceNameMappingsStructure()
+			mappings.SetResourceNameMappings(&corev1.ConfigMap{Data: map[string]string{types.ConfigMapMainFileKey: config}})
+			Expect(mappings.Mappings).Should(Equal(out))
+		},
+		Entry("no mappings key", `{"features": {}}`, nil),
+		Entry("invalid json", `{"resource-name-mappings": {`, nil),
+		Entry("valid mappings",
+			`{"resource-name-mappings": [
+				{"from": "intel.com/old", "to": ["intel.com/new"]},
+				{"from": "intel.com/bond", "to": ["intel.com/pf_a", "intel.com/pf_b"], "namespaces": ["ns1"]}
+			]}`,
+			[]ResourceNameMapping{
+				{From: "intel.com/old", To: []string{"intel.com/new"}},
+				{From: "intel.com/bond", To: []string{"intel.com/pf_a", "intel.com/pf_b"}, Namespaces: []string{"ns1"}},
+			}),
+		Entry("invalid mappings are skipped",
+			`{"resource-name-mappings": [
+				{"from": "", "to": ["intel.com/new"]},
+				{"from": "intel.com/old"},
+				{"from": "intel.com/old", "to": [" "]},
+				{"from": "intel.com/old", "to": ["intel.com/new"]}
+			]}`,
+			[]ResourceNameMapping{
+				{From: "intel.com/old", To: []string{"intel.com/new"}},
+			}),
+	)
+
+	It("removes mappings missing in updated ConfigMap", func() {
+		mappings := CreateResourceNameMappingsStructure()
+		mappings.SetResourceNameMappings(&corev1.ConfigMap{Data: map[string]string{
+			types.ConfigMapMainFileKey: `{"resource-name-mappings": [{"from": "intel.com/old", "to": ["intel.com/new"]}]}`}})
+		Expect(mappings.Mappings).Should(HaveLen(1))
+		mappings.SetResourceNameMappings(&corev1.ConfigMap{Data: map[string]string{}})
+		Expect(mappings.Mappings).Should(BeEmpty())
+	})
+
+	DescribeTable("Apply resource name mappings",
+		func(mappings []ResourceNameMapping, namespace string, in, out map[string]int64) {
+			resourceMappings := CreateResourceNameMappingsStructure()
+			resourceMappings.Mappings = mappings
+			Expect(resourceMappings.Apply(namespace, in)).Should(Equal(out))
+		},
+		Entry("no mappings", nil, "default",
+			map[string]int64{"intel.com/old": 1},
+			map[string]int64{"intel.com/old": 1}),
+		Entry("rename",
+			[]ResourceNameMapping{{From: "intel.com/old", To: []string{"intel.com/new"}}}, "default",
+			map[string]int64{"intel.com/old": 2, "intel.com/other": 1},
+			map[string]int64{"intel.com/new": 2, "intel.com/other": 1}),
+		Entry("split copies the count",
+			[]ResourceNameMapping{{From: "intel.com/bond", To: []string{"intel.com/pf_a", "intel.com/pf_b"}}}, "default",
+			map[string]int64{"intel.com/bond": 2},
+			map[string]int64{"intel.com/pf_a": 2, "intel.com/pf_b": 2}),
+		Entry("mapped name merges with requested name",
+			[]ResourceNameMapping{{From: "intel.com/old", To: []string{"intel.com/new"}}}, "default",
+			map[string]int64{"intel.com/old": 1, "intel.com/new": 1},
+			map[string]int64{"intel.com/new": 2}),
+		Entry("mapped names are not mapped again",
+			[]ResourceNameMapping{
+				{From: "intel.com/a", To: []string{"intel.com/b"}},
+				{From: "intel.com/b", To: []string{"intel.com/c"}},
+			}, "default",
+			map[string]int64{"intel.com/a": 1},
+			map[string]int64{"intel.com/b": 1}),
+		Entry("namespace scoped mapping takes precedence",
+			[]ResourceNameMapping{
+				{From: "intel.com/old", To: []string{"intel.com/new"}},
+				{From: "intel.com/old", To: []string{"intel.com/canary"}, Namespaces: []string{"ns1"}},
+			}, "ns1",
+			map[string]int64{"intel.com/old": 1},
+			map[string]int64{"intel.com/canary": 1}),
+		Entry("namespace scoped mapping is ignored in other namespaces",
+			[]ResourceNameMapping{
+				{From: "intel.com/old", To: []string{"intel.com/canary"}, Namespaces: []string{"ns1"}},
+			}, "ns2",
+			map[string]int64{"intel.com/old": 1},
+			map[string]int64{"intel.com/old": 1}),
+	)
+
+	It("returns mapped resource names without duplicates", func() {
+		mappings := CreateResourceNameMappingsStructure()
+		mappings.Mappings = []ResourceNameMapping{
+			{From: "intel.com/old", To: []string{"intel.com/new"}},
+			{From: "intel.com/bond", To: []string{"intel.com/new", "intel.com/pf_b"}},
+		}
+		Expect(mappings.GetMappedResourceNames()).Should(Equal([]string{"intel.com/new", "intel.com/pf_b"}))
+	})
+})
@@ -15,6 +15,8 @@
 package cache
 
 import (
+	"context"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -23,123 +25,551 @@ import (
 	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned"
 	"github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/informers/externalversions"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
 )
 
+const (
+	// fallbackEntryTTL is how long net-attach-defs retrieved from the API server are kept, the informer
+	// normally delivers them within this time
+	fallbackEntryTTL = 30 * time.Second
+	// missingEntryTTL is how long net-attach-defs which don't exist are not looked up again
+	missingEntryTTL = 5 * time.Second
+	// informerCheckInterval is how often progress of the informers is checked, watch bookmarks move their
+	// resource version also when net-attach-defs don't change
+	informerCheckInterval = 10 * time.Second
+)
+
+var netAttachDefResource = cniv1.SchemeGroupVersion.WithResource("network-attachment-definitions")
+
+// cacheEntry holds a net-attach-def or records that it doesn't exist when netAttachDef is nil,
+// entries from the informer never expire
+type cacheEntry struct {
+	netAttachDef *cniv1.NetworkAttachmentDefinition
+	expiresAt    time.Time
+}
+
+// informerState tracks whether an informer is in sync with the API server, it is healthy from its initial
+// list until a watch error and again once its resource version moves
+type informerState struct {
+	informer        cache.SharedIndexInformer
+	healthy         bool
+	lastSync        time.Time
+	resourceVersion string
+}
+
+// CacheStats counts lookups of net-attach-defs since the cache was created
+type CacheStats struct {
+	// Hits lookups served by the cache, including net-attach-defs which were retrieved from the API server before
+	Hits uint64
+	// Misses lookups which needed the API server
+	Misses uint64
+	// FallbackHits misses for which the API server returned the net-attach-def
+	FallbackHits uint64
+	// NegativeHits lookups served by entries of net-attach-defs which don't exist
+	NegativeHits uint64
+	// Size number of cached net-attach-defs
+	Size int
+	// Synced is true when all informers listed the net-attach-defs at least once
+	Synced bool
+	// WatchHealthy is true when no informer failed to watch net-attach-defs since it was last in sync
+	WatchHealthy bool
+	// LastSync oldest time at which an informer was known to be in sync with the API server
+	LastSync time.Time
+	// Staleness how old the data of the informers may be, zero when all watches are healthy
+	Staleness time.Duration
+	// Stale is true when the data of the informers is older than the maximum staleness
+	Stale bool
+}
+
+// Options configures which net-attach-defs are watched and how much of them is kept in memory
+type Options struct {
+	// Namespaces in which net-attach-defs are watched, one informer is started per namespace,
+	// net-attach-defs in all namespaces are watched when empty
+	Namespaces []string
+	// MetadataOnly watches only metadata of the net-attach-defs, spec.config of cached net-attach-defs is empty
+	MetadataOnly bool
+	// MaxStaleness is how old cached data may be, older data is retrieved from the API server again,
+	// zero means that cached data is always used
+	MaxStaleness time.Duration
+}
+
 type NetAttachDefCache struct {
-	networkAnnotationsMap      map[string]map[string]string
-	networkAnnotationsMapMutex *sync.Mutex
-	stopper                    chan struct{}
-	isRunning                  int32
+	client         versioned.Interface
+	metadataClient metadata.Interface
+	options        Options
+	clock          clock.PassiveClock
+	entries        map[string]cacheEntry
+	lastKnown      map[string]*cniv1.NetworkAttachmentDefinition
+	entriesMutex   *sync.Mutex
+	stats          CacheStats
+	handlers       []NetAttachDefHandler
+	informers      map[string]*informerState
+	startedAt      time.Time
+	// stale is true when the last check of the informers found their data older than the maximum staleness
+	stale     bool
+	stopper   chan struct{}
+	isRunning int32
 }
 
+// NetAttachDefHandler is called with namespace and name of every net-attach-def added or updated by the informers
+type NetAttachDefHandler func(namespace, networkName string)
+
 type NetAttachDefCacheService interface {
 	Start()
 	Stop()
-	Get(namespace string, networkName string) map[string]string
+	Get(ctx context.Context, namespace string, networkName string) (*cniv1.NetworkAttachmentDefinition, error)
+	Lookup(ctx context.Context, namespace string, networkName string) (*cniv1.NetworkAttachmentDefinition, time.Duration, error)
+	List() []*cniv1.NetworkAttachmentDefinition
+	Stats() CacheStats
+	AddHandler(handler NetAttachDefHandler)
+	GetLastKnown(namespace string, networkName string) (*cniv1.NetworkAttachmentDefinition, bool)
 }
 
-func Create() NetAttachDefCacheService {
-	return &NetAttachDefCache{make(map[string]map[string]string),
-		&sync.Mutex{}, make(chan struct{}), 0}
+func Create(options Options) NetAttachDefCacheService {
+	config := setupInClusterConfig()
+	return newNetAttachDefCache(setupNetAttachDefClient(config), setupMetadataClient(config), options, clock.RealClock{})
 }
 
-// Start creates informer for NetworkAttachmentDefinition events and populate the local cache
+func newNetAttachDefCache(client versioned.Interface, metadataClient metadata.Interface, options Options, clock clock.PassiveClock) *NetAttachDefCache {
+	return &NetAttachDefCache{
+		client:         client,
+		metadataClient: metadataClient,
+		options:        options,
+		clock:          clock,
+		entries:        make(map[string]cacheEntry),
+		lastKnown:      make(map[string]*cniv1.NetworkAttachmentDefinition),
+		informers:      make(map[string]*informerState),
+		entriesMutex:   &sync.Mutex{},
+		stopper:        make(chan struct{}),
+	}
+}
+
+// Start creates informers for NetworkAttachmentDefinition events and populate the local cache
 func (nc *NetAttachDefCache) Start() {
-	factory := externalversions.NewSharedInformerFactoryWithOptions(setupNetAttachDefClient(), 0, externalversions.WithNamespace(""))
-	informer := factory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions().Informer()
-	// mutex to serialize the events.
+	namespaces := nc.options.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	// mutex to serialize the events of all informers.
 	mutex := &sync.Mutex{}
+	nc.entriesMutex.Lock()
+	nc.startedAt = nc.clock.Now()
+	nc.entriesMutex.Unlock()
+
+	for _, namespace := range namespaces {
+		informer := nc.newInformer(namespace)
+		nc.entriesMutex.Lock()
+		nc.informers[namespace] = &informerState{informer: informer}
+		nc.entriesMutex.Unlock()
+		err := informer.SetWatchErrorHandlerWithContext(func(ctx context.Context, r *cache.Reflector, err error) {
+			nc.markWatchError(namespace, err)
+			cache.DefaultWatchErrorHandler(ctx, r, err)
+		})
+		if err != nil {
+			glog.Errorf("error setting watch error handler of net-attach-def informer for namespace %q: %v", namespace, err)
+		}
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				nc.checkInformer(namespace)
+				if netAttachDef, ok := toNetAttachDef(obj); ok {
+					nc.put(netAttachDef)
+					nc.notify(netAttachDef)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				nc.checkInformer(namespace)
+				oldNetAttachDef, oldOk := toNetAttachDef(oldObj)
+				newNetAttachDef, newOk := toNetAttachDef(newObj)
+				if !oldOk || !newOk {
+					return
+				}
+				if oldNetAttachDef.GetResourceVersion() == newNetAttachDef.GetResourceVersion() {
+					glog.Infof("no change in net-attach-def %s, ignoring update event", nc.getKey(oldNetAttachDef.Namespace, newNetAttachDef.Name))
+					return
+				}
+				nc.put(newNetAttachDef)
+				nc.notify(newNetAttachDef)
+			},
+			DeleteFunc: func(obj interface{}) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				nc.checkInformer(namespace)
+				if netAttachDef, ok := toNetAttachDef(obj); ok {
+					nc.remove(netAttachDef.Namespace, netAttachDef.Name)
+				}
+			},
+		})
+
+		atomic.AddInt32(&nc.isRunning, 1)
+		go func(namespace string) {
+			// informer Run blocks until informer is stopped
+			glog.Infof("starting net-attach-def informer for namespace %q (metadata only: %t)", namespace, nc.options.MetadataOnly)
+			informer.Run(nc.stopper)
+			glog.Infof("net-attach-def informer for namespace %q is stopped", namespace)
+			atomic.AddInt32(&nc.isRunning, -1)
+		}(namespace)
+	}
+
+	go wait.Until(nc.checkInformers, informerCheckInterval, nc.stopper)
+}
+
+// checkInformers marks informers which made progress since the last check as in sync and logs when their data
+// gets older than the maximum staleness or is fresh again
+func (nc *NetAttachDefCache) checkInformers() {
+	nc.entriesMutex.Lock()
+	namespaces := make([]string, 0, len(nc.informers))
+	for namespace := range nc.informers {
+		namespaces = append(namespaces, namespace)
+	}
+	nc.entriesMutex.Unlock()
+	for _, namespace := range namespaces {
+		nc.checkInformer(namespace)
+	}
+
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	staleness, _, _, _ := nc.informersStaleness()
+	stale := nc.isStale(staleness)
+	if stale && !nc.stale {
+		glog.Warningf("cached net-attach-defs are stale for %v, maximum staleness is %v, they are retrieved from api server",
+			staleness, nc.options.MaxStaleness)
+	} else if !stale && nc.stale {
+		glog.Infof("cached net-attach-defs are within the maximum staleness %v again", nc.options.MaxStaleness)
+	}
+	nc.stale = stale
+}
+
+// checkInformer marks the informer as in sync when it listed the net-attach-defs and its resource version
+// moved since it was last in sync or it was healthy, i.e. its watch keeps delivering events
+func (nc *NetAttachDefCache) checkInformer(namespace string) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	state, exists := nc.informers[namespace]
+	if !exists || !state.informer.HasSynced() {
+		return
+	}
+	resourceVersion := state.informer.LastSyncResourceVersion()
+	if state.healthy || resourceVersion != state.resourceVersion {
+		if !state.healthy {
+			glog.Infof("net-attach-def informer for namespace %q is in sync", namespace)
+		}
+		state.healthy = true
+		state.lastSync = nc.clock.Now()
+		state.resourceVersion = resourceVersion
+	}
+}
+
+// markWatchError marks the informer as not in sync until its resource version moves again
+func (nc *NetAttachDefCache) markWatchError(namespace string, err error) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	if state, exists := nc.informers[namespace]; exists {
+		if state.healthy {
+			glog.Warningf("net-attach-def informer for namespace %q lost its watch, cached data gets stale: %v", namespace, err)
+		}
+		state.healthy = false
+		if state.informer != nil {
+			state.resourceVersion = state.informer.LastSyncResourceVersion()
+		}
+	}
+}
+
+// informersStaleness returns how old data of the informers may be, the time since the informers were started
+// when an informer was never in sync and zero when the cache was not started
+func (nc *NetAttachDefCache) informersStaleness() (staleness time.Duration, lastSync time.Time, synced, healthy bool) {
+	if len(nc.informers) == 0 {
+		return 0, time.Time{}, false, false
+	}
+	synced, healthy = true, true
+	for _, state := range nc.informers {
+		if state.lastSync.IsZero() {
+			synced, healthy = false, false
+			staleness = max(staleness, nc.clock.Since(nc.startedAt))
+			continue
+		}
+		if lastSync.IsZero() || state.lastSync.Before(lastSync) {
+			lastSync = state.lastSync
+		}
+		if !state.healthy {
+			healthy = false
+			staleness = max(staleness, nc.clock.Since(state.lastSync))
+		}
+	}
+	return staleness, lastSync, synced, healthy
+}
+
+func (nc *NetAttachDefCache) newInformer(namespace string) cache.SharedIndexInformer {
+	if nc.options.MetadataOnly {
+		return metadatainformer.NewFilteredMetadataInformer(nc.metadataClient, netAttachDefResource, namespace, 0, cache.Indexers{}, nil).Informer()
+	}
+	factory := externalversions.NewSharedInformerFactoryWithOptions(nc.client, 0, externalversions.WithNamespace(namespace))
+	return factory.K8sCniCncfIo().V1().NetworkAttachmentDefinitions().Informer()
+}
+
+// toNetAttachDef converts objects delivered by the typed and by the metadata informers
+func toNetAttachDef(obj interface{}) (*cniv1.NetworkAttachmentDefinition, bool) {
+	switch object := obj.(type) {
+	case *cniv1.NetworkAttachmentDefinition:
+		return object, true
+	case *metav1.PartialObjectMetadata:
+		return fromPartialObjectMetadata(object), true
+	case cache.DeletedFinalStateUnknown:
+		return toNetAttachDef(object.Obj)
+	}
+	return nil, false
+}
+
+func fromPartialObjectMetadata(object *metav1.PartialObjectMetadata) *cniv1.NetworkAttachmentDefinition {
+	return &cniv1.NetworkAttachmentDefinition{
+		TypeMeta:   metav1.TypeMeta{APIVersion: cniv1.SchemeGroupVersion.String(), Kind: "NetworkAttachmentDefinition"},
+		ObjectMeta: object.ObjectMeta,
+	}
+}
 
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			mutex.Lock()
-			defer mutex.Unlock()
-			netAttachDef := obj.(*cniv1.NetworkAttachmentDefinition)
-			nc.put(netAttachDef.Namespace, netAttachDef.Name, netAttachDef.Annotations)
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			mutex.Lock()
-			defer mutex.Unlock()
-			oldNetAttachDef := oldObj.(*cniv1.NetworkAttachmentDefinition)
-			newNetAttachDef := newObj.(*cniv1.NetworkAttachmentDefinition)
-			if oldNetAttachDef.GetResourceVersion() == newNetAttachDef.GetResourceVersion() {
-				glog.Infof("no change in net-attach-def %s, ignoring update event", nc.getKey(oldNetAttachDef.Namespace, newNetAttachDef.Name))
-				return
-			}
-			nc.remove(oldNetAttachDef.Namespace, oldNetAttachDef.Name)
-			nc.put(newNetAttachDef.Namespace, newNetAttachDef.Name, newNetAttachDef.Annotations)
-		},
-		DeleteFunc: func(obj interface{}) {
-			mutex.Lock()
-			defer mutex.Unlock()
-			netAttachDef := obj.(*cniv1.NetworkAttachmentDefinition)
-			nc.remove(netAttachDef.Namespace, netAttachDef.Name)
-		},
-	})
-	go func() {
-		atomic.StoreInt32(&(nc.isRunning), int32(1))
-		// informer Run blocks until informer is stopped
-		glog.Infof("starting net-attach-def informer")
-		informer.Run(nc.stopper)
-		glog.Infof("net-attach-def informer is stopped")
-		atomic.StoreInt32(&(nc.isRunning), int32(0))
-	}()
-}
-
-// Stop teardown the NetworkAttachmentDefinition informer
+// Stop teardown the NetworkAttachmentDefinition informers, cached data is still served and gets stale
 func (nc *NetAttachDefCache) Stop() {
 	close(nc.stopper)
 	tEnd := time.Now().Add(3 * time.Second)
 	for tEnd.After(time.Now()) {
 		if atomic.LoadInt32(&nc.isRunning) == 0 {
-			glog.Infof("net-attach-def informer is no longer running, proceed to clean up nad cache")
+			glog.Infof("net-attach-def informer is no longer running")
 			break
 		}
 		time.Sleep(600 * time.Millisecond)
 	}
-	nc.networkAnnotationsMapMutex.Lock()
-	nc.networkAnnotationsMap = nil
-	nc.networkAnnotationsMapMutex.Unlock()
+	/* cached net-attach-defs are kept and served as stale data */
+	nc.entriesMutex.Lock()
+	for _, state := range nc.informers {
+		state.healthy = false
+	}
+	nc.entriesMutex.Unlock()
+}
+
+// AddHandler registers handler of net-attach-defs added or updated by the informers, handlers are called
+// after the net-attach-def is cached
+func (nc *NetAttachDefCache) AddHandler(handler NetAttachDefHandler) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	nc.handlers = append(nc.handlers, handler)
+}
+
+func (nc *NetAttachDefCache) notify(netAttachDef *cniv1.NetworkAttachmentDefinition) {
+	nc.entriesMutex.Lock()
+	handlers := slices.Clone(nc.handlers)
+	nc.entriesMutex.Unlock()
+	for _, handler := range handlers {
+		handler(netAttachDef.Namespace, netAttachDef.Name)
+	}
+}
+
+func (nc *NetAttachDefCache) put(netAttachDef *cniv1.NetworkAttachmentDefinition) {
+	nc.putEntry(netAttachDef.Namespace, netAttachDef.Name, cacheEntry{netAttachDef: netAttachDef})
+}
+
+func (nc *NetAttachDefCache) putEntry(namespace, networkName string, entry cacheEntry) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	key := nc.getKey(namespace, networkName)
+	nc.entries[key] = entry
+	if entry.netAttachDef != nil {
+		nc.lastKnown[key] = entry.netAttachDef
+	}
+}
+
+func (nc *NetAttachDefCache) putLastKnown(netAttachDef *cniv1.NetworkAttachmentDefinition) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	nc.lastKnown[nc.getKey(netAttachDef.Namespace, netAttachDef.Name)] = netAttachDef
+}
+
+// GetLastKnown returns the last net-attach-def retrieved by the informers or from the API server, also when
+// its cache entry expired, net-attach-defs deleted by the informers are not returned
+func (nc *NetAttachDefCache) GetLastKnown(namespace, networkName string) (*cniv1.NetworkAttachmentDefinition, bool) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	netAttachDef, found := nc.lastKnown[nc.getKey(namespace, networkName)]
+	return netAttachDef, found
+}
+
+// Get returns the net-attach-def for the given namespace and network name. When it's not cached it's retrieved
+// from the API server and kept for a short time, so the informer can catch up. Net-attach-defs which don't exist
+// are remembered for a short time as well, the returned error is then NotFound.
+func (nc *NetAttachDefCache) Get(ctx context.Context, namespace, networkName string) (*cniv1.NetworkAttachmentDefinition, error) {
+	netAttachDef, _, err := nc.Lookup(ctx, namespace, networkName)
+	return netAttachDef, err
+}
+
+// Lookup works as Get and also returns how stale the returned data may be. Cached data older than the maximum
+// staleness is retrieved from the API server again without replacing the cache entry, the lookup fails when the
+// API server can't be reached.
+func (nc *NetAttachDefCache) Lookup(ctx context.Context, namespace, networkName string) (*cniv1.NetworkAttachmentDefinition, time.Duration, error) {
+	netAttachDef, found, cached, staleness := nc.getCached(namespace, networkName)
+	if cached && nc.isStale(staleness) {
+		glog.Warningf("cached network attachment definition '%s/%s' is stale for %v, retrieving it from api server",
+			namespace, networkName, staleness)
+		netAttachDef, err := nc.getFromAPI(ctx, namespace, networkName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, staleness, errors.Wrapf(err, "cached net-attach-def is stale for %v", staleness)
+		}
+		if netAttachDef != nil {
+			nc.putLastKnown(netAttachDef)
+			nc.entriesMutex.Lock()
+			nc.stats.FallbackHits++
+			nc.entriesMutex.Unlock()
+		}
+		return netAttachDef, 0, err
+	}
+	if cached {
+		if !found {
+			return nil, staleness, apierrors.NewNotFound(cniv1.Resource("network-attachment-definitions"), networkName)
+		}
+		return netAttachDef, staleness, nil
+	}
+
+	glog.Infof("cache entry not found, retrieving network attachment definition '%s/%s' from api server", namespace, networkName)
+	now := nc.clock.Now()
+	netAttachDef, err := nc.getFromAPI(ctx, namespace, networkName)
+	if apierrors.IsNotFound(err) {
+		nc.putEntry(namespace, networkName, cacheEntry{expiresAt: now.Add(missingEntryTTL)})
+		return nil, 0, err
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	nc.entriesMutex.Lock()
+	nc.stats.FallbackHits++
+	nc.entriesMutex.Unlock()
+	nc.putEntry(namespace, networkName, cacheEntry{netAttachDef: netAttachDef, expiresAt: now.Add(fallbackEntryTTL)})
+	return netAttachDef, 0, nil
 }
 
-func (nc *NetAttachDefCache) put(namespace, networkName string, annotations map[string]string) {
-	nc.networkAnnotationsMapMutex.Lock()
-	nc.networkAnnotationsMap[nc.getKey(namespace, networkName)] = annotations
-	nc.networkAnnotationsMapMutex.Unlock()
+// getFromAPI retrieves the net-attach-def with the same client which is used by the informers
+func (nc *NetAttachDefCache) getFromAPI(ctx context.Context, namespace, networkName string) (*cniv1.NetworkAttachmentDefinition, error) {
+	if nc.options.MetadataOnly {
+		object, err := nc.metadataClient.Resource(netAttachDefResource).Namespace(namespace).Get(ctx, networkName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return fromPartialObjectMetadata(object), nil
+	}
+	return nc.client.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Get(ctx, networkName, metav1.GetOptions{})
+}
+
+// getCached looks the net-attach-def up in the cache, found is false for net-attach-defs which are known
+// to not exist, cached is false when the API server needs to be asked. Entries retrieved from the API server
+// are not stale within their short TTL, entries of the informers are as stale as the informers. Entries older than the
+// maximum staleness are counted as misses, Lookup retrieves them from the API server again.
+func (nc *NetAttachDefCache) getCached(namespace, networkName string) (netAttachDef *cniv1.NetworkAttachmentDefinition, found, cached bool, staleness time.Duration) {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	key := nc.getKey(namespace, networkName)
+	entry, exists := nc.entries[key]
+	if exists && nc.isExpired(entry) {
+		delete(nc.entries, key)
+		exists = false
+	}
+	if !exists {
+		nc.stats.Misses++
+		return nil, false, false, 0
+	}
+	if entry.expiresAt.IsZero() {
+		staleness, _, _, _ = nc.informersStaleness()
+	}
+	if nc.isStale(staleness) {
+		nc.stats.Misses++
+		return entry.netAttachDef, entry.netAttachDef != nil, true, staleness
+	}
+	if entry.netAttachDef == nil {
+		nc.stats.NegativeHits++
+		return nil, false, true, staleness
+	}
+	nc.stats.Hits++
+	return entry.netAttachDef, true, true, staleness
+}
+
+// isStale returns true when cached data is older than the maximum staleness
+func (nc *NetAttachDefCache) isStale(staleness time.Duration) bool {
+	return nc.options.MaxStaleness > 0 && staleness > nc.options.MaxStaleness
 }
 
-// Get returns annotations map for the given namespace and network name, if it's not available
-// return nil
-func (nc *NetAttachDefCache) Get(namespace, networkName string) map[string]string {
-	nc.networkAnnotationsMapMutex.Lock()
-	defer nc.networkAnnotationsMapMutex.Unlock()
-	if annotationsMap, exists := nc.networkAnnotationsMap[nc.getKey(namespace, networkName)]; exists {
-		return annotationsMap
+func (nc *NetAttachDefCache) isExpired(entry cacheEntry) bool {
+	return !entry.expiresAt.IsZero() && !nc.clock.Now().Before(entry.expiresAt)
+}
+
+// List returns all cached net-attach-defs
+func (nc *NetAttachDefCache) List() []*cniv1.NetworkAttachmentDefinition {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	netAttachDefs := make([]*cniv1.NetworkAttachmentDefinition, 0, len(nc.entries))
+	for _, entry := range nc.entries {
+		if entry.netAttachDef != nil && !nc.isExpired(entry) {
+			netAttachDefs = append(netAttachDefs, entry.netAttachDef)
+		}
 	}
-	return nil
+	return netAttachDefs
+}
+
+// Stats returns lookup counters, the current number of cached net-attach-defs and health of the informers
+func (nc *NetAttachDefCache) Stats() CacheStats {
+	nc.entriesMutex.Lock()
+	defer nc.entriesMutex.Unlock()
+	stats := nc.stats
+	for _, entry := range nc.entries {
+		if entry.netAttachDef != nil && !nc.isExpired(entry) {
+			stats.Size++
+		}
+	}
+	stats.Staleness, stats.LastSync, stats.Synced, stats.WatchHealthy = nc.informersStaleness()
+	stats.Stale = nc.isStale(stats.Staleness)
+	return stats
 }
 
 func (nc *NetAttachDefCache) remove(namespace, networkName string) {
-	nc.networkAnnotationsMapMutex.Lock()
-	delete(nc.networkAnnotationsMap, nc.getKey(namespace, networkName))
-	nc.networkAnnotationsMapMutex.Unlock()
+	nc.entriesMutex.Lock()
+	key := nc.getKey(namespace, networkName)
+	delete(nc.entries, key)
+	delete(nc.lastKnown, key)
+	nc.entriesMutex.Unlock()
 }
 
 func (nc *NetAttachDefCache) getKey(namespace, networkName string) string {
 	return namespace + "/" + networkName
 }
 
-// setupNetAttachDefClient creates K8s client for net-attach-def crd
-func setupNetAttachDefClient() versioned.Interface {
+func setupInClusterConfig() *rest.Config {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		glog.Fatal(err)
 	}
+	return config
+}
+
+// setupNetAttachDefClient creates K8s client for net-attach-def crd
+func setupNetAttachDefClient(config *rest.Config) versioned.Interface {
 	clientset, err := versioned.NewForConfig(config)
 	if err != nil {
 		glog.Fatal(err)
 	}
 	return clientset
 }
+
+// setupMetadataClient creates K8s client used by the metadata only informers
+func setupMetadataClient(config *rest.Config) metadata.Interface {
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return metadataClient
+}
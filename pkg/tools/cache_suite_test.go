@@ -0,0 +1,15 @@
+package cache
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCache(t *testing.T) {
+	// generated fake clientset of net-attach-defs doesn't support streaming lists used by informers
+	t.Setenv("KUBE_FEATURE_WatchListClient", "false")
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cache Suite")
+}
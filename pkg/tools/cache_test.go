@@ -0,0 +1,332 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/client/clientset/versioned/fake"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func createNetAttachDef(namespace, name string) *cniv1.NetworkAttachmentDefinition {
+	return &cniv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+		},
+		Spec: cniv1.NetworkAttachmentDefinitionSpec{Config: `{"cniVersion":"1.0.0","type":"sriov"}`},
+	}
+}
+
+var _ = Describe("Net-attach-def cache", func() {
+	var (
+		client *fake.Clientset
+		clock  *clocktesting.FakePassiveClock
+		nc     *NetAttachDefCache
+		ctx    context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		// objects passed to the fake clientset constructor are tracked under a guessed resource name
+		client = fake.NewSimpleClientset()
+		_, err := client.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(ctx,
+			createNetAttachDef("default", "api-net"), metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		clock = clocktesting.NewFakePassiveClock(time.Now())
+		nc = newNetAttachDefCache(client, nil, Options{}, clock)
+	})
+
+	Context("Entries from the informer", func() {
+		It("should return the full net-attach-def", func() {
+			nc.put(createNetAttachDef("default", "sriov-net"))
+
+			netAttachDef, err := nc.Get(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netAttachDef.Spec.Config).To(ContainSubstring("sriov"))
+			Expect(netAttachDef.GetAnnotations()).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/resourceName", "intel.com/sriov"))
+			Expect(nc.Stats()).To(Equal(CacheStats{Hits: 1, Size: 1}))
+		})
+
+		It("should not expire", func() {
+			nc.put(createNetAttachDef("default", "sriov-net"))
+			clock.SetTime(clock.Now().Add(time.Hour))
+
+			_, err := nc.Get(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nc.Stats().Misses).To(BeZero())
+		})
+
+		It("should be listed", func() {
+			nc.put(createNetAttachDef("default", "sriov-net"))
+			nc.put(createNetAttachDef("other", "sriov-net"))
+			Expect(nc.List()).To(HaveLen(2))
+		})
+	})
+
+	Context("Fallback to the API server", func() {
+		It("should retrieve and cache net-attach-defs missing in the cache", func() {
+			_, err := nc.Get(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = nc.Get(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(nc.Stats()).To(Equal(CacheStats{Hits: 1, Misses: 1, FallbackHits: 1, Size: 1}))
+		})
+
+		It("should retrieve net-attach-def again when the fallback entry expires", func() {
+			_, err := nc.Get(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			clock.SetTime(clock.Now().Add(fallbackEntryTTL))
+
+			Expect(nc.List()).To(BeEmpty())
+			_, err = nc.Get(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nc.Stats().FallbackHits).To(Equal(uint64(2)))
+		})
+
+		It("should remember net-attach-defs which don't exist", func() {
+			_, err := nc.Get(ctx, "default", "unknown-net")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			_, err = nc.Get(ctx, "default", "unknown-net")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			Expect(nc.Stats()).To(Equal(CacheStats{Misses: 1, NegativeHits: 1}))
+			// create of api-net and a single get of unknown-net
+			Expect(client.Actions()).To(HaveLen(2))
+		})
+
+		It("should look net-attach-def up again when the negative entry expires", func() {
+			_, err := nc.Get(ctx, "default", "unknown-net")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			_, err = client.K8sCniCncfIoV1().NetworkAttachmentDefinitions("default").Create(ctx,
+				createNetAttachDef("default", "unknown-net"), metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			clock.SetTime(clock.Now().Add(missingEntryTTL))
+
+			_, err = nc.Get(ctx, "default", "unknown-net")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should keep last known net-attach-def after the fallback entry expires", func() {
+			_, err := nc.Get(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			clock.SetTime(clock.Now().Add(fallbackEntryTTL))
+			Expect(nc.List()).To(BeEmpty())
+
+			netAttachDef, found := nc.GetLastKnown("default", "api-net")
+			Expect(found).To(BeTrue())
+			Expect(netAttachDef.Name).To(Equal("api-net"))
+
+			nc.remove("default", "api-net")
+			_, found = nc.GetLastKnown("default", "api-net")
+			Expect(found).To(BeFalse())
+		})
+
+		It("should replace negative entry when informer delivers the net-attach-def", func() {
+			_, err := nc.Get(ctx, "default", "sriov-net")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			nc.put(createNetAttachDef("default", "sriov-net"))
+			_, err = nc.Get(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("Staleness", func() {
+		BeforeEach(func() {
+			nc.informers["default"] = &informerState{healthy: true, lastSync: clock.Now()}
+			nc.put(createNetAttachDef("default", "sriov-net"))
+		})
+
+		It("should be zero while the watches are healthy", func() {
+			clock.SetTime(clock.Now().Add(time.Hour))
+			_, staleness, err := nc.Lookup(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(staleness).To(BeZero())
+
+			stats := nc.Stats()
+			Expect(stats.Synced).To(BeTrue())
+			Expect(stats.WatchHealthy).To(BeTrue())
+		})
+
+		It("should grow after a watch error", func() {
+			nc.markWatchError("default", errors.New("connection refused"))
+			clock.SetTime(clock.Now().Add(2 * time.Minute))
+
+			_, staleness, err := nc.Lookup(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(staleness).To(Equal(2 * time.Minute))
+
+			stats := nc.Stats()
+			Expect(stats.WatchHealthy).To(BeFalse())
+			Expect(stats.Staleness).To(Equal(2 * time.Minute))
+		})
+
+		It("should be the time since start when an informer was never in sync", func() {
+			nc.startedAt = clock.Now()
+			nc.informers["other"] = &informerState{}
+			clock.SetTime(clock.Now().Add(time.Minute))
+
+			stats := nc.Stats()
+			Expect(stats.Synced).To(BeFalse())
+			Expect(stats.Staleness).To(Equal(time.Minute))
+		})
+
+		It("should be zero for entries retrieved from the API server", func() {
+			nc.markWatchError("default", errors.New("connection refused"))
+			_, err := nc.Get(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			clock.SetTime(clock.Now().Add(time.Second))
+
+			_, staleness, err := nc.Lookup(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(staleness).To(BeZero())
+		})
+
+		It("should retrieve net-attach-defs older than the maximum staleness from the API server", func() {
+			nc.options.MaxStaleness = time.Minute
+			stale := createNetAttachDef("default", "api-net")
+			stale.Annotations["k8s.v1.cni.cncf.io/resourceName"] = "intel.com/stale"
+			nc.put(stale)
+			nc.markWatchError("default", errors.New("connection refused"))
+			clock.SetTime(clock.Now().Add(2 * time.Minute))
+
+			netAttachDef, staleness, err := nc.Lookup(ctx, "default", "api-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(staleness).To(BeZero())
+			Expect(netAttachDef.Annotations).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/resourceName", "intel.com/sriov"))
+			lastKnown, _ := nc.GetLastKnown("default", "api-net")
+			Expect(lastKnown.Annotations).To(HaveKeyWithValue("k8s.v1.cni.cncf.io/resourceName", "intel.com/sriov"))
+			stats := nc.Stats()
+			Expect(stats.Stale).To(BeTrue())
+			Expect(stats.Hits).To(BeZero())
+			Expect(stats.Misses).To(Equal(uint64(1)))
+			Expect(stats.FallbackHits).To(Equal(uint64(1)))
+		})
+
+		It("should fail when net-attach-def is too stale and the API server can't be reached", func() {
+			nc.options.MaxStaleness = time.Minute
+			nc.markWatchError("default", errors.New("connection refused"))
+			clock.SetTime(clock.Now().Add(2 * time.Minute))
+			client.PrependReactor("get", "network-attachment-definitions", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewTimeoutError("request did not complete in time", 0)
+			})
+
+			_, staleness, err := nc.Lookup(ctx, "default", "sriov-net")
+			Expect(err).To(MatchError(ContainSubstring("stale for 2m0s")))
+			Expect(apierrors.IsTimeout(errors.Cause(err))).To(BeTrue())
+			Expect(staleness).To(Equal(2 * time.Minute))
+		})
+
+		It("should serve stale data after the informers are stopped", func() {
+			nc.Stop()
+			clock.SetTime(clock.Now().Add(time.Minute))
+
+			netAttachDef, staleness, err := nc.Lookup(ctx, "default", "sriov-net")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netAttachDef.Name).To(Equal("sriov-net"))
+			Expect(staleness).To(Equal(time.Minute))
+		})
+	})
+
+	Context("Metrics", func() {
+		It("should expose staleness and lookups of the cache", func() {
+			nc.informers["default"] = &informerState{lastSync: clock.Now()}
+			clock.SetTime(clock.Now().Add(time.Minute))
+			_, err := nc.Get(ctx, "default", "unknown-net")
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			registry := prometheus.NewPedanticRegistry()
+			Expect(RegisterMetrics(registry, nc)).To(Succeed())
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP network_resources_injector_net_attach_def_cache_staleness_seconds How old the cached net-attach-defs may be, zero while all watches are healthy.
+# TYPE network_resources_injector_net_attach_def_cache_staleness_seconds gauge
+network_resources_injector_net_attach_def_cache_staleness_seconds 60
+# HELP network_resources_injector_net_attach_def_cache_watch_healthy 1 when no informer failed to watch net-attach-defs since it was last in sync.
+# TYPE network_resources_injector_net_attach_def_cache_watch_healthy gauge
+network_resources_injector_net_attach_def_cache_watch_healthy 0
+# HELP network_resources_injector_net_attach_def_cache_lookups_total Number of net-attach-def lookups by result.
+# TYPE network_resources_injector_net_attach_def_cache_lookups_total counter
+network_resources_injector_net_attach_def_cache_lookups_total{result="fallback_hit"} 0
+network_resources_injector_net_attach_def_cache_lookups_total{result="hit"} 0
+network_resources_injector_net_attach_def_cache_lookups_total{result="miss"} 1
+network_resources_injector_net_attach_def_cache_lookups_total{result="negative_hit"} 0
+`), "network_resources_injector_net_attach_def_cache_staleness_seconds",
+				"network_resources_injector_net_attach_def_cache_watch_healthy",
+				"network_resources_injector_net_attach_def_cache_lookups_total")).To(Succeed())
+		})
+	})
+
+	Context("Informers", func() {
+		It("should watch only the given namespaces", func() {
+			for _, namespace := range []string{"watched", "other"} {
+				_, err := client.K8sCniCncfIoV1().NetworkAttachmentDefinitions(namespace).Create(ctx,
+					createNetAttachDef(namespace, "sriov-net"), metav1.CreateOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			nc = newNetAttachDefCache(client, nil, Options{Namespaces: []string{"watched"}}, clock)
+			nc.Start()
+			defer nc.Stop()
+
+			Eventually(nc.List).Should(HaveLen(1))
+			Consistently(nc.List).Should(HaveLen(1))
+			Expect(nc.List()[0].Namespace).To(Equal("watched"))
+		})
+
+		It("should be in sync after the initial list", func() {
+			nc.Start()
+			defer nc.Stop()
+
+			Eventually(func() bool { return nc.Stats().Synced }).Should(BeTrue())
+			Expect(nc.Stats().WatchHealthy).To(BeTrue())
+			Expect(nc.Stats().Staleness).To(BeZero())
+		})
+
+		It("should notify handlers of added net-attach-defs", func() {
+			notified := make(chan string, 1)
+			nc.AddHandler(func(namespace, networkName string) {
+				notified <- namespace + "/" + networkName
+			})
+			nc.Start()
+			defer nc.Stop()
+
+			Eventually(notified).Should(Receive(Equal("default/api-net")))
+		})
+
+		It("should keep only metadata in metadata only mode", func() {
+			scheme := metadatafake.NewTestScheme()
+			Expect(metav1.AddMetaToScheme(scheme)).To(Succeed())
+			metadataClient := metadatafake.NewSimpleMetadataClient(scheme)
+			netAttachDef := createNetAttachDef("default", "sriov-net")
+			Expect(metadataClient.Tracker().Create(netAttachDefResource, &metav1.PartialObjectMetadata{
+				TypeMeta:   metav1.TypeMeta{APIVersion: cniv1.SchemeGroupVersion.String(), Kind: "NetworkAttachmentDefinition"},
+				ObjectMeta: netAttachDef.ObjectMeta,
+			}, "default")).To(Succeed())
+
+			nc = newNetAttachDefCache(client, metadataClient, Options{MetadataOnly: true}, clock)
+			nc.Start()
+			defer nc.Stop()
+
+			Eventually(nc.List).Should(HaveLen(1))
+			cached := nc.List()[0]
+			Expect(cached.GetAnnotations()).To(Equal(netAttachDef.GetAnnotations()))
+			Expect(cached.Spec.Config).To(BeEmpty())
+		})
+	})
+})
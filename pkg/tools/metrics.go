@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "network_resources_injector"
+
+// RegisterMetrics registers gauges and counters which expose the health of the net-attach-def cache, values are
+// read from the cache statistics on every scrape
+func RegisterMetrics(registerer prometheus.Registerer, nc NetAttachDefCacheService) error {
+	gauge := func(name, help string, value func(stats CacheStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "net_attach_def_cache",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return value(nc.Stats())
+		})
+	}
+	lookups := func(result string, value func(stats CacheStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   "net_attach_def_cache",
+			Name:        "lookups_total",
+			Help:        "Number of net-attach-def lookups by result.",
+			ConstLabels: prometheus.Labels{"result": result},
+		}, func() float64 {
+			return float64(value(nc.Stats()))
+		})
+	}
+	boolValue := func(value bool) float64 {
+		if value {
+			return 1
+		}
+		return 0
+	}
+
+	collectors := []prometheus.Collector{
+		gauge("staleness_seconds", "How old the cached net-attach-defs may be, zero while all watches are healthy.",
+			func(stats CacheStats) float64 { return stats.Staleness.Seconds() }),
+		gauge("stale", "1 when the cached net-attach-defs are older than the maximum staleness.",
+			func(stats CacheStats) float64 { return boolValue(stats.Stale) }),
+		gauge("last_sync_timestamp_seconds", "Oldest time at which an informer was known to be in sync with the API server.",
+			func(stats CacheStats) float64 {
+				if stats.LastSync.IsZero() {
+					return 0
+				}
+				return float64(stats.LastSync.UnixNano()) / 1e9
+			}),
+		gauge("watch_healthy", "1 when no informer failed to watch net-attach-defs since it was last in sync.",
+			func(stats CacheStats) float64 { return boolValue(stats.WatchHealthy) }),
+		gauge("synced", "1 when all informers listed the net-attach-defs at least once.",
+			func(stats CacheStats) float64 { return boolValue(stats.Synced) }),
+		gauge("size", "Number of cached net-attach-defs.",
+			func(stats CacheStats) float64 { return float64(stats.Size) }),
+		lookups("hit", func(stats CacheStats) uint64 { return stats.Hits }),
+		lookups("negative_hit", func(stats CacheStats) uint64 { return stats.NegativeHits }),
+		lookups("miss", func(stats CacheStats) uint64 { return stats.Misses }),
+		lookups("fallback_hit", func(stats CacheStats) uint64 { return stats.FallbackHits }),
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
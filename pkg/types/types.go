@@ -15,15 +15,17 @@
 package types
 
 const (
-	DownwardAPIMountPath   = "/etc/podnetinfo"
-	AnnotationsPath        = "annotations"
-	LabelsPath             = "labels"
-	EnvNameContainerName   = "CONTAINER_NAME"
-	Hugepages1GRequestPath = "hugepages_1G_request"
-	Hugepages2MRequestPath = "hugepages_2M_request"
-	Hugepages1GLimitPath   = "hugepages_1G_limit"
-	Hugepages2MLimitPath   = "hugepages_2M_limit"
-	ConfigMapMainFileKey   = "config.json"
+	DownwardAPIVolumeName   = "podnetinfo"
+	DownwardAPIMountPath    = "/etc/podnetinfo"
+	AnnotationsPath         = "annotations"
+	LabelsPath              = "labels"
+	NetworkResourcesMapPath = "network-resources-map"
+	EnvNameContainerName    = "CONTAINER_NAME"
+	Hugepages1GRequestPath  = "hugepages_1G_request"
+	Hugepages2MRequestPath  = "hugepages_2M_request"
+	Hugepages1GLimitPath    = "hugepages_1G_limit"
+	Hugepages2MLimitPath    = "hugepages_2M_limit"
+	ConfigMapMainFileKey    = "config.json"
 )
 
 // JSONPatchOperation the JSON path operation
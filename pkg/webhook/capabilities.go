@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// capabilitiesKey net-attach-def annotation with comma separated Linux capabilities needed by an attachment
+	capabilitiesKey = "k8s.v1.cni.cncf.io/capabilities"
+	// podSecurityEnforceLabel namespace label with the Pod Security Admission level enforced in the namespace
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+	podSecurityLevelBaseline   = "baseline"
+	podSecurityLevelRestricted = "restricted"
+)
+
+var (
+	capabilityRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+	// baselineCapabilities capabilities which may be added by pods with the baseline Pod Security Standard
+	baselineCapabilities = []corev1.Capability{"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL",
+		"MKNOD", "NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT"}
+	// restrictedCapabilities capabilities which may be added by pods with the restricted Pod Security Standard
+	restrictedCapabilities = []corev1.Capability{"NET_BIND_SERVICE"}
+)
+
+// parseNetAttachDefCapabilities returns the capabilities listed by the net-attach-def annotation, the names are
+// accepted with or without the CAP_ prefix and in any case
+func parseNetAttachDefCapabilities(annotationsMap map[string]string) ([]corev1.Capability, error) {
+	value, exists := annotationsMap[capabilitiesKey]
+	if !exists {
+		return nil, nil
+	}
+	var capabilities []corev1.Capability
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "CAP_")
+		if !capabilityRegex.MatchString(name) {
+			return nil, fmt.Errorf("capabilities annotation %q has invalid capability %q", value, name)
+		}
+		capabilities = append(capabilities, corev1.Capability(name))
+	}
+	return capabilities, nil
+}
+
+// mergeCapabilities adds the capabilities which are not in the list yet and keeps the list sorted
+func mergeCapabilities(capabilities []corev1.Capability, added []corev1.Capability) []corev1.Capability {
+	for _, capability := range added {
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	slices.Sort(capabilities)
+	return capabilities
+}
+
+// addCapabilities merges the capabilities into securityContext.capabilities.add of the container, capabilities
+// the container already adds are kept
+func addCapabilities(container *corev1.Container, capabilities []corev1.Capability) {
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	if container.SecurityContext.Capabilities == nil {
+		container.SecurityContext.Capabilities = &corev1.Capabilities{}
+	}
+	for _, capability := range capabilities {
+		if slices.Contains(container.SecurityContext.Capabilities.Add, capability) {
+			glog.Infof("capability %s is already added by container %s, skipping", capability, container.Name)
+			continue
+		}
+		container.SecurityContext.Capabilities.Add = append(container.SecurityContext.Capabilities.Add, capability)
+	}
+}
+
+// getForbiddenCapabilities returns the capabilities which pods can't add with the Pod Security Admission level
+func getForbiddenCapabilities(level string, capabilities []corev1.Capability) []corev1.Capability {
+	var allowed []corev1.Capability
+	switch level {
+	case podSecurityLevelBaseline:
+		allowed = baselineCapabilities
+	case podSecurityLevelRestricted:
+		allowed = restrictedCapabilities
+	default:
+		return nil
+	}
+	var forbidden []corev1.Capability
+	for _, capability := range capabilities {
+		if !slices.Contains(allowed, capability) {
+			forbidden = append(forbidden, capability)
+		}
+	}
+	return forbidden
+}
+
+// checkCapabilitiesPodSecurity returns warning when the Pod Security Admission level enforced in the namespace
+// of the pod forbids capabilities needed by its networks, the pod is then rejected by the API server. The check
+// is skipped when the namespace can't be retrieved.
+func (wh *Webhook) checkCapabilitiesPodSecurity(ctx context.Context, namespace string, capabilities []corev1.Capability) string {
+	if len(capabilities) == 0 {
+		return ""
+	}
+	ns, err := wh.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		glog.Warningf("could not check Pod Security Admission level of namespace %s: %v", namespace, err)
+		return ""
+	}
+	level := ns.Labels[podSecurityEnforceLabel]
+	forbidden := getForbiddenCapabilities(level, capabilities)
+	if len(forbidden) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("capabilities %v needed by the pod networks are forbidden by Pod Security Admission level %s of namespace %s",
+		forbidden, level, namespace)
+}
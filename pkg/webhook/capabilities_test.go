@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	evanphx "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("Capabilities", func() {
+	DescribeTable("Parsing net-attach-def capabilities",
+		func(value string, expected []corev1.Capability, shouldFail bool) {
+			capabilities, err := parseNetAttachDefCapabilities(map[string]string{capabilitiesKey: value})
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(capabilities).To(Equal(expected))
+		},
+		Entry("single capability", "IPC_LOCK", []corev1.Capability{"IPC_LOCK"}, false),
+		Entry("list with prefix and lower case", "cap_net_raw, IPC_LOCK", []corev1.Capability{"NET_RAW", "IPC_LOCK"}, false),
+		Entry("empty entry", "IPC_LOCK,", nil, true),
+		Entry("invalid name", "IPC-LOCK", nil, true),
+	)
+
+	It("should merge capabilities into the container security context", func() {
+		container := &corev1.Container{Name: "app", SecurityContext: &corev1.SecurityContext{
+			Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_RAW"}, Drop: []corev1.Capability{"ALL"}},
+		}}
+		addCapabilities(container, []corev1.Capability{"IPC_LOCK", "NET_RAW"})
+		Expect(container.SecurityContext.Capabilities.Add).To(Equal([]corev1.Capability{"NET_RAW", "IPC_LOCK"}))
+		Expect(container.SecurityContext.Capabilities.Drop).To(Equal([]corev1.Capability{"ALL"}))
+	})
+
+	DescribeTable("Finding capabilities forbidden by Pod Security Admission",
+		func(level string, expected []corev1.Capability) {
+			Expect(getForbiddenCapabilities(level, []corev1.Capability{"NET_BIND_SERVICE", "CHOWN", "NET_RAW", "IPC_LOCK"})).To(Equal(expected))
+		},
+		Entry("privileged", "privileged", nil),
+		Entry("no level", "", nil),
+		Entry("baseline", "baseline", []corev1.Capability{"NET_RAW", "IPC_LOCK"}),
+		Entry("restricted", "restricted", []corev1.Capability{"CHOWN", "NET_RAW", "IPC_LOCK"}),
+	)
+
+	Describe("Admission", func() {
+		var wh *Webhook
+
+		mutate := func() (*admissionv1.AdmissionResponse, *corev1.Pod) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "rdma-net,dpdk-net"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			Expect(ar.Response.Allowed).To(BeTrue())
+
+			patch, err := evanphx.DecodePatch(ar.Response.Patch)
+			Expect(err).NotTo(HaveOccurred())
+			podJSON, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			patchedJSON, err := patch.Apply(podJSON)
+			Expect(err).NotTo(HaveOccurred())
+			patched := &corev1.Pod{}
+			Expect(json.Unmarshal(patchedJSON, patched)).To(Succeed())
+			return ar.Response, patched
+		}
+
+		BeforeEach(func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			wh = newTestWebhook(switches, map[string]map[string]string{
+				"default/rdma-net": {"k8s.v1.cni.cncf.io/resourceName": "nvidia.com/rdma", capabilitiesKey: "IPC_LOCK"},
+				"default/dpdk-net": {capabilitiesKey: "IPC_LOCK,NET_RAW"},
+			})
+		})
+
+		It("should add capabilities of all networks once", func() {
+			response, patched := mutate()
+			Expect(response.Warnings).To(BeEmpty())
+			Expect(patched.Spec.Containers[0].SecurityContext.Capabilities.Add).To(Equal([]corev1.Capability{"IPC_LOCK", "NET_RAW"}))
+		})
+
+		It("should warn when the namespace enforces the baseline level", func() {
+			_, err := wh.client.(*fake.Clientset).CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{podSecurityEnforceLabel: "baseline"}},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			response, _ := mutate()
+			Expect(response.Warnings).To(ConsistOf(
+				"capabilities [IPC_LOCK NET_RAW] needed by the pod networks are forbidden by Pod Security Admission level baseline of namespace default"))
+		})
+	})
+})
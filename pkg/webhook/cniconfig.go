@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// getResourceNamesFromConfig extracts resource names from the CNI config of a net-attach-def. Paths are dot
+// separated keys of nested JSON objects, the first path found in a plugin config wins. Every plugin of a
+// configuration list is searched, so a conflist may define resources of several plugins.
+func getResourceNamesFromConfig(config string, paths []string) ([]string, error) {
+	if strings.TrimSpace(config) == "" || len(paths) == 0 {
+		return nil, nil
+	}
+
+	var cniConfig map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &cniConfig); err != nil {
+		return nil, errors.Wrap(err, "could not parse CNI config")
+	}
+
+	pluginConfigs := []map[string]interface{}{cniConfig}
+	if plugins, isList := cniConfig["plugins"].([]interface{}); isList {
+		pluginConfigs = nil
+		for _, plugin := range plugins {
+			if pluginConfig, ok := plugin.(map[string]interface{}); ok {
+				pluginConfigs = append(pluginConfigs, pluginConfig)
+			}
+		}
+	}
+
+	var resourceNames []string
+	for _, pluginConfig := range pluginConfigs {
+		for _, path := range paths {
+			value, found := lookupConfigPath(pluginConfig, path)
+			if !found {
+				continue
+			}
+			resourceName, ok := value.(string)
+			if !ok || strings.TrimSpace(resourceName) == "" {
+				return nil, errors.Errorf("CNI config value at %s is not a resource name", path)
+			}
+			if !slices.Contains(resourceNames, resourceName) {
+				resourceNames = append(resourceNames, resourceName)
+			}
+			break
+		}
+	}
+
+	return resourceNames, nil
+}
+
+func lookupConfigPath(config map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = config
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("CNI config", func() {
+	paths := []string{"resourceName", "deviceInfo.pool"}
+
+	DescribeTable("Extracting resource names",
+		func(config string, expectedResourceNames []string, shouldFail bool) {
+			resourceNames, err := getResourceNamesFromConfig(config, paths)
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resourceNames).To(Equal(expectedResourceNames))
+		},
+		Entry("empty config", "", nil, false),
+		Entry("single plugin config", `{"type":"sriov","resourceName":"intel.com/sriov"}`, []string{"intel.com/sriov"}, false),
+		Entry("nested path", `{"type":"host-device","deviceInfo":{"pool":"example.com/pool"}}`, []string{"example.com/pool"}, false),
+		Entry("first path wins", `{"resourceName":"intel.com/sriov","deviceInfo":{"pool":"example.com/pool"}}`, []string{"intel.com/sriov"}, false),
+		Entry("config without resource name", `{"type":"macvlan","master":"eth0"}`, nil, false),
+		Entry("conflist", `{"name":"net","plugins":[{"type":"sriov","resourceName":"intel.com/sriov_a"},{"type":"tuning"},`+
+			`{"type":"sriov","resourceName":"intel.com/sriov_b"},{"type":"sriov","resourceName":"intel.com/sriov_a"}]}`,
+			[]string{"intel.com/sriov_a", "intel.com/sriov_b"}, false),
+		Entry("value is not a string", `{"resourceName":5}`, nil, true),
+		Entry("value is empty", `{"resourceName":""}`, nil, true),
+		Entry("invalid JSON", `{"resourceName":`, nil, true),
+	)
+
+	Describe("Parsing net-attach-def", func() {
+		var switches *controlswitches.ControlSwitches
+
+		BeforeEach(func() {
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.SetResourceConfigPathsUnitTests("resourceName")
+			switches.InitControlSwitches()
+		})
+
+		createNetAttachDef := func(annotations map[string]string, config string) *cniv1.NetworkAttachmentDefinition {
+			return &cniv1.NetworkAttachmentDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "default", Annotations: annotations},
+				Spec:       cniv1.NetworkAttachmentDefinitionSpec{Config: config},
+			}
+		}
+
+		It("should prefer resource names from annotations", func() {
+			resources, _, err := parseNetAttachDef(createNetAttachDef(
+				map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/annotation"},
+				`{"resourceName":"intel.com/config"}`), switches)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(Equal(map[string]int64{"intel.com/annotation": 1}))
+		})
+
+		It("should fall back to the CNI config", func() {
+			resources, _, err := parseNetAttachDef(createNetAttachDef(nil, `{"resourceName":"intel.com/config"}`), switches)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(Equal(map[string]int64{"intel.com/config": 1}))
+		})
+
+		It("should ignore the CNI config when no paths are configured", func() {
+			switches.SetResourceConfigPathsUnitTests("")
+			switches.InitControlSwitches()
+			resources, _, err := parseNetAttachDef(createNetAttachDef(nil, `{"resourceName":"intel.com/config"}`), switches)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(BeEmpty())
+		})
+	})
+})
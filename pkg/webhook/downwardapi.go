@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+// pod annotations overriding the Downward API volume configured by the control switches
+const (
+	downwardAPIVolumeNameKey = "k8s.v1.cni.cncf.io/downward-api-volume-name"
+	downwardAPIMountPathKey  = "k8s.v1.cni.cncf.io/downward-api-mount-path"
+	// downwardAPIContainersKey all, resources or comma separated names of containers, init containers included
+	downwardAPIContainersKey = "k8s.v1.cni.cncf.io/downward-api-containers"
+	downwardAPIItemsKey      = "k8s.v1.cni.cncf.io/downward-api-items"
+)
+
+// downwardAPIConfig Downward API volume injected into a pod
+type downwardAPIConfig struct {
+	volumeName string
+	mountPath  string
+	// containerNames containers in which the volume is mounted, all containers when nil
+	containerNames []string
+	items          []string
+}
+
+// hasItem returns true when the item is projected by the volume
+func (c *downwardAPIConfig) hasItem(item string) bool {
+	return slices.Contains(c.items, item)
+}
+
+// getDownwardAPIConfig returns the Downward API volume configured by the control switches with the overrides of
+// the pod annotations, invalid overrides are refused
+func getDownwardAPIConfig(pod *corev1.Pod, switches *controlswitches.ControlSwitches) (*downwardAPIConfig, error) {
+	config := &downwardAPIConfig{
+		volumeName: switches.GetDownwardAPIVolumeName(),
+		mountPath:  switches.GetDownwardAPIMountPath(),
+		items:      switches.GetDownwardAPIItems(),
+	}
+	if name, exists := pod.Annotations[downwardAPIVolumeNameKey]; exists {
+		if err := controlswitches.ValidateDownwardAPIVolumeName(name); err != nil {
+			return nil, err
+		}
+		config.volumeName = name
+	}
+	if mountPath, exists := pod.Annotations[downwardAPIMountPathKey]; exists {
+		if err := controlswitches.ValidateDownwardAPIMountPath(mountPath); err != nil {
+			return nil, err
+		}
+		config.mountPath = mountPath
+	}
+	if value, exists := pod.Annotations[downwardAPIItemsKey]; exists {
+		items, err := controlswitches.ParseDownwardAPIItems(value)
+		if err != nil {
+			return nil, err
+		}
+		config.items = items
+	}
+
+	containers := switches.GetDownwardAPIContainers()
+	if value, exists := pod.Annotations[downwardAPIContainersKey]; exists {
+		containers = strings.TrimSpace(value)
+	}
+	switch containers {
+	case controlswitches.DownwardAPIContainersAll:
+	case controlswitches.DownwardAPIContainersResources:
+		target, err := getResourceInjectionTarget(pod)
+		if err != nil {
+			return nil, err
+		}
+		config.containerNames = []string{}
+		if target != nil {
+			config.containerNames = append(config.containerNames, target.Name)
+		}
+	default:
+		config.containerNames = []string{}
+		for _, name := range strings.Split(containers, ",") {
+			name = strings.TrimSpace(name)
+			if !hasContainer(pod, name) {
+				return nil, fmt.Errorf("invalid %s annotation %q, container %q doesn't exist", downwardAPIContainersKey, containers, name)
+			}
+			config.containerNames = append(config.containerNames, name)
+		}
+	}
+	return config, nil
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	isNamed := func(container corev1.Container) bool {
+		return container.Name == name
+	}
+	return slices.ContainsFunc(pod.Spec.Containers, isNamed) || slices.ContainsFunc(pod.Spec.InitContainers, isNamed)
+}
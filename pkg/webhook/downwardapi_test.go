@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("Downward API volume", func() {
+	var switches *controlswitches.ControlSwitches
+
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Labels:      map[string]string{"app": "test"},
+				Annotations: annotations,
+			},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers: []corev1.Container{
+					{Name: "app", Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{"hugepages-2Mi": resource.MustParse("4Mi")},
+					}},
+					{Name: "sidecar"},
+				},
+			},
+		}
+	}
+
+	mutate := func(pod *corev1.Pod) (*corev1.Pod, error) {
+		return NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{
+			ControlSwitches:  switches,
+			ResourceRequests: map[string]int64{"intel.com/sriov": 1},
+		})
+	}
+
+	mountedContainers := func(pod *corev1.Pod, volumeName string) []string {
+		names := []string{}
+		for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for _, container := range containers {
+				for _, vm := range container.VolumeMounts {
+					if vm.Name == volumeName {
+						names = append(names, container.Name)
+					}
+				}
+			}
+		}
+		return names
+	}
+
+	itemPaths := func(volume corev1.Volume) []string {
+		paths := []string{}
+		for _, item := range volume.DownwardAPI.Items {
+			paths = append(paths, item.Path)
+		}
+		return paths
+	}
+
+	BeforeEach(func() {
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(true), createBool(false), createString(""))
+	})
+
+	It("should mount podnetinfo volume with all items in all regular containers by default", func() {
+		switches.InitControlSwitches()
+		mutated, err := mutate(newPod(map[string]string{"note": "test"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountedContainers(mutated, "podnetinfo")).To(Equal([]string{"app", "sidecar"}))
+		Expect(mutated.Spec.Containers[0].VolumeMounts[0].MountPath).To(Equal("/etc/podnetinfo"))
+		Expect(itemPaths(mutated.Spec.Volumes[0])).To(ConsistOf("labels", "annotations", "hugepages_2M_limit_app"))
+	})
+
+	It("should use the volume configured by the control switches", func() {
+		switches.SetDownwardAPIUnitTests("nri-info", "/var/run/nri", controlswitches.DownwardAPIContainersResources, "labels")
+		switches.InitControlSwitches()
+		mutated, err := mutate(newPod(map[string]string{"note": "test"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountedContainers(mutated, "nri-info")).To(Equal([]string{"app"}))
+		Expect(mutated.Spec.Containers[0].VolumeMounts[0].MountPath).To(Equal("/var/run/nri"))
+		Expect(mutated.Spec.Volumes).To(HaveLen(1))
+		Expect(mutated.Spec.Volumes[0].Name).To(Equal("nri-info"))
+		Expect(itemPaths(mutated.Spec.Volumes[0])).To(Equal([]string{"labels"}))
+	})
+
+	It("should use the volume requested by the pod annotations", func() {
+		switches.InitControlSwitches()
+		mutated, err := mutate(newPod(map[string]string{
+			downwardAPIVolumeNameKey: "netinfo",
+			downwardAPIMountPathKey:  "/run/netinfo",
+			downwardAPIContainersKey: "init, sidecar",
+			downwardAPIItemsKey:      "annotations,hugepages",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountedContainers(mutated, "netinfo")).To(Equal([]string{"init", "sidecar"}))
+		Expect(mutated.Spec.Containers[1].VolumeMounts[0].MountPath).To(Equal("/run/netinfo"))
+		Expect(itemPaths(mutated.Spec.Volumes[0])).To(ConsistOf("annotations", "hugepages_2M_limit_app"))
+	})
+
+	It("should mount the volume in the container named by the resource injection annotation", func() {
+		switches.InitControlSwitches()
+		mutated, err := mutate(newPod(map[string]string{
+			resourceInjectionContainerKey: "sidecar",
+			downwardAPIContainersKey:      controlswitches.DownwardAPIContainersResources,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountedContainers(mutated, "podnetinfo")).To(Equal([]string{"sidecar"}))
+	})
+
+	It("should warn and skip the injection when the pod has a conflicting volume", func() {
+		switches.InitControlSwitches()
+		pod := newPod(nil)
+		pod.Spec.Volumes = []corev1.Volume{{Name: "podnetinfo", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+		state := &MutationState{ControlSwitches: switches, ResourceRequests: map[string]int64{"intel.com/sriov": 1}}
+		mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mountedContainers(mutated, "podnetinfo")).To(BeEmpty())
+		Expect(mutated.Spec.Volumes).To(Equal(pod.Spec.Volumes))
+		Expect(state.Warnings).To(ConsistOf(
+			"volume podnetinfo of the pod isn't a Downward API volume, network information isn't exposed, " +
+				"use annotation k8s.v1.cni.cncf.io/downward-api-volume-name to choose another volume name"))
+	})
+
+	It("should warn about items of the existing volume projecting other fields", func() {
+		switches.InitControlSwitches()
+		pod := newPod(map[string]string{"note": "test"})
+		pod.Spec.Volumes = []corev1.Volume{{Name: "podnetinfo", VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+			Items: []corev1.DownwardAPIVolumeFile{{Path: "labels", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		}}}}
+		state := &MutationState{ControlSwitches: switches, ResourceRequests: map[string]int64{"intel.com/sriov": 1}}
+		mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(itemPaths(mutated.Spec.Volumes[0])).To(ConsistOf("labels", "annotations", "hugepages_2M_limit_app"))
+		Expect(mutated.Spec.Volumes[0].DownwardAPI.Items[0].FieldRef.FieldPath).To(Equal("metadata.name"))
+		Expect(state.Warnings).To(ConsistOf(
+			"item labels of Downward API volume podnetinfo projects another field than network resources injector expects, it is kept"))
+	})
+
+	It("should not warn about items of the existing volume projecting the same fields", func() {
+		switches.InitControlSwitches()
+		pod := newPod(nil)
+		pod.Spec.Volumes = []corev1.Volume{{Name: "podnetinfo", VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+			Items: []corev1.DownwardAPIVolumeFile{{Path: "labels", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels"}}},
+		}}}}
+		state := &MutationState{ControlSwitches: switches, ResourceRequests: map[string]int64{"intel.com/sriov": 1}}
+		mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, state)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(itemPaths(mutated.Spec.Volumes[0])).To(ConsistOf("labels", "hugepages_2M_limit_app"))
+		Expect(state.Warnings).To(BeEmpty())
+	})
+
+	It("should return the conflict in the admission response", func() {
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		switches.InitControlSwitches()
+		wh := newTestWebhook(switches, map[string]map[string]string{
+			"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+		})
+		pod := newPod(map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})
+		pod.Spec.Volumes = []corev1.Volume{{Name: "podnetinfo", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+		w := httptest.NewRecorder()
+		wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+		ar := admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+		Expect(ar.Response.Allowed).To(BeTrue())
+		Expect(ar.Response.Warnings).To(ConsistOf(ContainSubstring("volume podnetinfo of the pod isn't a Downward API volume")))
+	})
+
+	DescribeTable("Rejecting invalid pod annotations",
+		func(key, value, message string) {
+			switches.InitControlSwitches()
+			_, err := mutate(newPod(map[string]string{key: value}))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("volume name", downwardAPIVolumeNameKey, "Net_Info", `invalid Downward API volume name "Net_Info"`),
+		Entry("mount path", downwardAPIMountPathKey, "run/netinfo", `invalid Downward API mount path "run/netinfo"`),
+		Entry("items", downwardAPIItemsKey, "labels,env", `invalid Downward API item "env"`),
+		Entry("containers", downwardAPIContainersKey, "app,proxy", `container "proxy" doesn't exist`),
+	)
+})
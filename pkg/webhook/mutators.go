@@ -0,0 +1,392 @@
+package webhook
+
+import (
+	"context"
+	"slices"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+// names of the built-in mutators, can be used as anchors when registering custom mutators
+const (
+	ResourcesMutatorName              = "resources"
+	ResourceClaimsMutatorName         = "resource-claims"
+	CapabilitiesMutatorName           = "capabilities"
+	NetworkResourcesMapMutatorName    = "network-resources-map"
+	HugepagesDownwardAPIMutatorName   = "hugepages-downward-api"
+	DownwardAPIVolumeMutatorName      = "downward-api-volume"
+	UserDefinedAnnotationsMutatorName = "user-defined-annotations"
+	NodeSelectorMutatorName           = "node-selector"
+)
+
+// MutationState holds data shared by all mutators during a single admission request
+type MutationState struct {
+	// ControlSwitches active configuration of the features
+	ControlSwitches *controlswitches.ControlSwitches
+	// ResourceRequests number of network resources needed by the pod, indexed by resource name
+	ResourceRequests map[string]int64
+	// NodeSelectors node labels required by the networks of the pod
+	NodeSelectors map[string]string
+	// ResourceClaims claims of DRA devices needed by the networks of the pod, one per net-attach-def
+	ResourceClaims []NetworkResourceClaim
+	// Capabilities Linux capabilities needed by the networks of the pod
+	Capabilities []corev1.Capability
+	// NetworkResources device resources of the networks of the pod, indexed by namespace/name@interface
+	NetworkResources map[string][]NetworkResource
+	// UserDefinedPatch user defined injections matching the pod labels
+	UserDefinedPatch []types.JSONPatchOperation
+	// Warnings about the mutation returned in the admission response
+	Warnings []string
+
+	// hugepages which should be exposed through the Downward API volume
+	hugepageResources []hugepageResourceData
+}
+
+// hasNetworkResources returns true when network resources or resource claims of DRA devices are injected into
+// the pod, the DRA replace injection mode injects only resource claims
+func (s *MutationState) hasNetworkResources() bool {
+	return len(s.ResourceRequests) > 0 || len(s.ResourceClaims) > 0
+}
+
+// Mutator is a single step of the pod mutation pipeline
+type Mutator interface {
+	// Name returns a name which identifies the mutator in the registry
+	Name() string
+	// Enabled reports if the mutator should run with the given control switches
+	Enabled(switches *controlswitches.ControlSwitches) bool
+	// Mutate modifies the pod in place
+	Mutate(ctx context.Context, pod *corev1.Pod, state *MutationState) error
+}
+
+// MutatorRegistry keeps mutators in the order in which they are executed
+type MutatorRegistry struct {
+	mutators []Mutator
+}
+
+// NewMutatorRegistry returns registry with the given mutators executed in the given order, it fails when two
+// mutators have the same name
+func NewMutatorRegistry(mutators ...Mutator) (*MutatorRegistry, error) {
+	registry := &MutatorRegistry{}
+	for _, mutator := range mutators {
+		if err := registry.Register(mutator); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// MustNewMutatorRegistry is like NewMutatorRegistry but panics when the registry can't be created
+func MustNewMutatorRegistry(mutators ...Mutator) *MutatorRegistry {
+	registry, err := NewMutatorRegistry(mutators...)
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// NewDefaultMutatorRegistry returns registry with all built-in mutators
+func NewDefaultMutatorRegistry() *MutatorRegistry {
+	return MustNewMutatorRegistry(
+		&resourcesMutator{},
+		&resourceClaimsMutator{},
+		&capabilitiesMutator{},
+		&networkResourcesMapMutator{},
+		&hugepagesDownwardAPIMutator{},
+		&downwardAPIVolumeMutator{},
+		&userDefinedAnnotationsMutator{},
+		&nodeSelectorMutator{},
+	)
+}
+
+// Register appends the mutator at the end of the pipeline
+func (r *MutatorRegistry) Register(mutator Mutator) error {
+	return r.insert(len(r.mutators), mutator)
+}
+
+// RegisterBefore inserts the mutator right before the mutator with the given name
+func (r *MutatorRegistry) RegisterBefore(name string, mutator Mutator) error {
+	index := r.index(name)
+	if index < 0 {
+		return errors.Errorf("mutator %s is not registered", name)
+	}
+	return r.insert(index, mutator)
+}
+
+// RegisterAfter inserts the mutator right after the mutator with the given name
+func (r *MutatorRegistry) RegisterAfter(name string, mutator Mutator) error {
+	index := r.index(name)
+	if index < 0 {
+		return errors.Errorf("mutator %s is not registered", name)
+	}
+	return r.insert(index+1, mutator)
+}
+
+// Names returns names of the registered mutators in the execution order
+func (r *MutatorRegistry) Names() []string {
+	names := make([]string, 0, len(r.mutators))
+	for _, mutator := range r.mutators {
+		names = append(names, mutator.Name())
+	}
+	return names
+}
+
+// Mutate runs all enabled mutators on a deep copy of the pod, the original pod is left untouched
+func (r *MutatorRegistry) Mutate(ctx context.Context, pod *corev1.Pod, state *MutationState) (*corev1.Pod, error) {
+	mutatedPod := pod.DeepCopy()
+	for _, mutator := range r.mutators {
+		if !mutator.Enabled(state.ControlSwitches) {
+			glog.V(2).Infof("mutator %s is disabled, skipping", mutator.Name())
+			continue
+		}
+		if err := mutator.Mutate(ctx, mutatedPod, state); err != nil {
+			return nil, errors.Wrapf(err, "mutator %s failed", mutator.Name())
+		}
+	}
+	return mutatedPod, nil
+}
+
+func (r *MutatorRegistry) index(name string) int {
+	return slices.IndexFunc(r.mutators, func(mutator Mutator) bool {
+		return mutator.Name() == name
+	})
+}
+
+func (r *MutatorRegistry) insert(index int, mutator Mutator) error {
+	if r.index(mutator.Name()) >= 0 {
+		return errors.Errorf("mutator %s is already registered", mutator.Name())
+	}
+	r.mutators = slices.Insert(r.mutators, index, mutator)
+	return nil
+}
+
+// resourcesMutator requests network resources in the first container or in the container named by the pod
+// annotation, resources supported in the pod-level resources are injected there as well when the pod defines
+// them, depending on the pod-level resources mode
+type resourcesMutator struct{}
+
+func (m *resourcesMutator) Name() string {
+	return ResourcesMutatorName
+}
+
+func (m *resourcesMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return true
+}
+
+func (m *resourcesMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if len(state.ResourceRequests) == 0 {
+		glog.Infof("pod %s/%s doesn't need any custom network resources", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		return nil
+	}
+	containerRequests := state.ResourceRequests
+	if mode := state.ControlSwitches.GetPodLevelResourcesMode(); mode != controlswitches.PodLevelResourcesModeContainer && hasPodLevelResources(pod) {
+		podLevelRequests, otherRequests := splitPodLevelResources(state.ResourceRequests)
+		glog.Infof("pod %s/%s has pod-level resources, injecting %v at pod level", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, podLevelRequests)
+		addPodLevelResources(pod, podLevelRequests)
+		if mode == controlswitches.PodLevelResourcesModePod {
+			containerRequests = otherRequests
+		}
+	}
+	if len(containerRequests) == 0 {
+		return nil
+	}
+	target, err := getResourceInjectionTarget(pod)
+	if err != nil {
+		return err
+	}
+	if state.ControlSwitches.IsHonorExistingResourcesEnabled() {
+		updateResources(target, containerRequests)
+	} else {
+		addResources(pod, target, containerRequests)
+	}
+	return nil
+}
+
+// resourceClaimsMutator adds resource claims of the networks to the pod and requests them in the same container
+// as the network resources
+type resourceClaimsMutator struct{}
+
+func (m *resourceClaimsMutator) Name() string {
+	return ResourceClaimsMutatorName
+}
+
+func (m *resourceClaimsMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return true
+}
+
+func (m *resourceClaimsMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if len(state.ResourceClaims) == 0 {
+		return nil
+	}
+	target, err := getResourceInjectionTarget(pod)
+	if err != nil {
+		return err
+	}
+	addResourceClaims(pod, target, state.ResourceClaims)
+	return nil
+}
+
+// capabilitiesMutator adds Linux capabilities of the networks to the security context of the same container as
+// the network resources
+type capabilitiesMutator struct{}
+
+func (m *capabilitiesMutator) Name() string {
+	return CapabilitiesMutatorName
+}
+
+func (m *capabilitiesMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return true
+}
+
+func (m *capabilitiesMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if len(state.Capabilities) == 0 {
+		return nil
+	}
+	target, err := getResourceInjectionTarget(pod)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		glog.Warningf("pod has no containers, skipping injection of capabilities %v", state.Capabilities)
+		return nil
+	}
+	addCapabilities(target, state.Capabilities)
+	return nil
+}
+
+// networkResourcesMapMutator exposes the map of pod networks to their device resources and device plugin env vars
+// in the pod annotation, which is mounted by the Downward API volume, and in the env var of the same container as
+// the network resources
+type networkResourcesMapMutator struct{}
+
+func (m *networkResourcesMapMutator) Name() string {
+	return NetworkResourcesMapMutatorName
+}
+
+func (m *networkResourcesMapMutator) Enabled(switches *controlswitches.ControlSwitches) bool {
+	return switches.IsNetworkResourcesMapEnabled()
+}
+
+func (m *networkResourcesMapMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if len(state.NetworkResources) == 0 {
+		return nil
+	}
+	networkResourcesMap, err := marshalNetworkResourcesMap(state.NetworkResources)
+	if err != nil {
+		return err
+	}
+	target, err := getResourceInjectionTarget(pod)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		glog.Warningf("pod has no containers, skipping injection of network resources map")
+		return nil
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[networkResourcesMapKey] = networkResourcesMap
+	addEnvVar(target, networkResourcesMapEnvName, networkResourcesMap)
+	return nil
+}
+
+// hugepagesDownwardAPIMutator determines if hugepages are being requested for a given container,
+// and if so, prepares the value to be exposed to the container via Downward API files, env vars or both
+type hugepagesDownwardAPIMutator struct{}
+
+func (m *hugepagesDownwardAPIMutator) Name() string {
+	return HugepagesDownwardAPIMutatorName
+}
+
+func (m *hugepagesDownwardAPIMutator) Enabled(switches *controlswitches.ControlSwitches) bool {
+	return switches.IsHugePagedownAPIEnabled()
+}
+
+func (m *hugepagesDownwardAPIMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if !state.hasNetworkResources() {
+		return nil
+	}
+	mode := state.ControlSwitches.GetHugepagesDownwardAPIMode()
+	/* the files are mounted in init containers only when the feature is enabled, env vars need no volume */
+	withFiles := mode != controlswitches.HugepagesDownwardAPIModeEnv
+	initWithFiles := withFiles && state.ControlSwitches.IsInitContainersEnabled()
+	hugepageResources := processHugepagesForDownwardAPI(pod.Spec.Containers, withFiles)
+	initHugepageResources := processHugepagesForDownwardAPI(pod.Spec.InitContainers, initWithFiles)
+	if mode != controlswitches.HugepagesDownwardAPIModeFiles {
+		addHugepageEnvVars(pod, append(hugepageResources, initHugepageResources...))
+	}
+	if withFiles {
+		state.hugepageResources = hugepageResources
+		if initWithFiles {
+			state.hugepageResources = append(state.hugepageResources, initHugepageResources...)
+		}
+	}
+	return nil
+}
+
+// downwardAPIVolumeMutator adds the Downward API volume and mounts it in the containers, init containers included
+// when the feature is enabled. The volume is configured by the control switches and the pod annotations.
+type downwardAPIVolumeMutator struct{}
+
+func (m *downwardAPIVolumeMutator) Name() string {
+	return DownwardAPIVolumeMutatorName
+}
+
+func (m *downwardAPIVolumeMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return true
+}
+
+func (m *downwardAPIVolumeMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if !state.hasNetworkResources() {
+		return nil
+	}
+	config, err := getDownwardAPIConfig(pod, state.ControlSwitches)
+	if err != nil {
+		return err
+	}
+	for _, warning := range addVolumes(pod, config, state.hugepageResources, state.ControlSwitches.IsInitContainersEnabled()) {
+		glog.Warningf("pod %s/%s: %s", pod.Namespace, pod.Name, warning)
+		state.Warnings = append(state.Warnings, warning)
+	}
+	return nil
+}
+
+// userDefinedAnnotationsMutator applies user defined injections to the pod annotations
+type userDefinedAnnotationsMutator struct{}
+
+func (m *userDefinedAnnotationsMutator) Name() string {
+	return UserDefinedAnnotationsMutatorName
+}
+
+func (m *userDefinedAnnotationsMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return true
+}
+
+func (m *userDefinedAnnotationsMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	if !state.hasNetworkResources() {
+		return nil
+	}
+	applyUserDefinedPatch(pod, state.UserDefinedPatch)
+	return nil
+}
+
+// nodeSelectorMutator adds node selectors required by the networks to the pod spec
+type nodeSelectorMutator struct{}
+
+func (m *nodeSelectorMutator) Name() string {
+	return NodeSelectorMutatorName
+}
+
+func (m *nodeSelectorMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return true
+}
+
+func (m *nodeSelectorMutator) Mutate(_ context.Context, pod *corev1.Pod, state *MutationState) error {
+	addNodeSelector(pod, state.NodeSelectors)
+	return nil
+}
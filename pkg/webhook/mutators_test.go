@@ -0,0 +1,315 @@
+package webhook
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+// fakeMutator adds an annotation with its name to the pod
+type fakeMutator struct {
+	name    string
+	enabled bool
+	err     error
+}
+
+func (m *fakeMutator) Name() string {
+	return m.name
+}
+
+func (m *fakeMutator) Enabled(_ *controlswitches.ControlSwitches) bool {
+	return m.enabled
+}
+
+func (m *fakeMutator) Mutate(_ context.Context, pod *corev1.Pod, _ *MutationState) error {
+	if m.err != nil {
+		return m.err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations["order"] += m.name
+	return nil
+}
+
+var _ = Describe("Mutator registry", func() {
+	var switches *controlswitches.ControlSwitches
+
+	BeforeEach(func() {
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+		switches.InitControlSwitches()
+	})
+
+	Context("Default registry", func() {
+		It("should contain built-in mutators in the execution order", func() {
+			Expect(NewDefaultMutatorRegistry().Names()).To(Equal([]string{
+				ResourcesMutatorName,
+				ResourceClaimsMutatorName,
+				CapabilitiesMutatorName,
+				NetworkResourcesMapMutatorName,
+				HugepagesDownwardAPIMutatorName,
+				DownwardAPIVolumeMutatorName,
+				UserDefinedAnnotationsMutatorName,
+				NodeSelectorMutatorName,
+			}))
+		})
+	})
+
+	Context("Registering mutators", func() {
+		It("should insert mutators relative to the registered ones", func() {
+			registry := MustNewMutatorRegistry(&fakeMutator{name: "a", enabled: true}, &fakeMutator{name: "c", enabled: true})
+			Expect(registry.RegisterBefore("a", &fakeMutator{name: "0", enabled: true})).To(Succeed())
+			Expect(registry.RegisterAfter("a", &fakeMutator{name: "b", enabled: true})).To(Succeed())
+			Expect(registry.Register(&fakeMutator{name: "d", enabled: true})).To(Succeed())
+			Expect(registry.Names()).To(Equal([]string{"0", "a", "b", "c", "d"}))
+
+			pod, err := registry.Mutate(context.Background(), &corev1.Pod{}, &MutationState{ControlSwitches: switches})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Annotations["order"]).To(Equal("0abcd"))
+		})
+
+		It("should refuse duplicated names", func() {
+			registry := MustNewMutatorRegistry(&fakeMutator{name: "a"})
+			Expect(registry.Register(&fakeMutator{name: "a"})).NotTo(Succeed())
+		})
+
+		It("should return error instead of registry with duplicated names", func() {
+			registry, err := NewMutatorRegistry(&fakeMutator{name: "a"}, &fakeMutator{name: "a"})
+			Expect(err).To(MatchError(ContainSubstring("mutator a is already registered")))
+			Expect(registry).To(BeNil())
+			Expect(func() { MustNewMutatorRegistry(&fakeMutator{name: "a"}, &fakeMutator{name: "a"}) }).To(Panic())
+		})
+
+		It("should refuse unknown anchors", func() {
+			registry := MustNewMutatorRegistry(&fakeMutator{name: "a"})
+			Expect(registry.RegisterAfter("b", &fakeMutator{name: "c"})).NotTo(Succeed())
+			Expect(registry.RegisterBefore("b", &fakeMutator{name: "c"})).NotTo(Succeed())
+		})
+	})
+
+	Context("Running mutators", func() {
+		It("should skip disabled mutators", func() {
+			registry := MustNewMutatorRegistry(&fakeMutator{name: "a", enabled: true}, &fakeMutator{name: "b"})
+			pod, err := registry.Mutate(context.Background(), &corev1.Pod{}, &MutationState{ControlSwitches: switches})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Annotations["order"]).To(Equal("a"))
+		})
+
+		It("should not modify the original pod", func() {
+			registry := MustNewMutatorRegistry(&fakeMutator{name: "a", enabled: true})
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"order": ""}}}
+			mutated, err := registry.Mutate(context.Background(), pod, &MutationState{ControlSwitches: switches})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Annotations["order"]).To(BeEmpty())
+			Expect(mutated.Annotations["order"]).To(Equal("a"))
+		})
+
+		It("should stop on the first error", func() {
+			registry := MustNewMutatorRegistry(
+				&fakeMutator{name: "a", enabled: true, err: errors.New("failure")},
+				&fakeMutator{name: "b", enabled: true},
+			)
+			_, err := registry.Mutate(context.Background(), &corev1.Pod{}, &MutationState{ControlSwitches: switches})
+			Expect(err).To(MatchError(ContainSubstring("mutator a failed")))
+		})
+
+		It("should inject the Downward API volume and user defined annotations for resource claims only", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{
+				ControlSwitches: switches,
+				ResourceClaims:  []NetworkResourceClaim{{Name: "sriov-net", ResourceClaimTemplateName: "sriov-net"}},
+				UserDefinedPatch: []types.JSONPatchOperation{{
+					Operation: patchOperationAdd,
+					Path:      metadataAnnotationsPath,
+					Value:     map[string]interface{}{"team": "net"},
+				}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mutated.Spec.ResourceClaims).To(HaveLen(1))
+			Expect(mutated.Spec.Volumes).To(ConsistOf(HaveField("Name", "podnetinfo")))
+			Expect(mutated.Spec.Containers[0].VolumeMounts).To(ConsistOf(HaveField("Name", "podnetinfo")))
+			Expect(mutated.Annotations).To(HaveKeyWithValue("team", "net"))
+		})
+
+		It("should run hugepages mutator only when enabled", func() {
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(true), createBool(false), createString(""))
+			switches.InitControlSwitches()
+			Expect((&hugepagesDownwardAPIMutator{}).Enabled(switches)).To(BeTrue())
+
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			switches.InitControlSwitches()
+			Expect((&hugepagesDownwardAPIMutator{}).Enabled(switches)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Init and sidecar containers", func() {
+	var switches *controlswitches.ControlSwitches
+	always := corev1.ContainerRestartPolicyAlways
+
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "proxy", RestartPolicy: &always}},
+				Containers:     []corev1.Container{{Name: "app"}},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(true), createBool(false), createString(""))
+		switches.InitControlSwitches()
+	})
+
+	It("should target the first container by default", func() {
+		pod := newPod(nil)
+		target, err := getResourceInjectionTarget(pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(BeIdenticalTo(&pod.Spec.Containers[0]))
+	})
+
+	It("should target the sidecar container named by the annotation", func() {
+		pod := newPod(map[string]string{resourceInjectionContainerKey: "proxy"})
+		target, err := getResourceInjectionTarget(pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(BeIdenticalTo(&pod.Spec.InitContainers[0]))
+	})
+
+	It("should fail when the annotated container does not exist", func() {
+		pod := newPod(map[string]string{resourceInjectionContainerKey: "missing"})
+		err := (&resourcesMutator{}).Mutate(context.Background(), pod, &MutationState{
+			ControlSwitches:  switches,
+			ResourceRequests: map[string]int64{"intel.com/sriov": 1},
+		})
+		Expect(err).To(MatchError(ContainSubstring(`container "missing" of annotation`)))
+	})
+
+	It("should patch resources of the init container", func() {
+		pod := newPod(map[string]string{resourceInjectionContainerKey: "proxy"})
+		mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{
+			ControlSwitches:  switches,
+			ResourceRequests: map[string]int64{"intel.com/sriov": 1},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mutated.Spec.Containers[0].Resources.Requests).To(BeEmpty())
+
+		patch, err := createPodPatch(marshalPod(pod), pod, mutated)
+		Expect(err).NotTo(HaveOccurred())
+		paths := []string{}
+		for _, operation := range patch {
+			paths = append(paths, operation.Path)
+		}
+		Expect(paths).To(ContainElement(HavePrefix("/spec/initContainers/0/resources")))
+	})
+
+	It("should skip resources already requested by an init container", func() {
+		pod := newPod(nil)
+		pod.Spec.InitContainers[0].Resources.Limits = corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")}
+		addResources(pod, &pod.Spec.Containers[0], map[string]int64{"intel.com/sriov": 1})
+		Expect(pod.Spec.Containers[0].Resources.Limits).To(BeEmpty())
+	})
+
+	It("should mount the Downward API volume and expose hugepages in init containers only when enabled", func() {
+		pod := newPod(nil)
+		pod.Spec.InitContainers[0].Resources.Requests = corev1.ResourceList{"hugepages-2Mi": resource.MustParse("4Mi")}
+
+		requests := map[string]int64{"intel.com/sriov": 1}
+
+		mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{ControlSwitches: switches, ResourceRequests: requests})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mutated.Spec.InitContainers[0].VolumeMounts).To(BeEmpty())
+		Expect(mutated.Spec.Containers[0].VolumeMounts).To(HaveLen(1))
+
+		switches.SetInitContainersUnitTests(true)
+		switches.InitControlSwitches()
+		mutated, err = NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{ControlSwitches: switches, ResourceRequests: requests})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mutated.Spec.InitContainers[0].VolumeMounts).To(HaveLen(1))
+		Expect(mutated.Spec.InitContainers[0].VolumeMounts[0].Name).To(Equal(types.DownwardAPIVolumeName))
+		Expect(mutated.Spec.InitContainers[0].Env).To(ContainElement(HaveField("Name", types.EnvNameContainerName)))
+		Expect(mutated.Spec.Volumes).To(HaveLen(1))
+		paths := []string{}
+		for _, item := range mutated.Spec.Volumes[0].DownwardAPI.Items {
+			paths = append(paths, item.Path)
+		}
+		Expect(paths).To(ContainElement("hugepages_2M_request_proxy"))
+	})
+
+	DescribeTable("Exposing hugepages in env vars of containers and init containers",
+		func(mode string, expectEnv, expectFiles bool) {
+			pod := newPod(nil)
+			pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi")}
+			pod.Spec.InitContainers[0].Resources.Requests = corev1.ResourceList{"hugepages-512Ki": resource.MustParse("4Mi")}
+			switches.SetInitContainersUnitTests(true)
+			switches.SetHugepagesDownwardAPIModeUnitTests(mode)
+			switches.InitControlSwitches()
+
+			mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{
+				ControlSwitches:  switches,
+				ResourceRequests: map[string]int64{"intel.com/sriov": 1},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			mebibyte := *resource.NewQuantity(1024*1024, resource.BinarySI)
+			limitEnv := corev1.EnvVar{Name: "HUGEPAGES_1G_LIMIT", ValueFrom: &corev1.EnvVarSource{
+				ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.hugepages-1Gi", Divisor: mebibyte},
+			}}
+			requestEnv := corev1.EnvVar{Name: "HUGEPAGES_512K_REQUEST", ValueFrom: &corev1.EnvVarSource{
+				ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "requests.hugepages-512Ki", Divisor: mebibyte},
+			}}
+			if expectEnv {
+				Expect(mutated.Spec.Containers[0].Env).To(ContainElement(limitEnv))
+				Expect(mutated.Spec.InitContainers[0].Env).To(ContainElement(requestEnv))
+			} else {
+				Expect(mutated.Spec.Containers[0].Env).NotTo(ContainElement(limitEnv))
+				Expect(mutated.Spec.InitContainers[0].Env).NotTo(ContainElement(requestEnv))
+			}
+
+			paths := []string{}
+			for _, item := range mutated.Spec.Volumes[0].DownwardAPI.Items {
+				paths = append(paths, item.Path)
+			}
+			if expectFiles {
+				Expect(paths).To(ContainElements("hugepages_1G_limit_app", "hugepages_512K_request_proxy"))
+			} else {
+				Expect(paths).NotTo(ContainElement(HavePrefix("hugepages_")))
+			}
+		},
+		Entry("files", controlswitches.HugepagesDownwardAPIModeFiles, false, true),
+		Entry("env", controlswitches.HugepagesDownwardAPIModeEnv, true, false),
+		Entry("both", controlswitches.HugepagesDownwardAPIModeBoth, true, true),
+	)
+
+	It("should expose hugepages in env vars of init containers without injecting into init containers", func() {
+		pod := newPod(nil)
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi")}
+		pod.Spec.InitContainers[0].Resources.Requests = corev1.ResourceList{"hugepages-512Ki": resource.MustParse("4Mi")}
+		switches.SetHugepagesDownwardAPIModeUnitTests(controlswitches.HugepagesDownwardAPIModeEnv)
+		switches.InitControlSwitches()
+
+		mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, &MutationState{
+			ControlSwitches:  switches,
+			ResourceRequests: map[string]int64{"intel.com/sriov": 1},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mutated.Spec.InitContainers[0].Env).To(ContainElement(HaveField("Name", "HUGEPAGES_512K_REQUEST")))
+		Expect(mutated.Spec.InitContainers[0].VolumeMounts).To(BeEmpty())
+		for _, container := range append(mutated.Spec.Containers, mutated.Spec.InitContainers...) {
+			Expect(container.Env).NotTo(ContainElement(HaveField("Name", types.EnvNameContainerName)))
+		}
+	})
+})
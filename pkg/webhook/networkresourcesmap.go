@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	multus "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
+)
+
+const (
+	// networkResourcesMapKey pod annotation with the JSON map of pod networks to their device resources, exposed
+	// through the Downward API volume
+	networkResourcesMapKey = "k8s.v1.cni.cncf.io/network-resources-map"
+	// networkResourcesMapEnvName env var with the JSON map of pod networks to their device resources
+	networkResourcesMapEnvName = "NETWORK_RESOURCES_MAP"
+	// defaultNetworkInterface name of the pod interface attached to the default network by multus
+	defaultNetworkInterface = "eth0"
+	// devicePluginEnvPrefix prefix of the env vars with the device IDs allocated by the device plugins
+	devicePluginEnvPrefix = "PCIDEVICE_"
+)
+
+var devicePluginEnvRegex = regexp.MustCompile(`[^A-Z0-9]`)
+
+// NetworkResource device resource used by a pod network and the env var in which the device plugin passes the
+// allocated device IDs to the container
+type NetworkResource struct {
+	ResourceName string `json:"resourceName"`
+	EnvVar       string `json:"envVar"`
+}
+
+// getDevicePluginEnvVar returns the env var set by the device plugin for the resource, e.g. intel.com/sriov is
+// passed as PCIDEVICE_INTEL_COM_SRIOV
+func getDevicePluginEnvVar(resourceName string) string {
+	return devicePluginEnvPrefix + devicePluginEnvRegex.ReplaceAllString(strings.ToUpper(resourceName), "_")
+}
+
+// getNetworkInterfaceName returns the name of the pod interface attached to the network at the index, multus names
+// interfaces of the additional networks net1, net2... unless the network selection requests the name
+func getNetworkInterfaceName(networks []*multus.NetworkSelectionElement, index int) string {
+	if networks[index].InterfaceRequest != "" {
+		return networks[index].InterfaceRequest
+	}
+	/* the default network is always the first one */
+	if networks[0].InterfaceRequest == defaultNetworkInterface {
+		return fmt.Sprintf("net%d", index)
+	}
+	return fmt.Sprintf("net%d", index+1)
+}
+
+// getNetworkResources returns the resources of a single network sorted by name
+func getNetworkResources(resourceRequests map[string]int64) []NetworkResource {
+	networkResources := make([]NetworkResource, 0, len(resourceRequests))
+	for resourceName := range resourceRequests {
+		networkResources = append(networkResources, NetworkResource{
+			ResourceName: resourceName,
+			EnvVar:       getDevicePluginEnvVar(resourceName),
+		})
+	}
+	sort.Slice(networkResources, func(i, j int) bool {
+		return networkResources[i].ResourceName < networkResources[j].ResourceName
+	})
+	return networkResources
+}
+
+// marshalNetworkResourcesMap returns the JSON map of the network selections, namespace/name@interface, to their
+// resources
+func marshalNetworkResourcesMap(networkResources map[string][]NetworkResource) (string, error) {
+	data, err := json.Marshal(networkResources)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
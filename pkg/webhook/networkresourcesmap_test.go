@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	evanphx "gopkg.in/evanphx/json-patch.v4"
+	multus "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+var _ = Describe("Network resources map", func() {
+	DescribeTable("Getting device plugin env var",
+		func(resourceName, expected string) {
+			Expect(getDevicePluginEnvVar(resourceName)).To(Equal(expected))
+		},
+		Entry("vendor and name", "intel.com/sriov", "PCIDEVICE_INTEL_COM_SRIOV"),
+		Entry("mixed case and dashes", "nvidia.com/rdma-Net_1", "PCIDEVICE_NVIDIA_COM_RDMA_NET_1"),
+	)
+
+	DescribeTable("Getting network interface name",
+		func(networks []*multus.NetworkSelectionElement, index int, expected string) {
+			Expect(getNetworkInterfaceName(networks, index)).To(Equal(expected))
+		},
+		Entry("first additional network",
+			[]*multus.NetworkSelectionElement{{Name: "a"}, {Name: "b"}}, 0, "net1"),
+		Entry("second additional network",
+			[]*multus.NetworkSelectionElement{{Name: "a"}, {Name: "b"}}, 1, "net2"),
+		Entry("requested interface",
+			[]*multus.NetworkSelectionElement{{Name: "a"}, {Name: "b", InterfaceRequest: "sriov0"}}, 1, "sriov0"),
+		Entry("default network",
+			[]*multus.NetworkSelectionElement{{Name: "a", InterfaceRequest: defaultNetworkInterface}, {Name: "b"}}, 0, "eth0"),
+		Entry("additional network after default network",
+			[]*multus.NetworkSelectionElement{{Name: "a", InterfaceRequest: defaultNetworkInterface}, {Name: "b"}}, 1, "net1"),
+	)
+
+	Describe("Admission", func() {
+		var switches *controlswitches.ControlSwitches
+
+		mutate := func(annotations map[string]string) *corev1.Pod {
+			wh := newTestWebhook(switches, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/rdma-net":  {"k8s.v1.cni.cncf.io/resourceName": "nvidia.com/rdma"},
+				"default/plain-net": {},
+			})
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			Expect(ar.Response.Allowed).To(BeTrue())
+
+			patch, err := evanphx.DecodePatch(ar.Response.Patch)
+			Expect(err).NotTo(HaveOccurred())
+			podJSON, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			patchedJSON, err := patch.Apply(podJSON)
+			Expect(err).NotTo(HaveOccurred())
+			patched := &corev1.Pod{}
+			Expect(json.Unmarshal(patchedJSON, patched)).To(Succeed())
+			return patched
+		}
+
+		BeforeEach(func() {
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		})
+
+		It("should not inject the map by default", func() {
+			switches.InitControlSwitches()
+			patched := mutate(map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"})
+			Expect(patched.Annotations).NotTo(HaveKey(networkResourcesMapKey))
+			Expect(patched.Spec.Containers[0].Env).To(BeEmpty())
+		})
+
+		It("should inject the map in the env var, the annotation and the Downward API volume", func() {
+			switches.SetNetworkResourcesMapUnitTests(true)
+			switches.InitControlSwitches()
+			patched := mutate(map[string]string{
+				"k8s.v1.cni.cncf.io/networks": "sriov-net,plain-net,rdma-net@rdma0,sriov-net",
+			})
+
+			expected := `{"default/rdma-net@rdma0":[{"resourceName":"nvidia.com/rdma","envVar":"PCIDEVICE_NVIDIA_COM_RDMA"}],` +
+				`"default/sriov-net@net1":[{"resourceName":"intel.com/sriov","envVar":"PCIDEVICE_INTEL_COM_SRIOV"}],` +
+				`"default/sriov-net@net4":[{"resourceName":"intel.com/sriov","envVar":"PCIDEVICE_INTEL_COM_SRIOV"}]}`
+			Expect(patched.Annotations).To(HaveKeyWithValue(networkResourcesMapKey, expected))
+			Expect(patched.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: networkResourcesMapEnvName, Value: expected}))
+			Expect(patched.Spec.Volumes[0].DownwardAPI.Items).To(ContainElement(corev1.DownwardAPIVolumeFile{
+				Path:     types.NetworkResourcesMapPath,
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.annotations['k8s.v1.cni.cncf.io/network-resources-map']"},
+			}))
+		})
+
+		It("should name the default network interface eth0", func() {
+			switches.SetNetworkResourcesMapUnitTests(true)
+			switches.InitControlSwitches()
+			patched := mutate(map[string]string{
+				"v1.multus-cni.io/default-network": "sriov-net",
+				"k8s.v1.cni.cncf.io/networks":      "rdma-net",
+			})
+			Expect(patched.Annotations[networkResourcesMapKey]).To(And(
+				ContainSubstring(`"default/sriov-net@eth0"`), ContainSubstring(`"default/rdma-net@net1"`)))
+		})
+	})
+})
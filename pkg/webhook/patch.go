@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// createPodPatch computes the RFC 6902 operations which transform the pod of the admission request into the mutated
+// one. The operations are computed against the raw object of the request, which is what the API server patches, so
+// fields unknown to this client and fields which are omitted by the request are handled correctly. Only changes
+// between the original and the mutated pod are carried over to the raw object.
+func createPodPatch(raw []byte, original, mutated *corev1.Pod) ([]jsonpatch.JsonPatchOperation, error) {
+	var rawObject, originalObject, mutatedObject interface{}
+	if err := json.Unmarshal(raw, &rawObject); err != nil {
+		return nil, errors.Wrap(err, "error parsing raw pod")
+	}
+	if err := toJSONObject(original, &originalObject); err != nil {
+		return nil, errors.Wrap(err, "error serializing original pod")
+	}
+	if err := toJSONObject(mutated, &mutatedObject); err != nil {
+		return nil, errors.Wrap(err, "error serializing mutated pod")
+	}
+
+	targetJSON, err := json.Marshal(mergeJSONChanges(rawObject, originalObject, mutatedObject))
+	if err != nil {
+		return nil, errors.Wrap(err, "error serializing patched pod")
+	}
+	patch, err := jsonpatch.CreatePatch(raw, targetJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "error computing JSON patch")
+	}
+
+	return patch, nil
+}
+
+func toJSONObject(pod *corev1.Pod, object *interface{}) error {
+	data, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, object)
+}
+
+// mergeJSONChanges applies the differences between the original and the mutated JSON values to the raw value. Object
+// members which are not part of the original value are kept, array elements are merged by their index as long as
+// the mutation only appends elements.
+func mergeJSONChanges(raw, original, mutated interface{}) interface{} {
+	if reflect.DeepEqual(original, mutated) {
+		return raw
+	}
+
+	switch mutatedValue := mutated.(type) {
+	case map[string]interface{}:
+		originalMap, _ := original.(map[string]interface{})
+		rawMap, isMap := raw.(map[string]interface{})
+		if !isMap {
+			rawMap = map[string]interface{}{}
+		}
+		merged := make(map[string]interface{}, len(rawMap)+len(mutatedValue))
+		for key, value := range rawMap {
+			merged[key] = value
+		}
+		for key := range originalMap {
+			if _, exists := mutatedValue[key]; !exists {
+				delete(merged, key)
+			}
+		}
+		for key, value := range mutatedValue {
+			rawValue, exists := rawMap[key]
+			if !exists && reflect.DeepEqual(originalMap[key], value) {
+				/* omitted by the request and not mutated */
+				continue
+			}
+			merged[key] = mergeJSONChanges(rawValue, originalMap[key], value)
+		}
+		return merged
+	case []interface{}:
+		originalSlice, _ := original.([]interface{})
+		rawSlice, isSlice := raw.([]interface{})
+		if !isSlice || len(rawSlice) != len(originalSlice) || len(mutatedValue) < len(originalSlice) {
+			return mutated
+		}
+		merged := make([]interface{}, len(mutatedValue))
+		for index, value := range mutatedValue {
+			if index < len(rawSlice) {
+				merged[index] = mergeJSONChanges(rawSlice[index], originalSlice[index], value)
+			} else {
+				merged[index] = value
+			}
+		}
+		return merged
+	}
+	return mutated
+}
+
+// setResponsePatch sets the JSON patch of the allowed admission response, empty patch is omitted
+func setResponsePatch(ar *admissionv1.AdmissionReview, patch []jsonpatch.JsonPatchOperation) {
+	if len(patch) == 0 {
+		return
+	}
+	patchBytes, _ := json.Marshal(patch)
+	ar.Response.Patch = patchBytes
+	ar.Response.PatchType = func() *admissionv1.PatchType {
+		pt := admissionv1.PatchTypeJSONPatch
+		return &pt
+	}()
+}
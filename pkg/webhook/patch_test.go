@@ -0,0 +1,310 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing/quick"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"gomodules.xyz/jsonpatch/v2"
+	evanphx "gopkg.in/evanphx/json-patch.v4"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	nritypes "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+)
+
+// resource names and map keys deliberately contain JSON pointer special characters
+var (
+	randomResourceNames = []string{"intel.com/sriov", "example.com/foo~bar", "hugepages-1Gi", "hugepages-2Mi", "memory", "cpu"}
+	randomMapKeys       = []string{"app", "k8s.v1.cni.cncf.io/networks", "a~b/c", "kubernetes.io/hostname", "zone"}
+)
+
+func marshalPod(pod *corev1.Pod) []byte {
+	podJSON, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+	return podJSON
+}
+
+func randomPick(r *rand.Rand, items []string) string {
+	return items[r.Intn(len(items))]
+}
+
+// randomStringMap returns either a nil, an empty or a populated map
+func randomStringMap(r *rand.Rand) map[string]string {
+	switch r.Intn(3) {
+	case 0:
+		return nil
+	case 1:
+		return map[string]string{}
+	}
+	m := map[string]string{}
+	for i := 0; i < 1+r.Intn(3); i++ {
+		m[randomPick(r, randomMapKeys)] = fmt.Sprintf("value-%d", r.Intn(3))
+	}
+	return m
+}
+
+func randomResourceList(r *rand.Rand) corev1.ResourceList {
+	if r.Intn(3) == 0 {
+		return nil
+	}
+	list := corev1.ResourceList{}
+	for i := 0; i < r.Intn(3); i++ {
+		list[corev1.ResourceName(randomPick(r, randomResourceNames))] = *resource.NewQuantity(int64(r.Intn(3)), resource.DecimalSI)
+	}
+	return list
+}
+
+func randomContainer(r *rand.Rand, index int) corev1.Container {
+	container := corev1.Container{
+		Name:  fmt.Sprintf("container-%d", index),
+		Image: "busybox",
+		Resources: corev1.ResourceRequirements{
+			Requests: randomResourceList(r),
+			Limits:   randomResourceList(r),
+		},
+	}
+	if r.Intn(2) == 0 {
+		container.Env = []corev1.EnvVar{{Name: nritypes.EnvNameContainerName, Value: container.Name}}
+	}
+	if r.Intn(3) == 0 {
+		container.VolumeMounts = []corev1.VolumeMount{{Name: nritypes.DownwardAPIVolumeName, MountPath: nritypes.DownwardAPIMountPath}}
+	}
+	return container
+}
+
+func randomPod(r *rand.Rand) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			Namespace:   "default",
+			Labels:      randomStringMap(r),
+			Annotations: randomStringMap(r),
+		},
+		Spec: corev1.PodSpec{
+			NodeSelector: randomStringMap(r),
+		},
+	}
+	for i := 0; i < r.Intn(4); i++ {
+		pod.Spec.Containers = append(pod.Spec.Containers, randomContainer(r, i))
+	}
+	for i := 0; i < r.Intn(3); i++ {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, randomContainer(r, len(pod.Spec.Containers)+i))
+	}
+	if r.Intn(3) == 0 {
+		pod.Spec.Resources = &corev1.ResourceRequirements{Requests: corev1.ResourceList{"memory": resource.MustParse("1Gi")}}
+	}
+	switch r.Intn(3) {
+	case 0:
+		pod.Spec.Volumes = []corev1.Volume{{Name: nritypes.DownwardAPIVolumeName}}
+	case 1:
+		pod.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	}
+	return pod
+}
+
+func randomResourceRequests(r *rand.Rand) map[string]int64 {
+	requests := map[string]int64{}
+	for i := 0; i < r.Intn(3); i++ {
+		requests[randomPick(r, randomResourceNames[:2])] += int64(1 + r.Intn(2))
+	}
+	return requests
+}
+
+func randomResourceClaims(r *rand.Rand) []NetworkResourceClaim {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	return []NetworkResourceClaim{{Name: "sriov-net", ResourceClaimTemplateName: "sriov-net"}}
+}
+
+// randomRawPod serializes the pod the way another client could send it: with fields unknown to this client and
+// without the empty resources of the containers
+func randomRawPod(r *rand.Rand, pod *corev1.Pod) []byte {
+	podJSON, _ := json.Marshal(pod)
+	var object map[string]interface{}
+	_ = json.Unmarshal(podJSON, &object)
+	spec := object["spec"].(map[string]interface{})
+	if r.Intn(2) == 0 {
+		spec["unknownField"] = map[string]interface{}{"enabled": true}
+	}
+	for _, key := range []string{"containers", "initContainers"} {
+		containers, _ := spec[key].([]interface{})
+		for _, container := range containers {
+			container := container.(map[string]interface{})
+			if r.Intn(2) == 0 {
+				container["unknownField"] = "value"
+			}
+			if resources, _ := container["resources"].(map[string]interface{}); len(resources) == 0 {
+				delete(container, "resources")
+			}
+		}
+	}
+	raw, _ := json.Marshal(object)
+	return raw
+}
+
+func randomUserDefinedPatch(r *rand.Rand) []nritypes.JSONPatchOperation {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	return []nritypes.JSONPatchOperation{{
+		Operation: "add",
+		Path:      "/metadata/annotations",
+		Value:     map[string]interface{}{randomPick(r, randomMapKeys): "user-defined"},
+	}}
+}
+
+// mutatedPodMatchesPatch checks the property that applying the patch computed for a random pod and
+// random mutation inputs to the serialized original pod always yields the serialized mutated pod
+func mutatedPodMatchesPatch(seed int64) bool {
+	r := rand.New(rand.NewSource(seed))
+
+	structure := controlswitches.SetupControlSwitchesUnitTests(createBool(r.Intn(2) == 0), createBool(r.Intn(2) == 0), createString(""))
+	structure.InitControlSwitches()
+
+	pod := randomPod(r)
+	original := pod.DeepCopy()
+	state := &MutationState{
+		ControlSwitches:  structure,
+		ResourceRequests: randomResourceRequests(r),
+		NodeSelectors:    randomStringMap(r),
+		ResourceClaims:   randomResourceClaims(r),
+		UserDefinedPatch: randomUserDefinedPatch(r),
+	}
+	mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, state)
+	if err != nil {
+		fmt.Fprintf(GinkgoWriter, "seed %d: %v\n", seed, err)
+		return false
+	}
+	if !equality.Semantic.DeepEqual(pod, original) {
+		fmt.Fprintf(GinkgoWriter, "seed %d: original pod was modified by the mutation\n", seed)
+		return false
+	}
+
+	raw := randomRawPod(r, pod)
+	patch, err := createPodPatch(raw, pod, mutated)
+	if err != nil {
+		fmt.Fprintf(GinkgoWriter, "seed %d: %v\n", seed, err)
+		return false
+	}
+	patchJSON, _ := json.Marshal(patch)
+	decodedPatch, err := evanphx.DecodePatch(patchJSON)
+	if err != nil {
+		fmt.Fprintf(GinkgoWriter, "seed %d: %v\n", seed, err)
+		return false
+	}
+
+	patchedJSON, err := decodedPatch.Apply(raw)
+	if err != nil {
+		fmt.Fprintf(GinkgoWriter, "seed %d: failed to apply patch %s: %v\n", seed, patchJSON, err)
+		return false
+	}
+
+	patchedPod := &corev1.Pod{}
+	if err := json.Unmarshal(patchedJSON, patchedPod); err != nil {
+		fmt.Fprintf(GinkgoWriter, "seed %d: %v\n", seed, err)
+		return false
+	}
+	if !equality.Semantic.DeepEqual(patchedPod, mutated) {
+		fmt.Fprintf(GinkgoWriter, "seed %d: patch %s does not yield the mutated pod\n", seed, patchJSON)
+		return false
+	}
+	if bytes.Contains(raw, []byte("unknownField")) != bytes.Contains(patchedJSON, []byte("unknownField")) {
+		fmt.Fprintf(GinkgoWriter, "seed %d: patch %s does not keep unknown fields\n", seed, patchJSON)
+		return false
+	}
+	return true
+}
+
+var _ = Describe("Pod patch", func() {
+	var switches *controlswitches.ControlSwitches
+
+	BeforeEach(func() {
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+		switches.InitControlSwitches()
+	})
+
+	Context("Generated from a mutated pod", func() {
+		It("should always yield the mutated pod when applied to the original one", func() {
+			config := &quick.Config{MaxCount: 1000, Rand: rand.New(rand.NewSource(GinkgoRandomSeed()))}
+			Expect(quick.Check(mutatedPodMatchesPatch, config)).To(Succeed())
+		})
+
+		It("should be empty when nothing was mutated", func() {
+			pod := randomPod(rand.New(rand.NewSource(1)))
+			patch, err := createPodPatch(marshalPod(pod), pod, pod.DeepCopy())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patch).To(BeEmpty())
+		})
+
+		It("should escape resource names in JSON pointers", func() {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "test",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{"cpu": resource.MustParse("1")},
+					Limits:   corev1.ResourceList{"cpu": resource.MustParse("1")},
+				},
+			}}}}
+			mutated := pod.DeepCopy()
+			addResources(mutated, &mutated.Spec.Containers[0], map[string]int64{"example.com/foo~bar": 2})
+
+			patch, err := createPodPatch(marshalPod(pod), pod, mutated)
+			Expect(err).NotTo(HaveOccurred())
+			paths := []string{}
+			for _, operation := range patch {
+				paths = append(paths, operation.Path)
+			}
+			Expect(paths).To(ConsistOf(
+				"/spec/containers/0/resources/requests/example.com~1foo~0bar",
+				"/spec/containers/0/resources/limits/example.com~1foo~0bar",
+			))
+		})
+
+		It("should patch the raw object with unknown fields and without resources", func() {
+			raw := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"test","namespace":"default"},` +
+				`"spec":{"futureField":{"enabled":true},"containers":[{"name":"app","image":"busybox","futureField":1}]}}`)
+			pod := &corev1.Pod{}
+			Expect(json.Unmarshal(raw, pod)).To(Succeed())
+			mutated := pod.DeepCopy()
+			addResources(mutated, &mutated.Spec.Containers[0], map[string]int64{"intel.com/sriov": 1})
+
+			patch, err := createPodPatch(raw, pod, mutated)
+			Expect(err).NotTo(HaveOccurred())
+			patchJSON, err := json.Marshal(patch)
+			Expect(err).NotTo(HaveOccurred())
+			decodedPatch, err := evanphx.DecodePatch(patchJSON)
+			Expect(err).NotTo(HaveOccurred())
+			patchedJSON, err := decodedPatch.Apply(raw)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(patch).To(ConsistOf(jsonpatch.JsonPatchOperation{
+				Operation: "add",
+				Path:      "/spec/containers/0/resources",
+				Value: map[string]interface{}{
+					"requests": map[string]interface{}{"intel.com/sriov": "1"},
+					"limits":   map[string]interface{}{"intel.com/sriov": "1"},
+				},
+			}))
+			Expect(string(patchedJSON)).To(And(ContainSubstring(`"futureField":{"enabled":true}`), ContainSubstring(`"futureField":1`)))
+		})
+
+		It("should not fail for pods without containers", func() {
+			pod := &corev1.Pod{}
+			state := &MutationState{ControlSwitches: switches, ResourceRequests: map[string]int64{"intel.com/sriov": 1}}
+			mutated, err := NewDefaultMutatorRegistry().Mutate(context.Background(), pod, state)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mutated.Spec.Containers).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// hasPodLevelResources returns true when the pod defines pod-level requests or limits
+func hasPodLevelResources(pod *corev1.Pod) bool {
+	return pod.Spec.Resources != nil && (len(pod.Spec.Resources.Requests) > 0 || len(pod.Spec.Resources.Limits) > 0)
+}
+
+// isSupportedPodLevelResource returns true for resources accepted by the API server in the pod-level resources,
+// extended resources can be requested only by containers
+func isSupportedPodLevelResource(resourceName corev1.ResourceName) bool {
+	return resourceName == corev1.ResourceCPU || resourceName == corev1.ResourceMemory ||
+		strings.HasPrefix(string(resourceName), corev1.ResourceHugePagesPrefix)
+}
+
+// splitPodLevelResources splits the resource requests to those supported in the pod-level resources and the others
+func splitPodLevelResources(resourceRequests map[string]int64) (map[string]int64, map[string]int64) {
+	podLevel := make(map[string]int64)
+	containerLevel := make(map[string]int64)
+	for resourceName, count := range resourceRequests {
+		if isSupportedPodLevelResource(corev1.ResourceName(resourceName)) {
+			podLevel[resourceName] = count
+		} else {
+			containerLevel[resourceName] = count
+		}
+	}
+	return podLevel, containerLevel
+}
+
+// addPodLevelResources adds the resources to the pod-level resources. Only memory and hugepages of the networks
+// are supported at pod level, their quantities are always added to the existing ones like in addResources.
+func addPodLevelResources(pod *corev1.Pod, resourceRequests map[string]int64) {
+	if len(resourceRequests) == 0 {
+		return
+	}
+	if pod.Spec.Resources == nil {
+		pod.Spec.Resources = &corev1.ResourceRequirements{}
+	}
+	for resourceName, quantity := range *getResourceList(resourceRequests) {
+		addResourceQuantity(pod.Spec.Resources, resourceName, quantity)
+	}
+}
+
+// validatePodLevelResources checks the pod-level resources with the rules of the API server validation:
+// only supported resources are used, requests don't exceed limits, the aggregated requests of the containers
+// fit into the pod-level requests and no container limit exceeds the pod-level limit
+func validatePodLevelResources(pod *corev1.Pod) error {
+	if !hasPodLevelResources(pod) {
+		return nil
+	}
+	var violations []string
+	podResources := pod.Spec.Resources
+
+	for _, list := range []corev1.ResourceList{podResources.Requests, podResources.Limits} {
+		for resourceName := range list {
+			if !isSupportedPodLevelResource(resourceName) {
+				violations = append(violations, fmt.Sprintf("resource %s is not supported in pod-level resources", resourceName))
+			}
+		}
+	}
+
+	/* requests which are not set are defaulted to the limits by the API server */
+	podRequests := podResources.Requests.DeepCopy()
+	if podRequests == nil {
+		podRequests = corev1.ResourceList{}
+	}
+	for resourceName, limit := range podResources.Limits {
+		request, exists := podRequests[resourceName]
+		if !exists {
+			podRequests[resourceName] = limit
+		} else if request.Cmp(limit) > 0 {
+			violations = append(violations, fmt.Sprintf("pod-level request of %s %s exceeds its limit %s",
+				resourceName, request.String(), limit.String()))
+		}
+	}
+
+	for resourceName, aggregated := range aggregateContainerRequests(pod) {
+		if request, exists := podRequests[resourceName]; exists && aggregated.Cmp(request) > 0 {
+			violations = append(violations, fmt.Sprintf("aggregated container requests of %s %s exceed pod-level request %s",
+				resourceName, aggregated.String(), request.String()))
+		}
+	}
+
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			for resourceName, containerLimit := range container.Resources.Limits {
+				if limit, exists := podResources.Limits[resourceName]; exists && containerLimit.Cmp(limit) > 0 {
+					violations = append(violations, fmt.Sprintf("limit of %s %s of container %s exceeds pod-level limit %s",
+						resourceName, containerLimit.String(), container.Name, limit.String()))
+				}
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return fmt.Errorf("pod-level resources are not valid: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// aggregateContainerRequests returns the effective requests of the containers the same way as the scheduler,
+// sidecars run alongside the regular containers while every other init container runs alone with the sidecars
+// started before it
+func aggregateContainerRequests(pod *corev1.Pod) corev1.ResourceList {
+	aggregated := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResourceList(aggregated, container.Resources.Requests)
+	}
+
+	sidecars := corev1.ResourceList{}
+	initMax := corev1.ResourceList{}
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			addResourceList(aggregated, container.Resources.Requests)
+			addResourceList(sidecars, container.Resources.Requests)
+			continue
+		}
+		running := sidecars.DeepCopy()
+		addResourceList(running, container.Resources.Requests)
+		maxResourceList(initMax, running)
+	}
+	maxResourceList(aggregated, initMax)
+	return aggregated
+}
+
+func addResourceList(list, added corev1.ResourceList) {
+	for resourceName, quantity := range added {
+		total := list[resourceName]
+		total.Add(quantity)
+		list[resourceName] = total
+	}
+}
+
+func maxResourceList(list, other corev1.ResourceList) {
+	for resourceName, quantity := range other {
+		if current, exists := list[resourceName]; !exists || quantity.Cmp(current) > 0 {
+			list[resourceName] = quantity.DeepCopy()
+		}
+	}
+}
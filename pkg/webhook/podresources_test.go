@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("Pod-level resources", func() {
+	resourceList := func(values map[string]string) corev1.ResourceList {
+		list := corev1.ResourceList{}
+		for name, value := range values {
+			list[corev1.ResourceName(name)] = resource.MustParse(value)
+		}
+		return list
+	}
+
+	/* quantities parsed from strings and created from numbers differ in their cached format */
+	expectResourceList := func(actual corev1.ResourceList, expected map[string]string) {
+		Expect(apiequality.Semantic.DeepEqual(actual, resourceList(expected))).To(BeTrue(), "%v should equal %v", actual, expected)
+	}
+
+	newPod := func(podRequests, podLimits map[string]string, containers ...corev1.Container) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec:       corev1.PodSpec{Containers: containers},
+		}
+		if podRequests != nil || podLimits != nil {
+			pod.Spec.Resources = &corev1.ResourceRequirements{}
+			if podRequests != nil {
+				pod.Spec.Resources.Requests = resourceList(podRequests)
+			}
+			if podLimits != nil {
+				pod.Spec.Resources.Limits = resourceList(podLimits)
+			}
+		}
+		return pod
+	}
+
+	newContainer := func(name string, requests, limits map[string]string) corev1.Container {
+		container := corev1.Container{Name: name}
+		if requests != nil {
+			container.Resources.Requests = resourceList(requests)
+		}
+		if limits != nil {
+			container.Resources.Limits = resourceList(limits)
+		}
+		return container
+	}
+
+	DescribeTable("Validating pod-level resources",
+		func(pod *corev1.Pod, expectedError string) {
+			err := validatePodLevelResources(pod)
+			if expectedError == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring(expectedError)))
+		},
+		Entry("pod without pod-level resources",
+			newPod(nil, nil, newContainer("app", map[string]string{"intel.com/sriov": "1"}, nil)), ""),
+		Entry("containers within pod-level budget",
+			newPod(map[string]string{"memory": "1Gi"}, map[string]string{"memory": "2Gi"},
+				newContainer("app", map[string]string{"memory": "512Mi", "intel.com/sriov": "1"}, map[string]string{"memory": "1Gi"})), ""),
+		Entry("extended resource at pod level",
+			newPod(map[string]string{"intel.com/sriov": "1"}, nil, newContainer("app", nil, nil)),
+			"resource intel.com/sriov is not supported in pod-level resources"),
+		Entry("request exceeding limit",
+			newPod(map[string]string{"cpu": "2"}, map[string]string{"cpu": "1"}, newContainer("app", nil, nil)),
+			"pod-level request of cpu 2 exceeds its limit 1"),
+		Entry("aggregated container requests exceeding pod-level request",
+			newPod(map[string]string{"hugepages-1Gi": "1Gi"}, nil,
+				newContainer("app", map[string]string{"hugepages-1Gi": "1Gi"}, nil),
+				newContainer("sidecar", map[string]string{"hugepages-1Gi": "1Gi"}, nil)),
+			"aggregated container requests of hugepages-1Gi 2Gi exceed pod-level request 1Gi"),
+		Entry("aggregated container requests exceeding pod-level limit used as request",
+			newPod(nil, map[string]string{"memory": "1Gi"},
+				newContainer("app", map[string]string{"memory": "2Gi"}, nil)),
+			"aggregated container requests of memory 2Gi exceed pod-level request 1Gi"),
+		Entry("container limit exceeding pod-level limit",
+			newPod(nil, map[string]string{"memory": "1Gi"},
+				newContainer("app", map[string]string{"memory": "512Mi"}, map[string]string{"memory": "2Gi"})),
+			"limit of memory 2Gi of container app exceeds pod-level limit 1Gi"),
+	)
+
+	It("should aggregate init containers and sidecars like the scheduler", func() {
+		always := corev1.ContainerRestartPolicyAlways
+		sidecar := newContainer("sidecar", map[string]string{"memory": "100Mi"}, nil)
+		sidecar.RestartPolicy = &always
+		pod := newPod(nil, nil, newContainer("app", map[string]string{"memory": "200Mi"}, nil))
+		pod.Spec.InitContainers = []corev1.Container{
+			newContainer("init-before", map[string]string{"memory": "500Mi"}, nil),
+			sidecar,
+			newContainer("init-after", map[string]string{"memory": "350Mi"}, nil),
+		}
+		aggregated := aggregateContainerRequests(pod)
+		Expect(aggregated.Memory().Cmp(resource.MustParse("500Mi"))).To(Equal(0))
+
+		pod.Spec.InitContainers[0].Resources.Requests = nil
+		aggregated = aggregateContainerRequests(pod)
+		Expect(aggregated.Memory().Cmp(resource.MustParse("450Mi"))).To(Equal(0))
+	})
+
+	DescribeTable("Adding pod-level resources",
+		func(podRequests, podLimits map[string]string, expectedRequests, expectedLimits map[string]string) {
+			pod := newPod(podRequests, podLimits)
+			addPodLevelResources(pod, map[string]int64{"hugepages-1Gi": 1})
+			if expectedRequests == nil {
+				Expect(pod.Spec.Resources.Requests).To(BeEmpty())
+			} else {
+				expectResourceList(pod.Spec.Resources.Requests, expectedRequests)
+			}
+			if expectedLimits == nil {
+				Expect(pod.Spec.Resources.Limits).To(BeEmpty())
+			} else {
+				expectResourceList(pod.Spec.Resources.Limits, expectedLimits)
+			}
+		},
+		Entry("undefined resource", map[string]string{"memory": "1Gi"}, nil,
+			map[string]string{"memory": "1Gi", "hugepages-1Gi": "1"}, map[string]string{"hugepages-1Gi": "1"}),
+		Entry("requested and limited resource", map[string]string{"hugepages-1Gi": "2"}, map[string]string{"hugepages-1Gi": "2"},
+			map[string]string{"hugepages-1Gi": "3"}, map[string]string{"hugepages-1Gi": "3"}),
+		Entry("requested resource without limit", map[string]string{"hugepages-1Gi": "2"}, nil,
+			map[string]string{"hugepages-1Gi": "3"}, nil),
+		Entry("limited resource without request", nil, map[string]string{"hugepages-1Gi": "2"},
+			nil, map[string]string{"hugepages-1Gi": "3"}),
+	)
+
+	DescribeTable("Injecting resources depending on pod-level resources mode",
+		func(mode string, withPodLevelResources bool, expectedPodRequests, expectedContainerRequests map[string]string) {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			switches.SetPodLevelResourcesModeUnitTests(mode)
+			switches.InitControlSwitches()
+
+			pod := newPod(nil, nil, newContainer("app", nil, nil))
+			if withPodLevelResources {
+				pod = newPod(map[string]string{"memory": "1Gi"}, nil, newContainer("app", nil, nil))
+			}
+			state := &MutationState{
+				ControlSwitches:  switches,
+				ResourceRequests: map[string]int64{"intel.com/sriov": 1, "hugepages-2Mi": 4},
+			}
+			Expect((&resourcesMutator{}).Mutate(context.Background(), pod, state)).To(Succeed())
+
+			if expectedPodRequests == nil {
+				Expect(pod.Spec.Resources).To(BeNil())
+			} else {
+				expectResourceList(pod.Spec.Resources.Requests, expectedPodRequests)
+			}
+			expectResourceList(pod.Spec.Containers[0].Resources.Requests, expectedContainerRequests)
+		},
+		Entry("container mode", controlswitches.PodLevelResourcesModeContainer, true,
+			map[string]string{"memory": "1Gi"}, map[string]string{"intel.com/sriov": "1", "hugepages-2Mi": "4"}),
+		Entry("pod mode", controlswitches.PodLevelResourcesModePod, true,
+			map[string]string{"memory": "1Gi", "hugepages-2Mi": "4"}, map[string]string{"intel.com/sriov": "1"}),
+		Entry("both mode", controlswitches.PodLevelResourcesModeBoth, true,
+			map[string]string{"memory": "1Gi", "hugepages-2Mi": "4"}, map[string]string{"intel.com/sriov": "1", "hugepages-2Mi": "4"}),
+		Entry("pod mode without pod-level resources", controlswitches.PodLevelResourcesModePod, false,
+			nil, map[string]string{"intel.com/sriov": "1", "hugepages-2Mi": "4"}),
+	)
+
+	It("should deny pods whose mutated spec violates pod-level resources", func() {
+		switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		switches.InitControlSwitches()
+		wh := newTestWebhook(switches, map[string]map[string]string{
+			"default/hugepages-net": {"k8s.v1.cni.cncf.io/resourceName": "hugepages-1Gi"},
+		})
+		pod := newPod(map[string]string{"memory": "1Gi"}, map[string]string{"hugepages-1Gi": "1"}, newContainer("app", nil, nil))
+		pod.Annotations = map[string]string{"k8s.v1.cni.cncf.io/networks": "hugepages-net,hugepages-net"}
+
+		w := httptest.NewRecorder()
+		wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+		ar := admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+		Expect(ar.Response.Allowed).To(BeFalse())
+		Expect(ar.Response.Result.Message).To(ContainSubstring("limit of hugepages-1Gi 2 of container app exceeds pod-level limit 1"))
+	})
+})
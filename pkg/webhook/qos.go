@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+// guaranteedQoSKey net-attach-def annotation with the action for pods attached to it which won't have Guaranteed QoS
+const guaranteedQoSKey = "k8s.v1.cni.cncf.io/guaranteedQoS"
+
+var guaranteedQoSActionStrictness = map[string]int{
+	controlswitches.GuaranteedQoSActionIgnore: 0,
+	controlswitches.GuaranteedQoSActionWarn:   1,
+	controlswitches.GuaranteedQoSActionDeny:   2,
+}
+
+// stricterGuaranteedQoSAction returns the stricter of the actions, an empty action is ignore
+func stricterGuaranteedQoSAction(action, other string) string {
+	if action == "" || guaranteedQoSActionStrictness[other] > guaranteedQoSActionStrictness[action] {
+		return other
+	}
+	return action
+}
+
+// parseNetAttachDefGuaranteedQoSAction returns the action requested by the net-attach-def annotation, empty when
+// the net-attach-def doesn't have it
+func parseNetAttachDefGuaranteedQoSAction(netAttachDef *cniv1.NetworkAttachmentDefinition) (string, error) {
+	action, exists := netAttachDef.GetAnnotations()[guaranteedQoSKey]
+	if !exists {
+		return "", nil
+	}
+	action = strings.TrimSpace(action)
+	if !controlswitches.IsValidGuaranteedQoSAction(action) {
+		return "", fmt.Errorf("invalid %s action %q, expected %s, %s or %s", guaranteedQoSKey, action,
+			controlswitches.GuaranteedQoSActionIgnore, controlswitches.GuaranteedQoSActionWarn, controlswitches.GuaranteedQoSActionDeny)
+	}
+	return action, nil
+}
+
+// getGuaranteedQoSViolations returns the reasons why the pod won't have Guaranteed QoS, the pod-level resources
+// decide when the pod has them, otherwise every container needs equal CPU and memory requests and limits.
+// Requests which are not set are defaulted to the limits by the API server.
+func getGuaranteedQoSViolations(pod *corev1.Pod) []string {
+	if hasPodLevelResources(pod) {
+		return getResourcesQoSViolations("pod-level resources", *pod.Spec.Resources)
+	}
+
+	var violations []string
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			violations = append(violations, getResourcesQoSViolations("container "+container.Name, container.Resources)...)
+		}
+	}
+	return violations
+}
+
+func getResourcesQoSViolations(owner string, resources corev1.ResourceRequirements) []string {
+	var violations []string
+	for _, resourceName := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		limit, hasLimit := resources.Limits[resourceName]
+		request, hasRequest := resources.Requests[resourceName]
+		switch {
+		case !hasLimit:
+			violations = append(violations, fmt.Sprintf("%s has no %s limit", owner, resourceName))
+		case hasRequest && request.Cmp(limit) != 0:
+			violations = append(violations, fmt.Sprintf("%s has %s request %s not equal to limit %s", owner, resourceName,
+				request.String(), limit.String()))
+		}
+	}
+	return violations
+}
+
+// checkGuaranteedQoS applies the stricter of the global action and the actions of the net-attach-defs to the
+// mutated pod. The global action applies only to pods which request network resources. Pods are denied with
+// an error, warnings are added to the requirements.
+func (wh *Webhook) checkGuaranteedQoS(pod *corev1.Pod, requirements *networkRequirements) error {
+	action := requirements.guaranteedQoSAction
+	if len(requirements.resourceRequests) > 0 || len(requirements.resourceClaims) > 0 {
+		action = stricterGuaranteedQoSAction(action, wh.config.ControlSwitches().GetGuaranteedQoSAction())
+	}
+	if action == "" || action == controlswitches.GuaranteedQoSActionIgnore {
+		return nil
+	}
+
+	violations := getGuaranteedQoSViolations(pod)
+	if len(violations) == 0 {
+		return nil
+	}
+	reason := fmt.Sprintf("pod won't have Guaranteed QoS required by its networks: %s", strings.Join(violations, "; "))
+	if action == controlswitches.GuaranteedQoSActionDeny {
+		return errors.New(reason)
+	}
+	glog.Warningf("pod %s/%s %s", pod.Namespace, pod.Name, reason)
+	requirements.warnings = append(requirements.warnings, reason)
+	return nil
+}
@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("Guaranteed QoS", func() {
+	resources := func(requests, limits map[string]string) corev1.ResourceRequirements {
+		requirements := corev1.ResourceRequirements{}
+		for name, value := range requests {
+			if requirements.Requests == nil {
+				requirements.Requests = corev1.ResourceList{}
+			}
+			requirements.Requests[corev1.ResourceName(name)] = resource.MustParse(value)
+		}
+		for name, value := range limits {
+			if requirements.Limits == nil {
+				requirements.Limits = corev1.ResourceList{}
+			}
+			requirements.Limits[corev1.ResourceName(name)] = resource.MustParse(value)
+		}
+		return requirements
+	}
+	guaranteed := map[string]string{"cpu": "2", "memory": "1Gi"}
+
+	newPod := func(containers ...corev1.Container) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+			},
+			Spec: corev1.PodSpec{Containers: containers},
+		}
+	}
+
+	DescribeTable("Finding containers without Guaranteed QoS",
+		func(pod *corev1.Pod, expected []string) {
+			Expect(getGuaranteedQoSViolations(pod)).To(Equal(expected))
+		},
+		Entry("equal requests and limits",
+			newPod(corev1.Container{Name: "app", Resources: resources(guaranteed, guaranteed)}), nil),
+		Entry("requests defaulted to limits",
+			newPod(corev1.Container{Name: "app", Resources: resources(nil, guaranteed)}), nil),
+		Entry("missing limits",
+			newPod(corev1.Container{Name: "app", Resources: resources(guaranteed, map[string]string{"cpu": "2"})}),
+			[]string{"container app has no memory limit"}),
+		Entry("request lower than limit",
+			newPod(corev1.Container{Name: "app", Resources: resources(map[string]string{"cpu": "1", "memory": "1Gi"}, guaranteed)}),
+			[]string{"container app has cpu request 1 not equal to limit 2"}),
+		Entry("second container without resources",
+			newPod(corev1.Container{Name: "app", Resources: resources(guaranteed, guaranteed)}, corev1.Container{Name: "sidecar"}),
+			[]string{"container sidecar has no cpu limit", "container sidecar has no memory limit"}),
+		Entry("pod-level resources",
+			func() *corev1.Pod {
+				pod := newPod(corev1.Container{Name: "app"})
+				podResources := resources(map[string]string{"cpu": "1"}, guaranteed)
+				pod.Spec.Resources = &podResources
+				return pod
+			}(),
+			[]string{"pod-level resources has cpu request 1 not equal to limit 2"}),
+	)
+
+	It("should also check init containers", func() {
+		pod := newPod(corev1.Container{Name: "app", Resources: resources(guaranteed, guaranteed)})
+		pod.Spec.InitContainers = []corev1.Container{{Name: "init"}}
+		Expect(getGuaranteedQoSViolations(pod)).To(ConsistOf("container init has no cpu limit", "container init has no memory limit"))
+	})
+
+	DescribeTable("Choosing the stricter action",
+		func(action, other, expected string) {
+			Expect(stricterGuaranteedQoSAction(action, other)).To(Equal(expected))
+		},
+		Entry("no action", "", controlswitches.GuaranteedQoSActionIgnore, controlswitches.GuaranteedQoSActionIgnore),
+		Entry("warn over ignore", controlswitches.GuaranteedQoSActionIgnore, controlswitches.GuaranteedQoSActionWarn, controlswitches.GuaranteedQoSActionWarn),
+		Entry("deny over warn", controlswitches.GuaranteedQoSActionDeny, controlswitches.GuaranteedQoSActionWarn, controlswitches.GuaranteedQoSActionDeny),
+	)
+
+	Describe("Admission", func() {
+		var (
+			switches    *controlswitches.ControlSwitches
+			annotations map[string]map[string]string
+		)
+
+		mutate := func(pod *corev1.Pod) *admissionv1.AdmissionResponse {
+			w := httptest.NewRecorder()
+			newTestWebhook(switches, annotations).ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			return ar.Response
+		}
+
+		BeforeEach(func() {
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			annotations = map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			}
+		})
+
+		It("should not check QoS by default", func() {
+			switches.InitControlSwitches()
+			response := mutate(newPod(corev1.Container{Name: "app"}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(BeEmpty())
+		})
+
+		It("should warn when the global action is warn", func() {
+			switches.SetGuaranteedQoSActionUnitTests(controlswitches.GuaranteedQoSActionWarn)
+			switches.InitControlSwitches()
+			response := mutate(newPod(corev1.Container{Name: "app", Resources: resources(map[string]string{"cpu": "1"}, guaranteed)}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(ConsistOf(ContainSubstring("container app has cpu request 1 not equal to limit 2")))
+			Expect(response.Patch).NotTo(BeEmpty())
+		})
+
+		It("should deny when the net-attach-def requires Guaranteed QoS", func() {
+			annotations["default/sriov-net"]["k8s.v1.cni.cncf.io/guaranteedQoS"] = "deny"
+			switches.SetGuaranteedQoSActionUnitTests(controlswitches.GuaranteedQoSActionWarn)
+			switches.InitControlSwitches()
+			response := mutate(newPod(corev1.Container{Name: "app"}))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("container app has no cpu limit; container app has no memory limit"))
+		})
+
+		It("should admit pod with Guaranteed QoS", func() {
+			annotations["default/sriov-net"]["k8s.v1.cni.cncf.io/guaranteedQoS"] = "deny"
+			switches.InitControlSwitches()
+			response := mutate(newPod(corev1.Container{Name: "app", Resources: resources(nil, guaranteed)}))
+			Expect(response.Allowed).To(BeTrue())
+		})
+
+		It("should not apply the global action to networks without resources", func() {
+			annotations["default/sriov-net"] = map[string]string{}
+			switches.SetGuaranteedQoSActionUnitTests(controlswitches.GuaranteedQoSActionDeny)
+			switches.InitControlSwitches()
+			Expect(mutate(newPod(corev1.Container{Name: "app"})).Allowed).To(BeTrue())
+		})
+
+		It("should deny pod attached to net-attach-def with invalid action", func() {
+			annotations["default/sriov-net"]["k8s.v1.cni.cncf.io/guaranteedQoS"] = "enforce"
+			switches.InitControlSwitches()
+			response := mutate(newPod(corev1.Container{Name: "app"}))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring(`invalid k8s.v1.cni.cncf.io/guaranteedQoS action "enforce"`))
+		})
+	})
+})
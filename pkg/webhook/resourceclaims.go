@@ -0,0 +1,298 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	resourceClaimTemplateNameKey = "k8s.v1.cni.cncf.io/resourceClaimTemplateName"
+	deviceClassNameKey           = "k8s.v1.cni.cncf.io/deviceClassName"
+
+	resourceClaimNamePrefix = "nri-"
+	// deviceRequestName name of the device request in the resource claim templates managed by NRI
+	deviceRequestName = "network"
+	managedByLabelKey = "app.kubernetes.io/managed-by"
+	managedByLabel    = "network-resources-injector"
+
+	// resourceClaimTemplateCollectInterval how often unused templates managed by NRI are looked for
+	resourceClaimTemplateCollectInterval = 10 * time.Minute
+	// resourceClaimTemplateGracePeriod templates younger than this are kept, the pod they were created for
+	// may still be in admission or waiting in a scheduling gate
+	resourceClaimTemplateGracePeriod = 10 * time.Minute
+)
+
+// NetworkResourceClaim resource claim of DRA devices needed by a network of the pod
+type NetworkResourceClaim struct {
+	// Name of the claim in the pod spec, unique per net-attach-def
+	Name string
+	// ResourceClaimTemplateName template in the namespace of the pod from which the claim is created
+	ResourceClaimTemplateName string
+	// DeviceClassName is set when the template is managed by NRI, it requests Count devices of the class
+	DeviceClassName string
+	// Count of the devices requested by the template managed by NRI
+	Count int64
+}
+
+// hasResourceClaimAnnotations returns true when the net-attach-def references DRA devices
+func hasResourceClaimAnnotations(annotationsMap map[string]string) bool {
+	_, hasTemplate := annotationsMap[resourceClaimTemplateNameKey]
+	_, hasDeviceClass := annotationsMap[deviceClassNameKey]
+	return hasTemplate || hasDeviceClass
+}
+
+// parseNetAttachDefResourceClaim returns the resource claim needed by a pod attached to the net-attach-def, nil
+// when the net-attach-def doesn't reference DRA devices. Claims referencing a device class are created from
+// a template managed by NRI in the namespace of the pod.
+func parseNetAttachDefResourceClaim(netAttachDef *cniv1.NetworkAttachmentDefinition, podNamespace string) (*NetworkResourceClaim, error) {
+	annotationsMap := netAttachDef.GetAnnotations()
+	templateName, hasTemplate := annotationsMap[resourceClaimTemplateNameKey]
+	deviceClassName, hasDeviceClass := annotationsMap[deviceClassNameKey]
+	if !hasTemplate && !hasDeviceClass {
+		return nil, nil
+	}
+	if hasTemplate && hasDeviceClass {
+		return nil, fmt.Errorf("annotations %s and %s are mutually exclusive", resourceClaimTemplateNameKey, deviceClassNameKey)
+	}
+
+	claim := &NetworkResourceClaim{Name: getResourceClaimName(netAttachDef, podNamespace)}
+	if hasTemplate {
+		templateName = strings.TrimSpace(templateName)
+		if errs := validation.IsDNS1123Subdomain(templateName); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid resource claim template name %q: %s", templateName, strings.Join(errs, ", "))
+		}
+		claim.ResourceClaimTemplateName = templateName
+		return claim, nil
+	}
+
+	deviceClassName = strings.TrimSpace(deviceClassName)
+	if errs := validation.IsDNS1123Subdomain(deviceClassName); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid device class name %q: %s", deviceClassName, strings.Join(errs, ", "))
+	}
+	claim.Count = 1
+	if value, exists := annotationsMap[resourceCountKey]; exists {
+		var err error
+		if claim.Count, err = parseResourceCount(value); err != nil {
+			return nil, err
+		}
+	}
+	claim.DeviceClassName = deviceClassName
+	/* template specs are immutable, every device class and count gets its own template in the pod namespace */
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", claim.DeviceClassName, claim.Count)))
+	claim.ResourceClaimTemplateName = claim.Name + "-" + hex.EncodeToString(hash[:])[:10]
+	return claim, nil
+}
+
+// getResourceClaimName returns name of the pod resource claim of the net-attach-def, it is prefixed by the
+// namespace of the net-attach-def when it differs from the pod namespace and shortened by a hash when it
+// exceeds the length of a DNS label
+func getResourceClaimName(netAttachDef *cniv1.NetworkAttachmentDefinition, podNamespace string) string {
+	name := resourceClaimNamePrefix + netAttachDef.Name
+	if netAttachDef.Namespace != podNamespace {
+		name = resourceClaimNamePrefix + netAttachDef.Namespace + "-" + netAttachDef.Name
+	}
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	hash := sha256.Sum256([]byte(netAttachDef.Namespace + "/" + netAttachDef.Name))
+	suffix := "-" + hex.EncodeToString(hash[:])[:10]
+	return strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)], "-.") + suffix
+}
+
+// addResourceClaims adds the claims to the pod spec and requests them in the target container, claims which
+// are already part of the pod spec are not added again
+func addResourceClaims(pod *corev1.Pod, container *corev1.Container, claims []NetworkResourceClaim) {
+	if container == nil {
+		glog.Warningf("pod has no containers, skipping injection of resource claims %v", claims)
+		return
+	}
+
+	for _, claim := range claims {
+		if !podHasResourceClaim(pod, claim.Name) {
+			templateName := claim.ResourceClaimTemplateName
+			pod.Spec.ResourceClaims = append(pod.Spec.ResourceClaims, corev1.PodResourceClaim{
+				Name:                      claim.Name,
+				ResourceClaimTemplateName: &templateName,
+			})
+		}
+		if !containerHasResourceClaim(container, claim.Name) {
+			container.Resources.Claims = append(container.Resources.Claims, corev1.ResourceClaim{Name: claim.Name})
+		}
+	}
+}
+
+func podHasResourceClaim(pod *corev1.Pod, name string) bool {
+	for _, claim := range pod.Spec.ResourceClaims {
+		if claim.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containerHasResourceClaim(container *corev1.Container, name string) bool {
+	for _, claim := range container.Resources.Claims {
+		if claim.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newResourceClaimTemplate returns template managed by NRI which requests devices of the claim device class
+func newResourceClaimTemplate(namespace string, claim NetworkResourceClaim) *resourcev1.ResourceClaimTemplate {
+	return &resourcev1.ResourceClaimTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claim.ResourceClaimTemplateName,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabelKey: managedByLabel},
+		},
+		Spec: resourcev1.ResourceClaimTemplateSpec{
+			Spec: resourcev1.ResourceClaimSpec{
+				Devices: resourcev1.DeviceClaim{
+					Requests: []resourcev1.DeviceRequest{{
+						Name: deviceRequestName,
+						Exactly: &resourcev1.ExactDeviceRequest{
+							DeviceClassName: claim.DeviceClassName,
+							AllocationMode:  resourcev1.DeviceAllocationModeExactCount,
+							Count:           claim.Count,
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// ensureResourceClaimTemplates creates templates managed by NRI for the claims which reference a device class,
+// templates which exist but are not managed by NRI are never used
+func (wh *Webhook) ensureResourceClaimTemplates(ctx context.Context, namespace string, claims []NetworkResourceClaim) error {
+	for _, claim := range claims {
+		if claim.DeviceClassName == "" {
+			continue
+		}
+
+		templates := wh.client.ResourceV1().ResourceClaimTemplates(namespace)
+		existing, err := templates.Get(ctx, claim.ResourceClaimTemplateName, metav1.GetOptions{})
+		if err == nil {
+			if existing.Labels[managedByLabelKey] != managedByLabel {
+				return fmt.Errorf("resource claim template %s/%s exists and is not managed by %s", namespace,
+					claim.ResourceClaimTemplateName, managedByLabel)
+			}
+			continue
+		}
+		if apierrors.IsNotFound(err) {
+			glog.Infof("creating resource claim template %s/%s for device class %s", namespace, claim.ResourceClaimTemplateName,
+				claim.DeviceClassName)
+			_, err = templates.Create(ctx, newResourceClaimTemplate(namespace, claim), metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				/* created by a concurrent admission request of another pod */
+				continue
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not ensure resource claim template %s/%s", namespace, claim.ResourceClaimTemplateName)
+		}
+	}
+	return nil
+}
+
+// ResourceClaimTemplateCollector deletes templates managed by NRI which are not referenced by any pod in their
+// namespace, e.g. templates created for pods which were denied after the webhook admitted them or templates of
+// net-attach-defs which changed their device class or count
+type ResourceClaimTemplateCollector struct {
+	client      kubernetes.Interface
+	gracePeriod time.Duration
+	stopper     chan struct{}
+}
+
+// NewResourceClaimTemplateCollector returns collector which has to be started before use
+func NewResourceClaimTemplateCollector(client kubernetes.Interface) *ResourceClaimTemplateCollector {
+	return &ResourceClaimTemplateCollector{
+		client:      client,
+		gracePeriod: resourceClaimTemplateGracePeriod,
+		stopper:     make(chan struct{}),
+	}
+}
+
+// Start looks for unused templates periodically
+func (c *ResourceClaimTemplateCollector) Start() {
+	glog.Infof("starting resource claim template collector")
+	go wait.Until(func() {
+		if err := c.collect(context.Background()); err != nil {
+			glog.Errorf("error collecting unused resource claim templates: %v", err)
+		}
+	}, resourceClaimTemplateCollectInterval, c.stopper)
+}
+
+// Stop stops looking for unused templates
+func (c *ResourceClaimTemplateCollector) Stop() {
+	close(c.stopper)
+}
+
+func (c *ResourceClaimTemplateCollector) collect(ctx context.Context) error {
+	templates, err := c.client.ResourceV1().ResourceClaimTemplates(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabelKey + "=" + managedByLabel,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not list resource claim templates")
+	}
+
+	/* pods of a namespace are listed only when it has a template old enough to be deleted */
+	referenced := map[string]sets.Set[string]{}
+	for _, template := range templates.Items {
+		if time.Since(template.CreationTimestamp.Time) < c.gracePeriod {
+			continue
+		}
+		if _, listed := referenced[template.Namespace]; !listed {
+			if referenced[template.Namespace], err = c.getReferencedTemplates(ctx, template.Namespace); err != nil {
+				return err
+			}
+		}
+		if referenced[template.Namespace].Has(template.Name) {
+			continue
+		}
+
+		glog.Infof("deleting unused resource claim template %s/%s", template.Namespace, template.Name)
+		err = c.client.ResourceV1().ResourceClaimTemplates(template.Namespace).Delete(ctx, template.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &template.UID},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			glog.Errorf("error deleting resource claim template %s/%s: %v", template.Namespace, template.Name, err)
+		}
+	}
+	return nil
+}
+
+// getReferencedTemplates returns names of the templates referenced by pods in the namespace
+func (c *ResourceClaimTemplateCollector) getReferencedTemplates(ctx context.Context, namespace string) (sets.Set[string], error) {
+	pods, err := c.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not list pods in namespace %s", namespace)
+	}
+	names := sets.New[string]()
+	for _, pod := range pods.Items {
+		for _, claim := range pod.Spec.ResourceClaims {
+			if claim.ResourceClaimTemplateName != nil {
+				names.Insert(*claim.ResourceClaimTemplateName)
+			}
+		}
+	}
+	return names, nil
+}
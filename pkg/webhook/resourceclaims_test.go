@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	evanphx "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("Resource claims", func() {
+	newNetAttachDef := func(namespace, name string, annotations map[string]string) *cniv1.NetworkAttachmentDefinition {
+		return &cniv1.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations},
+		}
+	}
+
+	DescribeTable("Parsing net-attach-def resource claim",
+		func(annotations map[string]string, expectedClaim *NetworkResourceClaim, shouldFail bool) {
+			claim, err := parseNetAttachDefResourceClaim(newNetAttachDef("default", "dra-net", annotations), "default")
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			if expectedClaim == nil {
+				Expect(claim).To(BeNil())
+				return
+			}
+			Expect(claim.Name).To(Equal(expectedClaim.Name))
+			Expect(claim.DeviceClassName).To(Equal(expectedClaim.DeviceClassName))
+			Expect(claim.Count).To(Equal(expectedClaim.Count))
+			if expectedClaim.ResourceClaimTemplateName != "" {
+				Expect(claim.ResourceClaimTemplateName).To(Equal(expectedClaim.ResourceClaimTemplateName))
+			}
+		},
+		Entry("without DRA annotations", map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"}, nil, false),
+		Entry("with resource claim template", map[string]string{"k8s.v1.cni.cncf.io/resourceClaimTemplateName": "sriov-vf"},
+			&NetworkResourceClaim{Name: "nri-dra-net", ResourceClaimTemplateName: "sriov-vf"}, false),
+		Entry("with device class", map[string]string{"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com"},
+			&NetworkResourceClaim{Name: "nri-dra-net", DeviceClassName: "vf.example.com", Count: 1}, false),
+		Entry("with device class and resource count", map[string]string{
+			"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com",
+			"k8s.v1.cni.cncf.io/resourceCount":   "2",
+		}, &NetworkResourceClaim{Name: "nri-dra-net", DeviceClassName: "vf.example.com", Count: 2}, false),
+		Entry("with both template and device class", map[string]string{
+			"k8s.v1.cni.cncf.io/resourceClaimTemplateName": "sriov-vf",
+			"k8s.v1.cni.cncf.io/deviceClassName":           "vf.example.com",
+		}, nil, true),
+		Entry("with invalid template name", map[string]string{"k8s.v1.cni.cncf.io/resourceClaimTemplateName": "Sriov_VF"}, nil, true),
+		Entry("with empty device class", map[string]string{"k8s.v1.cni.cncf.io/deviceClassName": " "}, nil, true),
+	)
+
+	It("should use different templates for different device classes and counts", func() {
+		first, err := parseNetAttachDefResourceClaim(newNetAttachDef("default", "dra-net",
+			map[string]string{"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com"}), "default")
+		Expect(err).NotTo(HaveOccurred())
+		second, err := parseNetAttachDefResourceClaim(newNetAttachDef("default", "dra-net",
+			map[string]string{"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com", "k8s.v1.cni.cncf.io/resourceCount": "2"}), "default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.ResourceClaimTemplateName).To(HavePrefix("nri-dra-net-"))
+		Expect(first.ResourceClaimTemplateName).NotTo(Equal(second.ResourceClaimTemplateName))
+	})
+
+	It("should prefix claim name with namespace of net-attach-def from another namespace", func() {
+		Expect(getResourceClaimName(newNetAttachDef("infra", "dra-net", nil), "default")).To(Equal("nri-infra-dra-net"))
+	})
+
+	It("should shorten long claim names to a DNS label", func() {
+		name := getResourceClaimName(newNetAttachDef("infra", strings.Repeat("a", 70), nil), "default")
+		Expect(len(name)).To(BeNumerically("<=", 63))
+		Expect(name).NotTo(Equal(getResourceClaimName(newNetAttachDef("infra", strings.Repeat("a", 71), nil), "default")))
+	})
+
+	It("should ignore extended resources of net-attach-defs with DRA annotations in replace mode", func() {
+		switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		switches.SetDRAInjectionModeUnitTests(controlswitches.DRAInjectionModeReplace)
+		switches.InitControlSwitches()
+		resources, _, err := parseNetAttachDef(newNetAttachDef("default", "dra-net", map[string]string{
+			"k8s.v1.cni.cncf.io/resourceName":    "intel.com/sriov",
+			"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com",
+		}), switches)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources).To(BeEmpty())
+	})
+
+	It("should add every claim once to the pod and the first container", func() {
+		templateName := "sriov-vf"
+		pod := &corev1.Pod{Spec: corev1.PodSpec{
+			Containers:     []corev1.Container{{Name: "app"}, {Name: "sidecar"}},
+			ResourceClaims: []corev1.PodResourceClaim{{Name: "nri-existing-net", ResourceClaimTemplateName: &templateName}},
+		}}
+		addResourceClaims(pod, &pod.Spec.Containers[0], []NetworkResourceClaim{
+			{Name: "nri-existing-net", ResourceClaimTemplateName: "other"},
+			{Name: "nri-dra-net", ResourceClaimTemplateName: "sriov-vf"},
+		})
+		Expect(pod.Spec.ResourceClaims).To(HaveLen(2))
+		Expect(*pod.Spec.ResourceClaims[0].ResourceClaimTemplateName).To(Equal("sriov-vf"))
+		Expect(pod.Spec.ResourceClaims[1].Name).To(Equal("nri-dra-net"))
+		Expect(pod.Spec.Containers[0].Resources.Claims).To(Equal([]corev1.ResourceClaim{{Name: "nri-existing-net"}, {Name: "nri-dra-net"}}))
+		Expect(pod.Spec.Containers[1].Resources.Claims).To(BeEmpty())
+	})
+
+	Describe("Handling requests", func() {
+		var wh *Webhook
+
+		BeforeEach(func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			wh = newTestWebhook(switches, map[string]map[string]string{
+				"default/dra-net":      {"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com"},
+				"default/template-net": {"k8s.v1.cni.cncf.io/resourceClaimTemplateName": "sriov-vf"},
+			})
+		})
+
+		mutate := func(pod *corev1.Pod, dryRun bool) (*admissionv1.AdmissionResponse, *corev1.Pod) {
+			req := newAdmissionRequest("/mutate", pod)
+			if dryRun {
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.NewDecoder(req.Body).Decode(&ar)).To(Succeed())
+				ar.Request.DryRun = &dryRun
+				req = httptest.NewRequest("POST", "https://fakewebhook/mutate", strings.NewReader(mustMarshal(ar)))
+				req.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, req)
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			if !ar.Response.Allowed {
+				return ar.Response, nil
+			}
+			patch, err := evanphx.DecodePatch(ar.Response.Patch)
+			Expect(err).NotTo(HaveOccurred())
+			patchedRawPod, err := patch.Apply([]byte(mustMarshal(pod)))
+			Expect(err).NotTo(HaveOccurred())
+			patchedPod := &corev1.Pod{}
+			Expect(json.Unmarshal(patchedRawPod, patchedPod)).To(Succeed())
+			return ar.Response, patchedPod
+		}
+
+		newPod := func(networks string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": networks},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+			}
+		}
+
+		It("should request one claim per network and create managed template", func() {
+			response, patchedPod := mutate(newPod("dra-net,dra-net,template-net"), false)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(patchedPod.Spec.ResourceClaims).To(HaveLen(2))
+			Expect(patchedPod.Spec.Containers[0].Resources.Claims).To(ConsistOf(
+				corev1.ResourceClaim{Name: "nri-dra-net"}, corev1.ResourceClaim{Name: "nri-template-net"}))
+
+			templateName := *patchedPod.Spec.ResourceClaims[0].ResourceClaimTemplateName
+			template, err := wh.client.ResourceV1().ResourceClaimTemplates("default").Get(context.Background(), templateName, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(template.Labels).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "network-resources-injector"))
+			Expect(template.Spec.Spec.Devices.Requests[0].Exactly.DeviceClassName).To(Equal("vf.example.com"))
+			Expect(*patchedPod.Spec.ResourceClaims[1].ResourceClaimTemplateName).To(Equal("sriov-vf"))
+		})
+
+		It("should not create templates for dry run requests", func() {
+			response, patchedPod := mutate(newPod("dra-net"), true)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(patchedPod.Spec.ResourceClaims).To(HaveLen(1))
+			templates, err := wh.client.ResourceV1().ResourceClaimTemplates("default").List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templates.Items).To(BeEmpty())
+		})
+
+		It("should deny pods when template with the same name is not managed by NRI", func() {
+			claim, err := parseNetAttachDefResourceClaim(newNetAttachDef("default", "dra-net",
+				map[string]string{"k8s.v1.cni.cncf.io/deviceClassName": "vf.example.com"}), "default")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = wh.client.ResourceV1().ResourceClaimTemplates("default").Create(context.Background(), &resourcev1.ResourceClaimTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: claim.ResourceClaimTemplateName, Namespace: "default"},
+			}, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			response, _ := mutate(newPod("dra-net"), false)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("is not managed by"))
+		})
+
+		It("should keep existing managed templates", func() {
+			Expect(wh.ensureResourceClaimTemplates(context.Background(), "default", []NetworkResourceClaim{
+				{Name: "nri-dra-net", ResourceClaimTemplateName: "nri-dra-net-1", DeviceClassName: "vf.example.com", Count: 1},
+			})).To(Succeed())
+			Expect(wh.ensureResourceClaimTemplates(context.Background(), "default", []NetworkResourceClaim{
+				{Name: "nri-dra-net", ResourceClaimTemplateName: "nri-dra-net-1", DeviceClassName: "vf.example.com", Count: 1},
+			})).To(Succeed())
+			templates, err := wh.client.ResourceV1().ResourceClaimTemplates("default").List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(templates.Items).To(HaveLen(1))
+		})
+	})
+
+	Describe("Collecting unused templates", func() {
+		newTemplate := func(namespace, name string, managed bool, age time.Duration) *resourcev1.ResourceClaimTemplate {
+			template := &resourcev1.ResourceClaimTemplate{ObjectMeta: metav1.ObjectMeta{
+				Namespace:         namespace,
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			}}
+			if managed {
+				template.Labels = map[string]string{managedByLabelKey: managedByLabel}
+			}
+			return template
+		}
+
+		It("should delete only old unreferenced templates managed by NRI", func() {
+			templateName := "used"
+			client := fake.NewSimpleClientset(
+				newTemplate("default", "used", true, time.Hour),
+				newTemplate("default", "unused", true, time.Hour),
+				newTemplate("default", "new", true, time.Minute),
+				newTemplate("default", "unmanaged", false, time.Hour),
+				newTemplate("other", "used", true, time.Hour),
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"},
+					Spec: corev1.PodSpec{ResourceClaims: []corev1.PodResourceClaim{
+						{Name: "nri-dra-net", ResourceClaimTemplateName: &templateName},
+					}},
+				},
+			)
+			Expect(NewResourceClaimTemplateCollector(client).collect(context.Background())).To(Succeed())
+
+			templates, err := client.ResourceV1().ResourceClaimTemplates("").List(context.Background(), metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, template := range templates.Items {
+				names = append(names, template.Namespace+"/"+template.Name)
+			}
+			Expect(names).To(ConsistOf("default/used", "default/new", "default/unmanaged"))
+		})
+	})
+})
+
+func mustMarshal(object interface{}) string {
+	raw, err := json.Marshal(object)
+	Expect(err).NotTo(HaveOccurred())
+	return string(raw)
+}
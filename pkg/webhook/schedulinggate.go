@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	multus "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
+)
+
+const (
+	// networksSchedulingGate keeps pods which reference missing net-attach-defs from being scheduled
+	networksSchedulingGate = "k8s.v1.cni.cncf.io/network-resources-injector"
+	// gatedPodLabelKey marks pods with the scheduling gate, so only those are watched by the controller
+	gatedPodLabelKey = "k8s.v1.cni.cncf.io/networks-gated"
+	// stuckGatedPodReason reason of the event recorded for gated pods which can't get their network resources
+	stuckGatedPodReason = "NetworkResourcesNotInjected"
+)
+
+func hasSchedulingGate(pod *corev1.Pod) bool {
+	for _, gate := range pod.Spec.SchedulingGates {
+		if gate.Name == networksSchedulingGate {
+			return true
+		}
+	}
+	return false
+}
+
+func addSchedulingGate(pod *corev1.Pod) {
+	if !hasSchedulingGate(pod) {
+		pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, corev1.PodSchedulingGate{Name: networksSchedulingGate})
+	}
+	if pod.ObjectMeta.Labels == nil {
+		pod.ObjectMeta.Labels = map[string]string{}
+	}
+	pod.ObjectMeta.Labels[gatedPodLabelKey] = "true"
+}
+
+// SchedulingGateController creates gated pods again once all net-attach-defs referenced by the pod exist. The API
+// server refuses changes of extended resources and resource claims of gated pods, so the network resources can't
+// be injected into the existing pod. Pods with a controller are deleted instead and the pod created again by the
+// controller is mutated by the webhook. Pods without a controller stay gated and get a warning event.
+type SchedulingGateController struct {
+	webhook     *Webhook
+	nadCache    netcache.NetAttachDefCacheService
+	factory     informers.SharedInformerFactory
+	pods        corelisters.PodLister
+	queue       workqueue.TypedRateLimitingInterface[string]
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	stopper     chan struct{}
+}
+
+// NewSchedulingGateController returns controller which has to be started before use, it watches only the pods
+// labelled by the webhook when the gate was added
+func NewSchedulingGateController(wh *Webhook, nadCache netcache.NetAttachDefCacheService) *SchedulingGateController {
+	factory := informers.NewSharedInformerFactoryWithOptions(wh.client, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = gatedPodLabelKey
+		}))
+	broadcaster := record.NewBroadcaster()
+	return &SchedulingGateController{
+		webhook:     wh,
+		nadCache:    nadCache,
+		factory:     factory,
+		pods:        factory.Core().V1().Pods().Lister(),
+		queue:       workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "network-resources-injector"}),
+		stopper:     make(chan struct{}),
+	}
+}
+
+// Start starts the pod informer and the worker, gated pods are processed when they are added and every time
+// a net-attach-def which they reference is added or updated
+func (c *SchedulingGateController) Start() {
+	glog.Infof("starting scheduling gate controller")
+	c.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.webhook.client.CoreV1().Events("")})
+	_, err := c.factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(_, newObj interface{}) {
+			c.enqueue(newObj)
+		},
+	})
+	if err != nil {
+		glog.Fatalf("error adding scheduling gate controller event handler: %v", err)
+	}
+	c.nadCache.AddHandler(func(namespace, networkName string) {
+		/* a net-attach-def may be referenced from any namespace, gated pods are few */
+		pods, err := c.pods.List(labels.Everything())
+		if err != nil {
+			glog.Errorf("error listing gated pods: %v", err)
+			return
+		}
+		for _, pod := range pods {
+			if c.referencesNetAttachDef(pod, namespace, networkName) {
+				c.enqueue(pod)
+			}
+		}
+	})
+
+	c.factory.Start(c.stopper)
+	for informerType, synced := range c.factory.WaitForCacheSync(c.stopper) {
+		if !synced {
+			glog.Errorf("cache of %v informer is not synced", informerType)
+		}
+	}
+	go wait.Until(c.runWorker, 0, c.stopper)
+}
+
+// Stop teardown the informer and the worker
+func (c *SchedulingGateController) Stop() {
+	close(c.stopper)
+	c.queue.ShutDown()
+	c.factory.Shutdown()
+	c.broadcaster.Shutdown()
+}
+
+func (c *SchedulingGateController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("error getting key of gated pod: %v", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *SchedulingGateController) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *SchedulingGateController) processNextItem() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.webhook.apiTimeout)
+	defer cancel()
+	if err := c.reconcile(ctx, key); err != nil {
+		glog.Infof("pod %s stays gated: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *SchedulingGateController) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+	pod, err := c.pods.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return c.syncPod(ctx, pod)
+}
+
+// getPodNetworks returns the networks of the pod including the user-defined injections
+func (c *SchedulingGateController) getPodNetworks(pod *corev1.Pod) ([]*multus.NetworkSelectionElement, error) {
+	userDefinedPatch, err := c.webhook.config.UserDefinedInjections().CreateUserDefinedPatch(*pod)
+	if err != nil {
+		glog.Warningf("failed to create user-defined injection patch for pod %s/%s, err: %v", pod.Namespace, pod.Name, err)
+	}
+	networks, _, err := getPodNetworks(*pod, userDefinedPatch)
+	return networks, err
+}
+
+// referencesNetAttachDef returns true when the gated pod references the net-attach-def
+func (c *SchedulingGateController) referencesNetAttachDef(pod *corev1.Pod, namespace, networkName string) bool {
+	networks, err := c.getPodNetworks(pod)
+	if err != nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Namespace == namespace && network.Name == networkName {
+			return true
+		}
+	}
+	return false
+}
+
+// syncPod deletes the gated pod once all its net-attach-defs exist, so its controller creates it again and the new
+// pod gets its network resources from the webhook. The gated pod itself is never updated, because the network
+// resources can't be added to it.
+func (c *SchedulingGateController) syncPod(ctx context.Context, pod *corev1.Pod) error {
+	if !hasSchedulingGate(pod) {
+		return nil
+	}
+
+	networks, err := c.getPodNetworks(pod)
+	if err != nil {
+		return err
+	}
+	if _, err := c.webhook.getNetworkRequirements(ctx, pod.Namespace, networks); err != nil {
+		return err
+	}
+
+	if metav1.GetControllerOf(pod) == nil {
+		glog.Errorf("net-attach-defs of gated pod %s/%s exist, the pod has no controller and has to be created again",
+			pod.Namespace, pod.Name)
+		c.recorder.Event(pod, corev1.EventTypeWarning, stuckGatedPodReason,
+			"net-attach-defs of the pod exist now, the pod has to be created again to get its network resources")
+		return nil
+	}
+
+	glog.Infof("net-attach-defs of gated pod %s/%s exist, deleting it to be created again by its controller",
+		pod.Namespace, pod.Name)
+	uid := pod.UID
+	err = c.webhook.client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "could not delete gated pod %s/%s", pod.Namespace, pod.Name)
+	}
+	return nil
+}
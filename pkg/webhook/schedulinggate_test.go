@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+var _ = Describe("Scheduling gate", func() {
+	var (
+		switches    *controlswitches.ControlSwitches
+		annotations map[string]map[string]string
+		ctx         context.Context
+	)
+
+	newGatedPod := func() *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				UID:         "pod-uid",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "late-net"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		addSchedulingGate(pod)
+		return pod
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		switches.SetMissingNetAttachDefActionUnitTests(controlswitches.MissingNetAttachDefActionGate)
+		switches.InitControlSwitches()
+		annotations = map[string]map[string]string{}
+	})
+
+	Describe("Admission", func() {
+		mutate := func(pod *corev1.Pod) *admissionv1.AdmissionResponse {
+			w := httptest.NewRecorder()
+			newTestWebhook(switches, annotations).ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			return ar.Response
+		}
+
+		newPod := func() *corev1.Pod {
+			isController := true
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test",
+					Namespace:       "default",
+					Annotations:     map[string]string{"k8s.v1.cni.cncf.io/networks": "late-net"},
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs", UID: "rs-uid", Controller: &isController}},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+		}
+
+		It("should admit pod referencing missing net-attach-def with the scheduling gate only", func() {
+			response := mutate(newPod())
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(ConsistOf(ContainSubstring("late-net")))
+
+			var patch []map[string]interface{}
+			Expect(json.Unmarshal(response.Patch, &patch)).To(Succeed())
+			paths := []string{}
+			for _, operation := range patch {
+				paths = append(paths, operation["path"].(string))
+			}
+			Expect(paths).To(ConsistOf("/metadata/labels", "/spec/schedulingGates"))
+		})
+
+		It("should deny pod referencing missing net-attach-def by default", func() {
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			response := mutate(newPod())
+			Expect(response.Allowed).To(BeFalse())
+		})
+
+		It("should gate pod without a controller with an additional warning", func() {
+			pod := newPod()
+			pod.OwnerReferences = nil
+			response := mutate(pod)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(ContainElement(ContainSubstring("has to be created again")))
+		})
+
+		It("should skip validation of gated pod", func() {
+			pod := newPod()
+			addSchedulingGate(pod)
+			w := httptest.NewRecorder()
+			newTestWebhook(switches, annotations).ServeHTTP(w, newAdmissionRequest("/validate", pod))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			Expect(ar.Response.Allowed).To(BeTrue())
+		})
+
+		It("should not gate pod with invalid net-attach-def", func() {
+			annotations["default/late-net"] = map[string]string{"k8s.v1.cni.cncf.io/resourceName": ""}
+			response := mutate(newPod())
+			Expect(response.Allowed).To(BeFalse())
+		})
+	})
+
+	Describe("Controller", func() {
+		var (
+			client     *fake.Clientset
+			controller *SchedulingGateController
+			pod        *corev1.Pod
+		)
+
+		setup := func() {
+			wh := newTestWebhook(switches, annotations)
+			client = wh.client.(*fake.Clientset)
+			controller = NewSchedulingGateController(wh, wh.nadCache)
+			_, err := client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		getPod := func() (*corev1.Pod, error) {
+			return client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		}
+
+		withController := func() {
+			isController := true
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs", UID: "rs-uid", Controller: &isController}}
+		}
+
+		expectNoUpdates := func() {
+			for _, action := range client.Actions() {
+				Expect(action.GetVerb()).NotTo(Equal("update"))
+			}
+		}
+
+		BeforeEach(func() {
+			pod = newGatedPod()
+		})
+
+		It("should keep the gate while the net-attach-def is missing", func() {
+			withController()
+			setup()
+			err := controller.syncPod(ctx, pod)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			gated, err := getPod()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hasSchedulingGate(gated)).To(BeTrue())
+		})
+
+		It("should delete pod with a controller once the net-attach-def exists", func() {
+			annotations["default/late-net"] = map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"}
+			withController()
+			setup()
+			Expect(controller.syncPod(ctx, pod)).To(Succeed())
+
+			_, err := getPod()
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			expectNoUpdates()
+		})
+
+		It("should keep standalone pod gated and record an event once the net-attach-def exists", func() {
+			annotations["default/late-net"] = map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"}
+			setup()
+			recorder := record.NewFakeRecorder(1)
+			controller.recorder = recorder
+			Expect(controller.syncPod(ctx, pod)).To(Succeed())
+
+			gated, err := getPod()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hasSchedulingGate(gated)).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring(stuckGatedPodReason)))
+			expectNoUpdates()
+		})
+
+		It("should match only net-attach-defs referenced by the pod", func() {
+			pod.Annotations["k8s.v1.cni.cncf.io/networks"] = "late-net,other/remote-net"
+			setup()
+			Expect(controller.referencesNetAttachDef(pod, "default", "late-net")).To(BeTrue())
+			Expect(controller.referencesNetAttachDef(pod, "other", "remote-net")).To(BeTrue())
+			Expect(controller.referencesNetAttachDef(pod, "other", "late-net")).To(BeFalse())
+			Expect(controller.referencesNetAttachDef(pod, "default", "unrelated-net")).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,236 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+// ValidateHandler checks that pods request the network resources needed by their networks, and that they
+// don't request network resources used by networks they don't attach
+func (wh *Webhook) ValidateHandler(w http.ResponseWriter, req *http.Request) {
+	controlSwitches := wh.config.ControlSwitches()
+	glog.Infof("Received validation request. Features status: %s", controlSwitches.GetAllFeaturesState())
+
+	/* read AdmissionReview from the HTTP request */
+	ar, httpStatus, err := readAdmissionReview(req, w)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus)
+		return
+	}
+
+	pod, err := wh.deserializePod(req.Context(), ar)
+	if err != nil {
+		handleValidationError(w, ar, err)
+		return
+	}
+	glog.Infof("AdmissionReview validation request received for pod %s/%s", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+
+	if hasSchedulingGate(&pod) {
+		/* net-attach-defs of gated pods don't exist yet, the pods are created again once they do */
+		glog.Infof("pod %s/%s has scheduling gate %s. Skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, networksSchedulingGate)
+		wh.writeValidationResponse(w, ar, nil)
+		return
+	}
+
+	/* the pod was already mutated, user defined injections are part of its annotations at this point */
+	networks, exists, err := getPodNetworks(pod, nil)
+	if err != nil {
+		handleValidationError(w, ar, err)
+		return
+	}
+	if !exists {
+		glog.Infof("pod %s/%s spec doesn't have network annotations. Skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+		wh.writeValidationResponse(w, ar, nil)
+		return
+	}
+
+	requirements, err := wh.getNetworkRequirements(req.Context(), pod.ObjectMeta.Namespace, networks)
+	if err != nil {
+		handleValidationError(w, ar, err)
+		return
+	}
+
+	violations := validatePodResources(&pod, requirements.resourceRequests, wh.getNetworkResourceNames())
+	if len(violations) > 0 {
+		glog.Warningf("pod %s/%s resources don't match its networks: %s", pod.ObjectMeta.Namespace,
+			pod.ObjectMeta.Name, strings.Join(violations, "; "))
+	}
+	wh.writeValidationResponse(w, ar, violations)
+}
+
+// ValidateNetAttachDefHandler refuses net-attach-defs with annotations which would make the mutation of
+// pods attached to them fail
+func (wh *Webhook) ValidateNetAttachDefHandler(w http.ResponseWriter, req *http.Request) {
+	/* read AdmissionReview from the HTTP request */
+	ar, httpStatus, err := readAdmissionReview(req, w)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus)
+		return
+	}
+
+	netAttachDef, err := deserializeNetworkAttachmentDefinition(ar)
+	if err != nil {
+		handleValidationError(w, ar, err)
+		return
+	}
+	glog.Infof("AdmissionReview validation request received for net-attach-def %s/%s", netAttachDef.Namespace, netAttachDef.Name)
+
+	_, _, err = parseNetAttachDef(&netAttachDef, wh.config.ControlSwitches())
+	if err == nil {
+		_, err = parseNetAttachDefResourceClaim(&netAttachDef, netAttachDef.Namespace)
+	}
+	if err == nil {
+		_, err = parseNetAttachDefHugepages(netAttachDef.GetAnnotations())
+	}
+	if err == nil {
+		_, err = parseNetAttachDefCapabilities(netAttachDef.GetAnnotations())
+	}
+	if err != nil {
+		reason := errors.Wrapf(err, "invalid net-attach-def %s/%s", netAttachDef.Namespace, netAttachDef.Name)
+		glog.Error(reason)
+		handleValidationError(w, ar, reason)
+		return
+	}
+
+	if err := prepareAdmissionReviewResponse(true, "", ar); err != nil {
+		glog.Errorf("error preparing AdmissionReview response, error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResponse(w, ar)
+}
+
+// writeValidationResponse allows the pod when there are no violations, otherwise it either denies the pod
+// or allows it with warnings depending on the configured validation action
+func (wh *Webhook) writeValidationResponse(w http.ResponseWriter, ar *admissionv1.AdmissionReview, violations []string) {
+	allowed := len(violations) == 0
+	message := ""
+	if !allowed {
+		message = "pod resources don't match its networks: " + strings.Join(violations, "; ")
+		if wh.config.ControlSwitches().GetPodValidationAction() == controlswitches.PodValidationActionWarn {
+			allowed = true
+		}
+	}
+
+	if err := prepareAdmissionReviewResponse(allowed, message, ar); err != nil {
+		glog.Errorf("error preparing AdmissionReview response, error: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if allowed {
+		ar.Response.Warnings = violations
+	}
+	writeResponse(w, ar)
+}
+
+// getNetworkResourceNames returns names of all resources referenced by the cached net-attach-defs and by
+// the resource name mappings
+func (wh *Webhook) getNetworkResourceNames() map[corev1.ResourceName]bool {
+	resourceNames := make(map[corev1.ResourceName]bool)
+	for _, netAttachDef := range wh.nadCache.List() {
+		netAttachDefResources, _, err := parseNetAttachDef(netAttachDef, wh.config.ControlSwitches())
+		if err != nil {
+			continue
+		}
+		for resourceName := range netAttachDefResources {
+			resourceNames[corev1.ResourceName(resourceName)] = true
+		}
+	}
+	for _, resourceName := range wh.config.ResourceNameMappings().GetMappedResourceNames() {
+		resourceNames[corev1.ResourceName(resourceName)] = true
+	}
+	return resourceNames
+}
+
+// validatePodResources compares resources requested by the pod containers with the resources needed by the
+// pod networks, it returns description of every mismatch
+func validatePodResources(pod *corev1.Pod, resourceRequests map[string]int64, networkResourceNames map[corev1.ResourceName]bool) []string {
+	var violations []string
+
+	requested := getRequestedResources(pod)
+	for resourceName, needed := range resourceRequests {
+		count := requested[corev1.ResourceName(resourceName)]
+		if count >= needed {
+			continue
+		}
+		if isByteResource(corev1.ResourceName(resourceName)) {
+			violations = append(violations, fmt.Sprintf("resource %s is requested with %s but the pod networks need %s",
+				resourceName, resource.NewQuantity(count, resource.BinarySI), resource.NewQuantity(needed, resource.BinarySI)))
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("resource %s is requested %d times but the pod networks need %d",
+			resourceName, count, needed))
+	}
+
+	for resourceName := range requested {
+		if _, needed := resourceRequests[string(resourceName)]; !needed && networkResourceNames[resourceName] {
+			violations = append(violations, fmt.Sprintf("network resource %s is requested but none of the pod networks uses it",
+				resourceName))
+		}
+	}
+
+	slices.Sort(violations)
+	return violations
+}
+
+// getRequestedResources returns the effective number of resources requested by the pod, like the scheduler
+// computes it: the sum of the regular and sidecar containers, or the largest init container together with the
+// sidecars started before it when that is more. Pod-level resources are used instead when they are defined.
+func getRequestedResources(pod *corev1.Pod) map[corev1.ResourceName]int64 {
+	requested := make(map[corev1.ResourceName]int64)
+	for _, container := range pod.Spec.Containers {
+		for resourceName, value := range getResourceRequests(container.Resources) {
+			requested[resourceName] += value
+		}
+	}
+
+	sidecars := make(map[corev1.ResourceName]int64)
+	initRequested := make(map[corev1.ResourceName]int64)
+	for _, container := range pod.Spec.InitContainers {
+		isSidecar := container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+		for resourceName, value := range getResourceRequests(container.Resources) {
+			if isSidecar {
+				sidecars[resourceName] += value
+				value = 0
+			}
+			initRequested[resourceName] = max(initRequested[resourceName], value+sidecars[resourceName])
+		}
+	}
+
+	for resourceName, value := range sidecars {
+		requested[resourceName] += value
+	}
+	for resourceName, value := range initRequested {
+		requested[resourceName] = max(requested[resourceName], value)
+	}
+	if pod.Spec.Resources != nil {
+		/* memory and hugepages may be injected only at pod level depending on the pod-level resources mode */
+		for resourceName, value := range getResourceRequests(*pod.Spec.Resources) {
+			requested[resourceName] = value
+		}
+	}
+	return requested
+}
+
+// getResourceRequests returns resources requested by a container or the pod, a limit is used only when the
+// resource isn't requested, like the API server defaults the request to the limit
+func getResourceRequests(resources corev1.ResourceRequirements) map[corev1.ResourceName]int64 {
+	requests := make(map[corev1.ResourceName]int64, len(resources.Requests)+len(resources.Limits))
+	for resourceName, quantity := range resources.Limits {
+		requests[resourceName] = quantity.Value()
+	}
+	for resourceName, quantity := range resources.Requests {
+		requests[resourceName] = quantity.Value()
+	}
+	return requests
+}
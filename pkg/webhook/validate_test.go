@@ -0,0 +1,317 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+)
+
+func createPodWithResources(networks string, requests, limits corev1.ResourceList) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:      "test",
+			Image:     "busybox",
+			Resources: corev1.ResourceRequirements{Requests: requests, Limits: limits},
+		}}},
+	}
+	if networks != "" {
+		pod.ObjectMeta.Annotations = map[string]string{"k8s.v1.cni.cncf.io/networks": networks}
+	}
+	return pod
+}
+
+var sidecarRestartPolicy = corev1.ContainerRestartPolicyAlways
+
+func limits(resourceName, value string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceName(resourceName): resource.MustParse(value)}}
+}
+
+var _ = Describe("Validation", func() {
+	networkResourceNames := map[corev1.ResourceName]bool{"intel.com/sriov": true, "intel.com/sriov-b": true}
+
+	DescribeTable("Comparing pod resources with its networks",
+		func(pod *corev1.Pod, resourceRequests map[string]int64, expectedViolations int) {
+			Expect(validatePodResources(pod, resourceRequests, networkResourceNames)).To(HaveLen(expectedViolations))
+		},
+		Entry("resources match the networks",
+			createPodWithResources("", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("2")}),
+			map[string]int64{"intel.com/sriov": 2}, 0),
+		Entry("requests are used when limits are not set",
+			createPodWithResources("", corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")}, nil),
+			map[string]int64{"intel.com/sriov": 1}, 0),
+		Entry("requests take precedence over limits",
+			createPodWithResources("", corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")},
+				corev1.ResourceList{"intel.com/sriov": resource.MustParse("2")}),
+			map[string]int64{"intel.com/sriov": 2}, 1),
+		Entry("resource is requested fewer times than needed",
+			createPodWithResources("", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")}),
+			map[string]int64{"intel.com/sriov": 2}, 1),
+		Entry("resource is not requested at all",
+			createPodWithResources("", nil, nil),
+			map[string]int64{"intel.com/sriov": 1}, 1),
+		Entry("network resource is requested without a network using it",
+			createPodWithResources("", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("1"), "intel.com/sriov-b": resource.MustParse("1")}),
+			map[string]int64{"intel.com/sriov": 1}, 1),
+		Entry("other resources are ignored",
+			createPodWithResources("", nil, corev1.ResourceList{"cpu": resource.MustParse("1"), "example.com/gpu": resource.MustParse("1")}),
+			map[string]int64{}, 0),
+	)
+
+	It("should describe missing memory and hugepages in bytes", func() {
+		pod := createPodWithResources("", nil, corev1.ResourceList{"hugepages-1Gi": resource.MustParse("1Gi")})
+		Expect(validatePodResources(pod, map[string]int64{"hugepages-1Gi": 2 << 30}, networkResourceNames)).To(ConsistOf(
+			"resource hugepages-1Gi is requested with 1Gi but the pod networks need 2Gi"))
+	})
+
+	It("should use memory requests instead of higher limits", func() {
+		pod := createPodWithResources("", corev1.ResourceList{"memory": resource.MustParse("256Mi")},
+			corev1.ResourceList{"memory": resource.MustParse("1Gi")})
+		Expect(validatePodResources(pod, map[string]int64{"memory": 512 << 20}, networkResourceNames)).To(ConsistOf(
+			"resource memory is requested with 256Mi but the pod networks need 512Mi"))
+	})
+
+	It("should count memory and hugepages of the pod-level resources", func() {
+		pod := createPodWithResources("", nil, nil)
+		pod.Spec.Resources = &corev1.ResourceRequirements{Limits: corev1.ResourceList{"hugepages-1Gi": resource.MustParse("2Gi")}}
+		Expect(validatePodResources(pod, map[string]int64{"hugepages-1Gi": 2 << 30}, networkResourceNames)).To(BeEmpty())
+	})
+
+	DescribeTable("Computing the effective resources requested by the pod",
+		func(initContainers []corev1.Container, expected int64) {
+			pod := createPodWithResources("", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")})
+			pod.Spec.InitContainers = initContainers
+			Expect(getRequestedResources(pod)).To(HaveKeyWithValue(corev1.ResourceName("intel.com/sriov"), expected))
+		},
+		Entry("init container requesting the same resource", []corev1.Container{
+			{Name: "init", Resources: limits("intel.com/sriov", "1")},
+		}, int64(1)),
+		Entry("init container requesting more", []corev1.Container{
+			{Name: "init", Resources: limits("intel.com/sriov", "3")},
+		}, int64(3)),
+		Entry("sidecar container", []corev1.Container{
+			{Name: "proxy", RestartPolicy: &sidecarRestartPolicy, Resources: limits("intel.com/sriov", "1")},
+		}, int64(2)),
+		Entry("init container after sidecar container", []corev1.Container{
+			{Name: "proxy", RestartPolicy: &sidecarRestartPolicy, Resources: limits("intel.com/sriov", "1")},
+			{Name: "init", Resources: limits("intel.com/sriov", "2")},
+		}, int64(3)),
+	)
+
+	Describe("Handling validation requests", func() {
+		var (
+			switches *controlswitches.ControlSwitches
+			wh       *Webhook
+		)
+
+		BeforeEach(func() {
+			switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			wh = newTestWebhook(switches, map[string]map[string]string{
+				"default/sriov-net":   {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+				"default/sriov-net-b": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov-b"},
+			})
+		})
+
+		validate := func(pod *corev1.Pod) *admissionv1.AdmissionResponse {
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, newAdmissionRequest("/validate", pod))
+			Expect(w.Result().StatusCode).To(Equal(http.StatusOK))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			return ar.Response
+		}
+
+		It("should allow pods without network annotations", func() {
+			response := validate(createPodWithResources("", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(BeEmpty())
+		})
+
+		It("should allow pods requesting resources of their networks", func() {
+			response := validate(createPodWithResources("sriov-net", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")}))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(BeEmpty())
+		})
+
+		It("should deny pods which don't request resources of their networks", func() {
+			response := validate(createPodWithResources("sriov-net,sriov-net", nil, corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")}))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("intel.com/sriov"))
+		})
+
+		It("should deny pods requesting resources of networks they don't attach", func() {
+			response := validate(createPodWithResources("sriov-net", nil, corev1.ResourceList{
+				"intel.com/sriov":   resource.MustParse("1"),
+				"intel.com/sriov-b": resource.MustParse("1"),
+			}))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("intel.com/sriov-b"))
+		})
+
+		It("should allow pods with warnings in warn mode", func() {
+			switches.SetPodValidationActionUnitTests(controlswitches.PodValidationActionWarn)
+			response := validate(createPodWithResources("sriov-net", nil, nil))
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Warnings).To(HaveLen(1))
+		})
+	})
+
+	DescribeTable("Parsing net-attach-def annotations",
+		func(annotations map[string]string, expectedResourceNames []string, expectedNodeSelector map[string]string, shouldFail bool) {
+			resourceNames, nodeSelector, err := parseNetAttachDefAnnotations(annotations, []string{"k8s.v1.cni.cncf.io/resourceName"},
+				controlswitches.ResourceNameKeysModeAll)
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resourceNames).To(Equal(expectedResourceNames))
+			Expect(nodeSelector).To(Equal(expectedNodeSelector))
+		},
+		Entry("without annotations", nil, nil, map[string]string{}, false),
+		Entry("with resource name", map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			[]string{"intel.com/sriov"}, map[string]string{}, false),
+		Entry("with empty resource name", map[string]string{"k8s.v1.cni.cncf.io/resourceName": " "}, nil, nil, true),
+		Entry("with node selector label", map[string]string{"k8s.v1.cni.cncf.io/nodeSelector": "zone = east"},
+			nil, map[string]string{"zone": "east"}, false),
+		Entry("with node selector label name only", map[string]string{"k8s.v1.cni.cncf.io/nodeSelector": "sriov"},
+			nil, map[string]string{"sriov": ""}, false),
+		Entry("with node selector containing two labels", map[string]string{"k8s.v1.cni.cncf.io/nodeSelector": "zone=east=west"}, nil, nil, true),
+		Entry("with node selector without label name", map[string]string{"k8s.v1.cni.cncf.io/nodeSelector": "=east"}, nil, nil, true),
+	)
+
+	DescribeTable("Handling multiple resource name keys",
+		func(keysMode string, expectedResourceNames []string, shouldFail bool) {
+			annotations := map[string]string{
+				"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov_old",
+				"example.com/resourceName":        "intel.com/sriov_new",
+			}
+			resourceNames, _, err := parseNetAttachDefAnnotations(annotations,
+				[]string{"example.com/resourceName", "k8s.v1.cni.cncf.io/resourceName"}, keysMode)
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resourceNames).To(Equal(expectedResourceNames))
+		},
+		Entry("all", controlswitches.ResourceNameKeysModeAll, []string{"intel.com/sriov_new", "intel.com/sriov_old"}, false),
+		Entry("first-match in order of the keys", controlswitches.ResourceNameKeysModeFirstMatch, []string{"intel.com/sriov_new"}, false),
+		Entry("error-on-multiple", controlswitches.ResourceNameKeysModeErrorOnMultiple, nil, true),
+	)
+
+	It("should not refuse a single resource name key in error-on-multiple mode", func() {
+		resourceNames, _, err := parseNetAttachDefAnnotations(map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			[]string{"example.com/resourceName", "k8s.v1.cni.cncf.io/resourceName"}, controlswitches.ResourceNameKeysModeErrorOnMultiple)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resourceNames).To(Equal([]string{"intel.com/sriov"}))
+	})
+
+	DescribeTable("Counting net-attach-def resources",
+		func(annotations map[string]string, resourceNames []string, expectedResources map[string]int64, shouldFail bool) {
+			resources, err := getNetAttachDefResources(annotations, resourceNames)
+			if shouldFail {
+				Expect(err).To(HaveOccurred())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resources).To(Equal(expectedResources))
+		},
+		Entry("single unit by default", nil, []string{"intel.com/sriov"}, map[string]int64{"intel.com/sriov": 1}, false),
+		Entry("with resource count", map[string]string{"k8s.v1.cni.cncf.io/resourceCount": "2"},
+			[]string{"intel.com/sriov"}, map[string]int64{"intel.com/sriov": 2}, false),
+		Entry("with resource count without resource name", map[string]string{"k8s.v1.cni.cncf.io/resourceCount": "2"}, nil, nil, true),
+		Entry("with zero resource count", map[string]string{"k8s.v1.cni.cncf.io/resourceCount": "0"}, []string{"intel.com/sriov"}, nil, true),
+		Entry("with invalid resource count", map[string]string{"k8s.v1.cni.cncf.io/resourceCount": "two"}, []string{"intel.com/sriov"}, nil, true),
+		Entry("with list of resources", map[string]string{"k8s.v1.cni.cncf.io/resources": "intel.com/pf_a, intel.com/pf_b=2"},
+			nil, map[string]int64{"intel.com/pf_a": 1, "intel.com/pf_b": 2}, false),
+		Entry("with list of resources and resource name", map[string]string{"k8s.v1.cni.cncf.io/resources": "intel.com/sriov=2,intel.com/pf_b"},
+			[]string{"intel.com/sriov"}, map[string]int64{"intel.com/sriov": 3, "intel.com/pf_b": 1}, false),
+		Entry("with list containing entry without name", map[string]string{"k8s.v1.cni.cncf.io/resources": "intel.com/pf_a,=2"}, nil, nil, true),
+		Entry("with list containing invalid count", map[string]string{"k8s.v1.cni.cncf.io/resources": "intel.com/pf_a=-1"}, nil, nil, true),
+	)
+
+	DescribeTable("Parsing net-attach-def hugepages",
+		func(annotations map[string]string, expectedAmounts map[string]int64, expectedError string) {
+			amounts, err := parseNetAttachDefHugepages(annotations)
+			if expectedError != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(amounts).To(Equal(expectedAmounts))
+		},
+		Entry("without annotations", nil, map[string]int64{}, ""),
+		Entry("single page size", map[string]string{"k8s.v1.cni.cncf.io/hugepages": "hugepages-1Gi=2Gi"},
+			map[string]int64{"hugepages-1Gi": 2 << 30}, ""),
+		Entry("several page sizes and memory", map[string]string{
+			"k8s.v1.cni.cncf.io/hugepages": "hugepages-1Gi=1Gi, hugepages-2Mi=512Mi",
+			"k8s.v1.cni.cncf.io/memory":    "256Mi",
+		}, map[string]int64{"hugepages-1Gi": 1 << 30, "hugepages-2Mi": 512 << 20, "memory": 256 << 20}, ""),
+		Entry("entry without amount", map[string]string{"k8s.v1.cni.cncf.io/hugepages": "hugepages-1Gi"}, nil,
+			"not in the hugepages-<size>=<amount> format"),
+		Entry("not a hugepages resource", map[string]string{"k8s.v1.cni.cncf.io/hugepages": "memory=1Gi"}, nil,
+			"not in the hugepages-<size>=<amount> format"),
+		Entry("invalid page size", map[string]string{"k8s.v1.cni.cncf.io/hugepages": "hugepages-huge=1Gi"}, nil,
+			`invalid hugepage size "huge"`),
+		Entry("amount not a multiple of the page size", map[string]string{"k8s.v1.cni.cncf.io/hugepages": "hugepages-1Gi=1536Mi"}, nil,
+			"amount 1536Mi of hugepages-1Gi is not a multiple of the page size"),
+		Entry("zero memory", map[string]string{"k8s.v1.cni.cncf.io/memory": "0"}, nil, `amount "0" is not a positive quantity`),
+	)
+
+	Describe("Handling net-attach-def validation requests", func() {
+		var wh *Webhook
+
+		BeforeEach(func() {
+			switches := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+			switches.InitControlSwitches()
+			wh = newTestWebhook(switches, nil)
+		})
+
+		validate := func(annotations map[string]string) *admissionv1.AdmissionResponse {
+			netAttachDef := &cniv1.NetworkAttachmentDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "sriov-net", Namespace: "default", Annotations: annotations},
+			}
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, newAdmissionRequest("/validate-net-attach-def", netAttachDef))
+			Expect(w.Result().StatusCode).To(Equal(http.StatusOK))
+			ar := admissionv1.AdmissionReview{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+			return ar.Response
+		}
+
+		It("should allow valid net-attach-defs", func() {
+			response := validate(map[string]string{
+				"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov",
+				"k8s.v1.cni.cncf.io/nodeSelector": "zone=east",
+			})
+			Expect(response.Allowed).To(BeTrue())
+		})
+
+		It("should deny net-attach-defs with invalid hugepages", func() {
+			response := validate(map[string]string{"k8s.v1.cni.cncf.io/hugepages": "hugepages-2Mi=1Mi"})
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("not a multiple of the page size"))
+		})
+
+		It("should deny net-attach-defs with invalid annotations", func() {
+			response := validate(map[string]string{"k8s.v1.cni.cncf.io/nodeSelector": "zone=east=west"})
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring("default/sriov-net"))
+		})
+	})
+})
@@ -24,6 +24,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
@@ -31,16 +32,21 @@ import (
 	multus "gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
 
 	admissionv1 "k8s.io/api/admission/v1"
-	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/ownerref"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/resourcemappings"
 	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
@@ -50,31 +56,150 @@ type hugepageResourceData struct {
 	ResourceName  string
 	ContainerName string
 	Path          string
+	EnvName       string
 }
 
 const (
 	networksAnnotationKey       = "k8s.v1.cni.cncf.io/networks"
 	nodeSelectorKey             = "k8s.v1.cni.cncf.io/nodeSelector"
+	resourceCountKey            = "k8s.v1.cni.cncf.io/resourceCount"
+	resourcesKey                = "k8s.v1.cni.cncf.io/resources"
+	hugepagesKey                = "k8s.v1.cni.cncf.io/hugepages"
+	memoryKey                   = "k8s.v1.cni.cncf.io/memory"
 	defaultNetworkAnnotationKey = "v1.multus-cni.io/default-network"
 	metadataAnnotationsPath     = "/metadata/annotations"
 	patchOperationAdd           = "add"
-	podNetInfoVolumeName        = "podnetinfo"
+	// resourceInjectionContainerKey pod annotation with name of the container which requests the network resources
+	resourceInjectionContainerKey = "k8s.v1.cni.cncf.io/resourceInjectionContainer"
 )
 
 var (
-	HugepageRegex         = regexp.MustCompile(`^hugepages-(.+)$`)
-	clientset             kubernetes.Interface
-	nadCache              netcache.NetAttachDefCacheService
-	userDefinedInjections *userdefinedinjections.UserDefinedInjections
-	controlSwitches       *controlswitches.ControlSwitches
+	HugepageRegex = regexp.MustCompile(`^hugepages-(.+)$`)
 )
 
-func SetControlSwitches(activeConfiguration *controlswitches.ControlSwitches) {
-	controlSwitches = activeConfiguration
+// DefaultAPITimeout is the default bound of API server calls made while processing an admission request, it
+// is lower than the default timeout of admission webhooks
+const DefaultAPITimeout = 5 * time.Second
+
+// ConfigProvider provides the active runtime configuration for each admission request
+type ConfigProvider interface {
+	ControlSwitches() *controlswitches.ControlSwitches
+	UserDefinedInjections() *userdefinedinjections.UserDefinedInjections
+	ResourceNameMappings() *resourcemappings.ResourceNameMappings
+}
+
+type configProvider struct {
+	controlSwitches       *controlswitches.ControlSwitches
+	userDefinedInjections *userdefinedinjections.UserDefinedInjections
+	resourceNameMappings  *resourcemappings.ResourceNameMappings
+}
+
+// NewConfigProvider returns ConfigProvider backed by the given structures, all are expected to be
+// updated in place when the runtime configuration changes
+func NewConfigProvider(switches *controlswitches.ControlSwitches,
+	injections *userdefinedinjections.UserDefinedInjections,
+	mappings *resourcemappings.ResourceNameMappings) ConfigProvider {
+	return &configProvider{controlSwitches: switches, userDefinedInjections: injections, resourceNameMappings: mappings}
+}
+
+func (p *configProvider) ControlSwitches() *controlswitches.ControlSwitches {
+	return p.controlSwitches
 }
 
-func SetUserInjectionStructure(injections *userdefinedinjections.UserDefinedInjections) {
-	userDefinedInjections = injections
+func (p *configProvider) UserDefinedInjections() *userdefinedinjections.UserDefinedInjections {
+	return p.userDefinedInjections
+}
+
+func (p *configProvider) ResourceNameMappings() *resourcemappings.ResourceNameMappings {
+	return p.resourceNameMappings
+}
+
+// Options holds dependencies of the Webhook
+type Options struct {
+	// Client is used to communicate with the API server, required
+	Client kubernetes.Interface
+	// NetAttachDefCache is used to look up NetworkAttachmentDefinitions before asking the API server, required
+	NetAttachDefCache netcache.NetAttachDefCacheService
+	// OwnerResolver is used to find namespaces of pods from their owner references, required
+	OwnerResolver ownerref.OwnerResolverService
+	// Config provides control switches and user defined injections, required
+	Config ConfigProvider
+	// Clock defaults to the real clock
+	Clock clock.PassiveClock
+	// Mutators defaults to the registry with all built-in mutators
+	Mutators *MutatorRegistry
+	// APITimeout bounds API server calls made while processing an admission request, defaults to DefaultAPITimeout
+	APITimeout time.Duration
+}
+
+// Webhook serves admission requests for pods with network resources
+type Webhook struct {
+	client        kubernetes.Interface
+	nadCache      netcache.NetAttachDefCacheService
+	ownerResolver ownerref.OwnerResolverService
+	config        ConfigProvider
+	clock         clock.PassiveClock
+	mutators      *MutatorRegistry
+	apiTimeout    time.Duration
+}
+
+// New creates Webhook from explicit dependencies
+func New(opts Options) (*Webhook, error) {
+	if opts.Client == nil {
+		return nil, errors.New("kubernetes client is required")
+	}
+	if opts.NetAttachDefCache == nil {
+		return nil, errors.New("net-attach-def cache is required")
+	}
+	if opts.OwnerResolver == nil {
+		return nil, errors.New("owner resolver is required")
+	}
+	if opts.Config == nil {
+		return nil, errors.New("config provider is required")
+	}
+	wh := &Webhook{
+		client:        opts.Client,
+		nadCache:      opts.NetAttachDefCache,
+		ownerResolver: opts.OwnerResolver,
+		config:        opts.Config,
+		clock:         opts.Clock,
+		mutators:      opts.Mutators,
+		apiTimeout:    opts.APITimeout,
+	}
+	if wh.clock == nil {
+		wh.clock = clock.RealClock{}
+	}
+	if wh.mutators == nil {
+		wh.mutators = NewDefaultMutatorRegistry()
+	}
+	if wh.apiTimeout <= 0 {
+		wh.apiTimeout = DefaultAPITimeout
+	}
+	return wh, nil
+}
+
+// ServeHTTP dispatches admission requests to the handler registered for the request path
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var handler http.HandlerFunc
+	switch req.URL.Path {
+	case "/mutate":
+		handler = wh.MutateHandler
+	case "/validate":
+		handler = wh.ValidateHandler
+	case "/validate-net-attach-def":
+		handler = wh.ValidateNetAttachDefHandler
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "Invalid HTTP verb requested", http.StatusMethodNotAllowed)
+		return
+	}
+	/* API server calls must finish before the API server gives up on the admission request */
+	ctx, cancel := context.WithTimeout(req.Context(), wh.apiTimeout)
+	defer cancel()
+	handler(w, req.WithContext(ctx))
 }
 
 func prepareAdmissionReviewResponse(allowed bool, message string, ar *admissionv1.AdmissionReview) error {
@@ -143,7 +268,14 @@ func deserializeAdmissionReview(body []byte) (*admissionv1.AdmissionReview, erro
 	return ar, err
 }
 
-func deserializePod(ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
+func deserializeNetworkAttachmentDefinition(ar *admissionv1.AdmissionReview) (cniv1.NetworkAttachmentDefinition, error) {
+	/* unmarshal NetworkAttachmentDefinition from AdmissionReview request */
+	netAttachDef := cniv1.NetworkAttachmentDefinition{}
+	err := json.Unmarshal(ar.Request.Object.Raw, &netAttachDef)
+	return netAttachDef, err
+}
+
+func (wh *Webhook) deserializePod(ctx context.Context, ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
 	/* unmarshal Pod from AdmissionReview request */
 	pod := corev1.Pod{}
 	err := json.Unmarshal(ar.Request.Object.Raw, &pod)
@@ -159,7 +291,12 @@ func deserializePod(ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
 
 	ownerRef := pod.ObjectMeta.OwnerReferences
 	if len(ownerRef) > 0 {
-		namespace, err := getNamespaceFromOwnerReference(pod.ObjectMeta.OwnerReferences[0])
+		namespace, err := wh.ownerResolver.GetNamespace(ctx, pod.ObjectMeta.OwnerReferences[0])
+		if apierrors.IsForbidden(errors.Cause(err)) {
+			/* owners of custom kinds can't be listed without extra RBAC, networks of the pod can't be resolved */
+			return pod, errors.Wrapf(err, "could not resolve namespace of pod owner %s %s, grant list permission "+
+				"on its resource to the service account", ownerRef[0].Kind, ownerRef[0].Name)
+		}
 		if err != nil {
 			return pod, err
 		}
@@ -172,80 +309,6 @@ func deserializePod(ar *admissionv1.AdmissionReview) (corev1.Pod, error) {
 	return pod, err
 }
 
-func getNamespaceFromOwnerReference(ownerRef metav1.OwnerReference) (namespace string, err error) {
-	namespace = ""
-	switch ownerRef.Kind {
-	case "ReplicaSet":
-		var replicaSets *v1.ReplicaSetList
-		replicaSets, err = clientset.AppsV1().ReplicaSets("").List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return
-		}
-		for _, replicaSet := range replicaSets.Items {
-			if replicaSet.ObjectMeta.Name == ownerRef.Name && replicaSet.ObjectMeta.UID == ownerRef.UID {
-				namespace = replicaSet.ObjectMeta.Namespace
-				err = nil
-				break
-			}
-		}
-	case "DaemonSet":
-		var daemonSets *v1.DaemonSetList
-		daemonSets, err = clientset.AppsV1().DaemonSets("").List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return
-		}
-		for _, daemonSet := range daemonSets.Items {
-			if daemonSet.ObjectMeta.Name == ownerRef.Name && daemonSet.ObjectMeta.UID == ownerRef.UID {
-				namespace = daemonSet.ObjectMeta.Namespace
-				err = nil
-				break
-			}
-		}
-	case "StatefulSet":
-		var statefulSets *v1.StatefulSetList
-		statefulSets, err = clientset.AppsV1().StatefulSets("").List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return
-		}
-		for _, statefulSet := range statefulSets.Items {
-			if statefulSet.ObjectMeta.Name == ownerRef.Name && statefulSet.ObjectMeta.UID == ownerRef.UID {
-				namespace = statefulSet.ObjectMeta.Namespace
-				err = nil
-				break
-			}
-		}
-	case "ReplicationController":
-		var replicationControllers *corev1.ReplicationControllerList
-		replicationControllers, err = clientset.CoreV1().ReplicationControllers("").List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return
-		}
-		for _, replicationController := range replicationControllers.Items {
-			if replicationController.ObjectMeta.Name == ownerRef.Name && replicationController.ObjectMeta.UID == ownerRef.UID {
-				namespace = replicationController.ObjectMeta.Namespace
-				err = nil
-				break
-			}
-		}
-	default:
-		glog.Infof("owner reference kind is not supported: %v, using default namespace", ownerRef.Kind)
-		namespace = "default"
-		return
-	}
-
-	if namespace == "" {
-		err = errors.New("pod namespace is not found")
-	}
-
-	return
-}
-
-func toSafeJSONPatchKey(in string) string {
-	out := strings.ReplaceAll(in, "~", "~0")
-	out = strings.ReplaceAll(out, "/", "~1")
-	return out
-}
-
 func parsePodNetworkSelections(podNetworks, defaultNamespace string) ([]*multus.NetworkSelectionElement, error) {
 	var networkSelections []*multus.NetworkSelectionElement
 
@@ -345,64 +408,360 @@ func parsePodNetworkSelectionElement(selection, defaultNamespace string) (*multu
 	return networkSelectionElement, nil
 }
 
-func getNetworkAttachmentDefinition(namespace, name string) (*cniv1.NetworkAttachmentDefinition, error) {
-	path := fmt.Sprintf("/apis/k8s.cni.cncf.io/v1/namespaces/%s/network-attachment-definitions/%s", namespace, name)
-	rawNetworkAttachmentDefinition, err := clientset.ExtensionsV1beta1().RESTClient().Get().AbsPath(path).DoRaw(context.TODO())
+// networkRequirements resources, node selectors and resource claims needed by the networks of a pod, warnings
+// are returned in the admission response
+type networkRequirements struct {
+	resourceRequests    map[string]int64
+	nodeSelectors       map[string]string
+	resourceClaims      []NetworkResourceClaim
+	capabilities        []corev1.Capability
+	guaranteedQoSAction string
+	// networkResources device resources of the networks indexed by namespace/name@interface
+	networkResources map[string][]NetworkResource
+	warnings         []string
+}
+
+func (wh *Webhook) parseNetworkAttachDefinition(ctx context.Context, net *multus.NetworkSelectionElement, ifName, podNamespace string, requirements *networkRequirements) error {
+	/* for each network in annotation look up network-attachment-definition, cache asks API server on a miss */
+	networkAttachmentDefinition, staleness, err := wh.nadCache.Lookup(ctx, net.Namespace, net.Name)
+	if err == nil && staleness > 0 {
+		/* informers lost their watch, data is served until it exceeds the maximum staleness */
+		glog.Warningf("network attachment definition '%s/%s' may be stale for %v", net.Namespace, net.Name, staleness)
+		requirements.warnings = append(requirements.warnings, fmt.Sprintf(
+			"network attachment definition %s/%s may be stale for %v", net.Namespace, net.Name, staleness.Round(time.Second)))
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		networkAttachmentDefinition, err = wh.handleLookupFailure(net, podNamespace, err, requirements)
+		if networkAttachmentDefinition == nil && err == nil {
+			return nil
+		}
+	}
 	if err != nil {
-		err := errors.Wrapf(err, "could not get Network Attachment Definition %s/%s", namespace, name)
-		glog.Error(err)
-		return nil, err
+		/* if doesn't exist: deny pod */
+		reason := errors.Wrapf(err, "could not find network attachment definition '%s/%s'", net.Namespace, net.Name)
+		glog.Error(reason)
+		return reason
+	}
+	glog.Infof("network attachment definition '%s/%s' found", net.Namespace, net.Name)
+
+	resources, nodeSelector, err := parseNetAttachDef(networkAttachmentDefinition, wh.config.ControlSwitches())
+	if err != nil {
+		reason := errors.Wrapf(err, "invalid net-attach-def %s/%s", net.Namespace, net.Name)
+		glog.Error(reason)
+		return reason
+	}
+
+	claim, err := parseNetAttachDefResourceClaim(networkAttachmentDefinition, podNamespace)
+	if err != nil {
+		reason := errors.Wrapf(err, "invalid net-attach-def %s/%s", net.Namespace, net.Name)
+		glog.Error(reason)
+		return reason
+	}
+
+	hugepages, err := parseNetAttachDefHugepages(networkAttachmentDefinition.GetAnnotations())
+	if err != nil {
+		reason := errors.Wrapf(err, "invalid net-attach-def %s/%s", net.Namespace, net.Name)
+		glog.Error(reason)
+		return reason
+	}
+	for resourceName, amount := range hugepages {
+		requirements.resourceRequests[resourceName] += amount
+		glog.Infof("%d bytes of '%s' need to be requested for network '%s/%s'", amount, resourceName, net.Namespace, net.Name)
 	}
 
-	networkAttachmentDefinition := cniv1.NetworkAttachmentDefinition{}
-	json.Unmarshal(rawNetworkAttachmentDefinition, &networkAttachmentDefinition)
+	capabilities, err := parseNetAttachDefCapabilities(networkAttachmentDefinition.GetAnnotations())
+	if err != nil {
+		reason := errors.Wrapf(err, "invalid net-attach-def %s/%s", net.Namespace, net.Name)
+		glog.Error(reason)
+		return reason
+	}
+	requirements.capabilities = mergeCapabilities(requirements.capabilities, capabilities)
+
+	qosAction, err := parseNetAttachDefGuaranteedQoSAction(networkAttachmentDefinition)
+	if err != nil {
+		reason := errors.Wrapf(err, "invalid net-attach-def %s/%s", net.Namespace, net.Name)
+		glog.Error(reason)
+		return reason
+	}
+	requirements.guaranteedQoSAction = stricterGuaranteedQoSAction(requirements.guaranteedQoSAction, qosAction)
+	/* a claim is shared by all attachments of the network */
+	if claim != nil && !slices.ContainsFunc(requirements.resourceClaims, func(c NetworkResourceClaim) bool {
+		return c.Name == claim.Name
+	}) {
+		glog.Infof("resource claim '%s' needs to be requested for network '%s/%s'", claim.Name, net.Namespace, net.Name)
+		requirements.resourceClaims = append(requirements.resourceClaims, *claim)
+	}
+
+	if len(resources) == 0 {
+		glog.Infof("network '%s/%s' doesn't use custom resources, skipping...", net.Namespace, net.Name)
+	}
+	for resourceName, count := range resources {
+		/* add resource to map/increase if it was already there */
+		requirements.resourceRequests[resourceName] += count
+		glog.Infof("resource '%s' needs to be requested %d times for network '%s/%s'", resourceName, count, net.Namespace, net.Name)
+	}
+	if len(resources) > 0 {
+		selection := fmt.Sprintf("%s/%s@%s", net.Namespace, net.Name, ifName)
+		requirements.networkResources[selection] = getNetworkResources(wh.config.ResourceNameMappings().Apply(podNamespace, resources))
+	}
+
+	/* add the net-attach-def node selector label to the desired node selectors */
+	for name, value := range nodeSelector {
+		requirements.nodeSelectors[name] = value
+	}
+
+	return nil
+}
 
-	return &networkAttachmentDefinition, nil
+// handleLookupFailure applies the lookup failure policy of the pod namespace to the net-attach-def which could
+// not be retrieved. It returns the net-attach-def to use, nil without error when the network is skipped.
+func (wh *Webhook) handleLookupFailure(net *multus.NetworkSelectionElement, podNamespace string, lookupErr error,
+	requirements *networkRequirements) (*cniv1.NetworkAttachmentDefinition, error) {
+	switch wh.config.ControlSwitches().GetLookupFailurePolicy(podNamespace) {
+	case controlswitches.LookupFailurePolicyAdmit:
+		warning := fmt.Sprintf("network resources of net-attach-def %s/%s are not injected: %v", net.Namespace, net.Name, lookupErr)
+		glog.Warning(warning)
+		requirements.warnings = append(requirements.warnings, warning)
+		return nil, nil
+	case controlswitches.LookupFailurePolicyUseCached:
+		netAttachDef, found := wh.nadCache.GetLastKnown(net.Namespace, net.Name)
+		if !found {
+			return nil, errors.Wrap(lookupErr, "no last known net-attach-def")
+		}
+		warning := fmt.Sprintf("last known net-attach-def %s/%s is used: %v", net.Namespace, net.Name, lookupErr)
+		glog.Warning(warning)
+		requirements.warnings = append(requirements.warnings, warning)
+		return netAttachDef, nil
+	}
+	return nil, lookupErr
 }
 
-func parseNetworkAttachDefinition(net *multus.NetworkSelectionElement, reqs map[string]int64, nsMap map[string]string) (map[string]int64, map[string]string, error) {
-	/* for each network in annotation ask API server for network-attachment-definition */
-	annotationsMap := nadCache.Get(net.Namespace, net.Name)
-	if annotationsMap == nil {
-		glog.Infof("cache entry not found, retrieving network attachment definition '%s/%s' from api server", net.Namespace, net.Name)
-		networkAttachmentDefinition, err := getNetworkAttachmentDefinition(net.Namespace, net.Name)
+// parseNetAttachDef returns the number of units of every resource used by a single attachment of the
+// net-attach-def and its node selector label. Resource names defined by annotations take precedence, the CNI
+// config is searched only when there are none. Net-attach-defs referencing DRA devices don't use any
+// resources in the replace DRA injection mode.
+func parseNetAttachDef(netAttachDef *cniv1.NetworkAttachmentDefinition, switches *controlswitches.ControlSwitches) (map[string]int64, map[string]string, error) {
+	resourceNames, nodeSelector, err := parseNetAttachDefAnnotations(netAttachDef.GetAnnotations(), switches.GetResourceNameKeys(),
+		switches.GetResourceNameKeysMode())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(resourceNames) == 0 {
+		resourceNames, err = getResourceNamesFromConfig(netAttachDef.Spec.Config, switches.GetResourceConfigPaths())
 		if err != nil {
-			/* if doesn't exist: deny pod */
-			reason := errors.Wrapf(err, "could not find network attachment definition '%s/%s'", net.Namespace, net.Name)
-			glog.Error(reason)
-			return reqs, nsMap, reason
+			return nil, nil, err
+		}
+	}
+	resources, err := getNetAttachDefResources(netAttachDef.GetAnnotations(), resourceNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hasResourceClaimAnnotations(netAttachDef.GetAnnotations()) && switches.GetDRAInjectionMode() == controlswitches.DRAInjectionModeReplace {
+		return map[string]int64{}, nodeSelector, nil
+	}
+	return resources, nodeSelector, nil
+}
+
+// getNetAttachDefResources applies the resource count annotation to the resource names and adds resources
+// listed by the resources annotation in the "name[=count],..." format
+func getNetAttachDefResources(annotationsMap map[string]string, resourceNames []string) (map[string]int64, error) {
+	count := int64(1)
+	if value, exists := annotationsMap[resourceCountKey]; exists {
+		/* the count also applies to the devices of the device class annotation */
+		if len(resourceNames) == 0 && !hasResourceClaimAnnotations(annotationsMap) {
+			return nil, fmt.Errorf("resource count annotation %s is set but resource name is not defined", resourceCountKey)
+		}
+		var err error
+		if count, err = parseResourceCount(value); err != nil {
+			return nil, err
 		}
-		annotationsMap = networkAttachmentDefinition.GetAnnotations()
 	}
-	glog.Infof("network attachment definition '%s/%s' found", net.Namespace, net.Name)
 
-	/* network object exists, so check if it contains resourceName annotation */
-	for _, networkResourceNameKey := range controlSwitches.GetResourceNameKeys() {
+	resources := make(map[string]int64)
+	for _, resourceName := range resourceNames {
+		resources[resourceName] += count
+	}
+
+	if value, exists := annotationsMap[resourcesKey]; exists {
+		for _, resource := range strings.Split(value, ",") {
+			resourceName, resourceCount, hasCount := strings.Cut(resource, "=")
+			resourceName = strings.TrimSpace(resourceName)
+			if resourceName == "" {
+				return nil, fmt.Errorf("resources annotation %q has entry without resource name", value)
+			}
+			count := int64(1)
+			if hasCount {
+				var err error
+				if count, err = parseResourceCount(resourceCount); err != nil {
+					return nil, err
+				}
+			}
+			resources[resourceName] += count
+		}
+	}
+
+	return resources, nil
+}
+
+// parseNetAttachDefHugepages returns the amount of hugepages in bytes per page size needed by a single attachment
+// of the net-attach-def, listed by the hugepages annotation in the "hugepages-<size>=<amount>,..." format, and the
+// amount of memory set by the memory annotation. Other containers request hugepages and memory as well, so
+// they are not network resource names for the validation of pods.
+func parseNetAttachDefHugepages(annotationsMap map[string]string) (map[string]int64, error) {
+	amounts := make(map[string]int64)
+	if value, exists := annotationsMap[hugepagesKey]; exists {
+		for _, hugepages := range strings.Split(value, ",") {
+			resourceName, amount, hasAmount := strings.Cut(hugepages, "=")
+			resourceName = strings.TrimSpace(resourceName)
+			matches := HugepageRegex.FindStringSubmatch(resourceName)
+			if matches == nil || !hasAmount {
+				return nil, fmt.Errorf("hugepages annotation entry %q is not in the hugepages-<size>=<amount> format", hugepages)
+			}
+			pageSize, err := resource.ParseQuantity(matches[1])
+			if err != nil || pageSize.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid hugepage size %q", matches[1])
+			}
+			quantity, err := parseAmount(amount)
+			if err != nil {
+				return nil, err
+			}
+			if quantity%pageSize.Value() != 0 {
+				return nil, fmt.Errorf("amount %s of %s is not a multiple of the page size", strings.TrimSpace(amount), resourceName)
+			}
+			amounts[resourceName] += quantity
+		}
+	}
+	if value, exists := annotationsMap[memoryKey]; exists {
+		quantity, err := parseAmount(value)
+		if err != nil {
+			return nil, err
+		}
+		amounts[string(corev1.ResourceMemory)] += quantity
+	}
+	return amounts, nil
+}
+
+func parseAmount(value string) (int64, error) {
+	quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+	if err != nil || quantity.Sign() <= 0 {
+		return 0, fmt.Errorf("amount %q is not a positive quantity", value)
+	}
+	return quantity.Value(), nil
+}
+
+func parseResourceCount(value string) (int64, error) {
+	count, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("resource count %q is not a positive integer", value)
+	}
+	return count, nil
+}
+
+// parseNetAttachDefAnnotations returns the resource names and the node selector label defined by the
+// net-attach-def annotations, it is shared by the mutation and by the net-attach-def validation. keysMode
+// selects which resource names are returned when more than one of the resource name keys is present.
+func parseNetAttachDefAnnotations(annotationsMap map[string]string, resourceNameKeys []string, keysMode string) ([]string, map[string]string, error) {
+	var resourceNames, presentKeys []string
+	for _, networkResourceNameKey := range resourceNameKeys {
 		if resourceName, exists := annotationsMap[networkResourceNameKey]; exists {
-			/* add resource to map/increment if it was already there */
-			reqs[resourceName]++
-			glog.Infof("resource '%s' needs to be requested for network '%s/%s'", resourceName, net.Namespace, net.Name)
-		} else {
-			glog.Infof("network '%s/%s' doesn't use custom resources, skipping...", net.Namespace, net.Name)
+			if strings.TrimSpace(resourceName) == "" {
+				return nil, nil, fmt.Errorf("resource name annotation %s is empty", networkResourceNameKey)
+			}
+			resourceNames = append(resourceNames, resourceName)
+			presentKeys = append(presentKeys, networkResourceNameKey)
+		}
+	}
+
+	if len(resourceNames) > 1 {
+		switch keysMode {
+		case controlswitches.ResourceNameKeysModeFirstMatch:
+			glog.Infof("resource name annotations %v are present, using the first one %s", presentKeys, presentKeys[0])
+			resourceNames = resourceNames[:1]
+		case controlswitches.ResourceNameKeysModeErrorOnMultiple:
+			return nil, nil, fmt.Errorf("more than one resource name annotation is present: %s", strings.Join(presentKeys, ", "))
 		}
 	}
 
-	/* parse the net-attach-def annotations for node selector label and add it to the desiredNsMap */
+	nodeSelector := make(map[string]string)
 	if ns, exists := annotationsMap[nodeSelectorKey]; exists {
 		nsNameValue := strings.Split(ns, "=")
-		nsNameValueLen := len(nsNameValue)
-		if nsNameValueLen > 2 {
-			reason := fmt.Errorf("node selector in net-attach-def %s has more than one label", net.Name)
-			glog.Error(reason)
-			return reqs, nsMap, reason
-		} else if nsNameValueLen == 2 {
-			nsMap[strings.TrimSpace(nsNameValue[0])] = strings.TrimSpace(nsNameValue[1])
-		} else {
-			nsMap[strings.TrimSpace(ns)] = ""
+		if len(nsNameValue) > 2 {
+			return nil, nil, fmt.Errorf("node selector %q has more than one label", ns)
+		}
+		name := strings.TrimSpace(nsNameValue[0])
+		if name == "" {
+			return nil, nil, fmt.Errorf("node selector %q has empty label name", ns)
+		}
+		nodeSelector[name] = ""
+		if len(nsNameValue) == 2 {
+			nodeSelector[name] = strings.TrimSpace(nsNameValue[1])
+		}
+	}
+
+	return resourceNames, nodeSelector, nil
+}
+
+// getPodNetworks parses the default and additional networks of the pod, exists is false when the pod
+// doesn't have any of the network annotations
+func getPodNetworks(pod corev1.Pod, userDefinedPatch []types.JSONPatchOperation) ([]*multus.NetworkSelectionElement, bool, error) {
+	var networks []*multus.NetworkSelectionElement
+
+	defaultNetSelection, defExist := getNetworkSelections(defaultNetworkAnnotationKey, pod, userDefinedPatch)
+	additionalNetSelections, addExists := getNetworkSelections(networksAnnotationKey, pod, userDefinedPatch)
+	if !defExist && !addExists {
+		return nil, false, nil
+	}
+
+	if defaultNetSelection != "" {
+		defNetwork, err := parsePodNetworkSelections(defaultNetSelection, pod.ObjectMeta.Namespace)
+		if err != nil {
+			return nil, true, err
+		}
+		if len(defNetwork) == 1 {
+			/* multus attaches the default network as the pod interface */
+			if defNetwork[0].InterfaceRequest == "" {
+				defNetwork[0].InterfaceRequest = defaultNetworkInterface
+			}
+			networks = append(networks, defNetwork[0])
+		}
+	}
+	if additionalNetSelections != "" {
+		/* unmarshal list of network selection objects */
+		additionalNetworks, err := parsePodNetworkSelections(additionalNetSelections, pod.ObjectMeta.Namespace)
+		if err != nil {
+			return nil, true, err
+		}
+		networks = append(networks, additionalNetworks...)
+	}
+
+	return networks, true, nil
+}
+
+// getNetworkRequirements returns the resources, node selectors and resource claims needed by the networks of a pod
+// in the namespace, resource names found on the net-attach-defs are rewritten by the resource name mappings
+func (wh *Webhook) getNetworkRequirements(ctx context.Context, namespace string, networks []*multus.NetworkSelectionElement) (*networkRequirements, error) {
+	requirements := &networkRequirements{
+		/* map of resources request needed by a pod and a number of them */
+		resourceRequests: make(map[string]int64),
+		/* map of node labels on which pod needs to be scheduled*/
+		nodeSelectors: make(map[string]string),
+		/* map of network selections to their device resources */
+		networkResources: make(map[string][]NetworkResource),
+	}
+
+	for i, n := range networks {
+		if err := wh.parseNetworkAttachDefinition(ctx, n, getNetworkInterfaceName(networks, i), namespace, requirements); err != nil {
+			return nil, err
 		}
 	}
 
-	return reqs, nsMap, nil
+	requirements.resourceRequests = wh.config.ResourceNameMappings().Apply(namespace, requirements.resourceRequests)
+	return requirements, nil
+}
+
+// isDryRun returns true when the admission request must not have side effects
+func isDryRun(ar *admissionv1.AdmissionReview) bool {
+	return ar.Request != nil && ar.Request.DryRun != nil && *ar.Request.DryRun
 }
 
 func handleValidationError(w http.ResponseWriter, ar *admissionv1.AdmissionReview, orgErr error) {
@@ -421,34 +780,12 @@ func writeResponse(w http.ResponseWriter, ar *admissionv1.AdmissionReview) {
 	w.Write(resp)
 }
 
-func patchEmptyResources(patch []types.JSONPatchOperation, containerIndex uint, key string) []types.JSONPatchOperation {
-	patch = append(patch, types.JSONPatchOperation{
-		Operation: "add",
-		Path:      "/spec/containers/" + fmt.Sprintf("%d", containerIndex) + "/resources/" + toSafeJSONPatchKey(key),
-		Value:     corev1.ResourceList{},
-	})
-	return patch
-}
-
-func addVolDownwardAPI(patch []types.JSONPatchOperation, hugepageResourceList []hugepageResourceData, pod *corev1.Pod) []types.JSONPatchOperation {
-	for _, vol := range pod.Spec.Volumes {
-		if vol.Name == podNetInfoVolumeName {
-			glog.Infof("%s volume already exists, skipping injection", podNetInfoVolumeName)
-			return patch
-		}
-	}
-
-	if len(pod.Spec.Volumes) == 0 {
-		patch = append(patch, types.JSONPatchOperation{
-			Operation: "add",
-			Path:      "/spec/volumes",
-			Value:     []corev1.Volume{},
-		})
-	}
-
+// addVolDownwardAPI adds the Downward API volume, items missing in a Downward API volume of the pod with the same
+// name are merged into it. It returns warnings about items of the pod which project other fields than NRI would.
+func addVolDownwardAPI(pod *corev1.Pod, config *downwardAPIConfig, hugepageResourceList []hugepageResourceData) []string {
 	dAPIItems := []corev1.DownwardAPIVolumeFile{}
 
-	if len(pod.Labels) > 0 {
+	if len(pod.Labels) > 0 && config.hasItem(controlswitches.DownwardAPIItemLabels) {
 		labels := corev1.ObjectFieldSelector{
 			FieldPath: "metadata.labels",
 		}
@@ -459,7 +796,7 @@ func addVolDownwardAPI(patch []types.JSONPatchOperation, hugepageResourceList []
 		dAPIItems = append(dAPIItems, dAPILabels)
 	}
 
-	if len(pod.Annotations) > 0 {
+	if len(pod.Annotations) > 0 && config.hasItem(controlswitches.DownwardAPIItemAnnotations) {
 		annotations := corev1.ObjectFieldSelector{
 			FieldPath: "metadata.annotations",
 		}
@@ -470,6 +807,20 @@ func addVolDownwardAPI(patch []types.JSONPatchOperation, hugepageResourceList []
 		dAPIItems = append(dAPIItems, dAPIAnnotations)
 	}
 
+	if _, exists := pod.Annotations[networkResourcesMapKey]; exists && config.hasItem(controlswitches.DownwardAPIItemNetworkResourcesMap) {
+		networkResourcesMap := corev1.ObjectFieldSelector{
+			FieldPath: fmt.Sprintf("metadata.annotations['%s']", networkResourcesMapKey),
+		}
+		dAPINetworkResourcesMap := corev1.DownwardAPIVolumeFile{
+			Path:     types.NetworkResourcesMapPath,
+			FieldRef: &networkResourcesMap,
+		}
+		dAPIItems = append(dAPIItems, dAPINetworkResourcesMap)
+	}
+
+	if !config.hasItem(controlswitches.DownwardAPIItemHugepages) {
+		hugepageResourceList = nil
+	}
 	for _, hugepageResource := range hugepageResourceList {
 		hugepageSelector := corev1.ResourceFieldSelector{
 			Resource:      hugepageResource.ResourceName,
@@ -483,6 +834,16 @@ func addVolDownwardAPI(patch []types.JSONPatchOperation, hugepageResourceList []
 		dAPIItems = append(dAPIItems, dAPIHugepage)
 	}
 
+	for volumeIndex := range pod.Spec.Volumes {
+		vol := &pod.Spec.Volumes[volumeIndex]
+		/* volumes of other types were refused by getDownwardAPIVolumeConflict */
+		if vol.Name != config.volumeName {
+			continue
+		}
+		glog.Infof("%s volume already exists, merging missing items", config.volumeName)
+		return mergeDownwardAPIItems(vol, dAPIItems)
+	}
+
 	dAPIVolSource := corev1.DownwardAPIVolumeSource{
 		Items: dAPIItems,
 	}
@@ -490,216 +851,278 @@ func addVolDownwardAPI(patch []types.JSONPatchOperation, hugepageResourceList []
 		DownwardAPI: &dAPIVolSource,
 	}
 	vol := corev1.Volume{
-		Name:         podNetInfoVolumeName,
+		Name:         config.volumeName,
 		VolumeSource: volSource,
 	}
 
-	patch = append(patch, types.JSONPatchOperation{
-		Operation: "add",
-		Path:      "/spec/volumes/-",
-		Value:     vol,
-	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, vol)
+	return nil
+}
 
-	return patch
+// mergeDownwardAPIItems adds the items whose path is not projected by the volume yet, items of the pod take
+// precedence. It returns warnings about items of the pod which project other fields at the same path.
+func mergeDownwardAPIItems(volume *corev1.Volume, items []corev1.DownwardAPIVolumeFile) []string {
+	var warnings []string
+	for _, item := range items {
+		index := slices.IndexFunc(volume.DownwardAPI.Items, func(existing corev1.DownwardAPIVolumeFile) bool {
+			return existing.Path == item.Path
+		})
+		if index < 0 {
+			volume.DownwardAPI.Items = append(volume.DownwardAPI.Items, item)
+			continue
+		}
+		existing := volume.DownwardAPI.Items[index]
+		if !equality.Semantic.DeepEqual(existing.FieldRef, item.FieldRef) ||
+			!equality.Semantic.DeepEqual(existing.ResourceFieldRef, item.ResourceFieldRef) {
+			warnings = append(warnings, fmt.Sprintf("item %s of Downward API volume %s projects another field than "+
+				"network resources injector expects, it is kept", item.Path, volume.Name))
+			continue
+		}
+		glog.Infof("Downward API item %s already exists, skipping", item.Path)
+	}
+	return warnings
 }
 
-func addVolumeMount(patch []types.JSONPatchOperation, containers []corev1.Container) []types.JSONPatchOperation {
+// addVolumeMount mounts the Downward API volume in the containers selected by the configuration, it returns
+// warnings about containers which already mount another volume at the mount path
+func addVolumeMount(containers []corev1.Container, config *downwardAPIConfig) []string {
+	var warnings []string
 	vm := corev1.VolumeMount{
-		Name:      podNetInfoVolumeName,
+		Name:      config.volumeName,
 		ReadOnly:  true,
-		MountPath: types.DownwardAPIMountPath,
+		MountPath: config.mountPath,
 	}
-	for containerIndex, container := range containers {
+	for containerIndex := range containers {
+		container := &containers[containerIndex]
+		if config.containerNames != nil && !slices.Contains(config.containerNames, container.Name) {
+			continue
+		}
 		if slices.ContainsFunc(container.VolumeMounts, func(vm corev1.VolumeMount) bool {
-			return vm.Name == podNetInfoVolumeName
+			return vm.Name == config.volumeName
 		}) {
 			continue
 		}
-
-		if len(container.VolumeMounts) == 0 {
-			patch = append(patch, types.JSONPatchOperation{
-				Operation: "add",
-				Path:      "/spec/containers/" + strconv.Itoa(containerIndex) + "/volumeMounts",
-				Value:     []corev1.VolumeMount{},
-			})
+		if index := slices.IndexFunc(container.VolumeMounts, func(vm corev1.VolumeMount) bool {
+			return vm.MountPath == config.mountPath
+		}); index >= 0 {
+			warnings = append(warnings, fmt.Sprintf("container %s already mounts volume %s at %s, Downward API volume %s is not mounted",
+				container.Name, container.VolumeMounts[index].Name, config.mountPath, config.volumeName))
+			continue
 		}
-		patch = append(patch, types.JSONPatchOperation{
-			Operation: "add",
-			Path:      "/spec/containers/" + strconv.Itoa(containerIndex) + "/volumeMounts/-",
-			Value:     vm,
-		})
+		container.VolumeMounts = append(container.VolumeMounts, vm)
 	}
+	return warnings
+}
 
-	return patch
+// getDownwardAPIVolumeConflict returns warning when the pod has a volume with the name of the Downward API volume
+// which isn't a Downward API volume
+func getDownwardAPIVolumeConflict(pod *corev1.Pod, config *downwardAPIConfig) string {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == config.volumeName && vol.DownwardAPI == nil {
+			return fmt.Sprintf("volume %s of the pod isn't a Downward API volume, network information isn't exposed, "+
+				"use annotation %s to choose another volume name", config.volumeName, downwardAPIVolumeNameKey)
+		}
+	}
+	return ""
 }
 
-func createVolPatch(patch []types.JSONPatchOperation, hugepageResourceList []hugepageResourceData, pod *corev1.Pod) []types.JSONPatchOperation {
-	patch = addVolumeMount(patch, pod.Spec.Containers)
-	patch = addVolDownwardAPI(patch, hugepageResourceList, pod)
-	return patch
+// addVolumes adds the Downward API volume and mounts it, init containers are included when enabled or when they
+// are named by the configuration. Nothing is injected when the pod has a conflicting volume, conflicts are
+// returned as warnings.
+func addVolumes(pod *corev1.Pod, config *downwardAPIConfig, hugepageResourceList []hugepageResourceData, includeInitContainers bool) []string {
+	if conflict := getDownwardAPIVolumeConflict(pod, config); conflict != "" {
+		return []string{conflict}
+	}
+	warnings := addVolumeMount(pod.Spec.Containers, config)
+	if includeInitContainers || config.containerNames != nil {
+		warnings = append(warnings, addVolumeMount(pod.Spec.InitContainers, config)...)
+	}
+	return append(warnings, addVolDownwardAPI(pod, config, hugepageResourceList)...)
 }
 
-func addEnvVar(patch []types.JSONPatchOperation, containerIndex int, firstElement bool,
-	envName string, envVal string) []types.JSONPatchOperation {
-	env := corev1.EnvVar{
+func addEnvVar(container *corev1.Container, envName string, envVal string) {
+	// Determine if requested ENV already exists
+	for _, env := range container.Env {
+		if env.Name == envName {
+			if env.Value != envVal {
+				glog.Warningf("Error, adding env '%s', name existed but value different: '%s' != '%s'",
+					envName, env.Value, envVal)
+			}
+			return
+		}
+	}
+
+	container.Env = append(container.Env, corev1.EnvVar{
 		Name:  envName,
 		Value: envVal,
-	}
+	})
+}
 
-	if firstElement {
-		patch = append(patch, types.JSONPatchOperation{
-			Operation: "add",
-			Path:      "/spec/containers/" + fmt.Sprintf("%d", containerIndex) + "/env",
-			Value:     []corev1.EnvVar{env},
-		})
-	} else {
-		patch = append(patch, types.JSONPatchOperation{
-			Operation: "add",
-			Path:      "/spec/containers/" + fmt.Sprintf("%d", containerIndex) + "/env/-",
-			Value:     env,
-		})
+// addEnvVarFrom adds env var whose value is read from the source, an env var with the same name is kept
+func addEnvVarFrom(container *corev1.Container, envName string, source *corev1.EnvVarSource) {
+	for _, env := range container.Env {
+		if env.Name == envName {
+			glog.Warningf("env '%s' already exists in container '%s', skipping", envName, container.Name)
+			return
+		}
 	}
 
-	return patch
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name:      envName,
+		ValueFrom: source,
+	})
 }
 
-func createEnvPatch(patch []types.JSONPatchOperation, container *corev1.Container,
-	containerIndex int, envName string, envVal string) []types.JSONPatchOperation {
-	// Determine if requested ENV already exists
-	found := false
-	firstElement := false
-	if len(container.Env) != 0 {
-		for _, env := range container.Env {
-			if env.Name == envName {
-				found = true
-				if env.Value != envVal {
-					glog.Warningf("Error, adding env '%s', name existed but value different: '%s' != '%s'",
-						envName, env.Value, envVal)
+// addHugepageEnvVars sets the hugepages of the containers in MiB in their env vars, e.g. HUGEPAGES_1G_REQUEST
+func addHugepageEnvVars(pod *corev1.Pod, hugepageResourceList []hugepageResourceData) {
+	for _, hugepageResource := range hugepageResourceList {
+		for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+			for containerIndex := range containers {
+				if containers[containerIndex].Name != hugepageResource.ContainerName {
+					continue
 				}
-				break
+				addEnvVarFrom(&containers[containerIndex], hugepageResource.EnvName, &corev1.EnvVarSource{
+					ResourceFieldRef: &corev1.ResourceFieldSelector{
+						Resource: hugepageResource.ResourceName,
+						Divisor:  *resource.NewQuantity(1*1024*1024, resource.BinarySI),
+					},
+				})
 			}
 		}
-	} else {
-		firstElement = true
-	}
-
-	if !found {
-		patch = addEnvVar(patch, containerIndex, firstElement, envName, envVal)
 	}
-	return patch
 }
 
-func createNodeSelectorPatch(patch []types.JSONPatchOperation, existing map[string]string, desired map[string]string) []types.JSONPatchOperation {
-	targetMap := make(map[string]string)
-	for k, v := range existing {
-		targetMap[k] = v
+func addNodeSelector(pod *corev1.Pod, desired map[string]string) {
+	if len(desired) == 0 {
+		return
 	}
-	for k, v := range desired {
-		targetMap[k] = v
+	if pod.Spec.NodeSelector == nil {
+		pod.Spec.NodeSelector = make(map[string]string)
 	}
-	if len(targetMap) == 0 {
-		return patch
+	for k, v := range desired {
+		pod.Spec.NodeSelector[k] = v
 	}
-	patch = append(patch, types.JSONPatchOperation{
-		Operation: "add",
-		Path:      "/spec/nodeSelector",
-		Value:     targetMap,
-	})
-	return patch
 }
 
-func createResourcePatch(patch []types.JSONPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) []types.JSONPatchOperation {
-	/* check whether resources paths exists in the first container and add as the first patches if missing */
-	if len(Containers[0].Resources.Requests) == 0 {
-		patch = patchEmptyResources(patch, 0, "requests")
-	}
-	if len(Containers[0].Resources.Limits) == 0 {
-		patch = patchEmptyResources(patch, 0, "limits")
+// getResourceInjectionTarget returns the container which requests the network resources, it is the first
+// container unless the pod annotation names another container, init and sidecar containers included
+func getResourceInjectionTarget(pod *corev1.Pod) (*corev1.Container, error) {
+	name, exists := pod.ObjectMeta.Annotations[resourceInjectionContainerKey]
+	if !exists {
+		if len(pod.Spec.Containers) == 0 {
+			return nil, nil
+		}
+		return &pod.Spec.Containers[0], nil
 	}
 
-	for resourceName := range resourceRequests {
-		for _, container := range Containers {
-			if _, exists := container.Resources.Limits[corev1.ResourceName(resourceName)]; exists {
-				delete(resourceRequests, resourceName)
-			}
-			if _, exists := container.Resources.Requests[corev1.ResourceName(resourceName)]; exists {
-				delete(resourceRequests, resourceName)
+	for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for containerIndex := range containers {
+			if containers[containerIndex].Name == name {
+				return &containers[containerIndex], nil
 			}
 		}
 	}
+	return nil, fmt.Errorf("container %q of annotation %s not found in the pod", name, resourceInjectionContainerKey)
+}
 
+func addResources(pod *corev1.Pod, target *corev1.Container, resourceRequests map[string]int64) {
+	if target == nil {
+		glog.Warningf("pod has no containers, skipping injection of resources %v", resourceRequests)
+		return
+	}
+
+	/* memory and hugepages of the networks come on top of what the container needs for itself */
 	resourceList := *getResourceList(resourceRequests)
+	for resourceName, quantity := range resourceList {
+		if isByteResource(resourceName) {
+			addResourceQuantity(&target.Resources, resourceName, quantity)
+			delete(resourceList, resourceName)
+		}
+	}
 
-	for resource, quantity := range resourceList {
-		patch = appendResource(patch, resource.String(), quantity, quantity)
+	/* skip resources which are already requested by any of the containers */
+	for resourceName := range resourceList {
+		for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for _, container := range containers {
+				if _, exists := container.Resources.Limits[resourceName]; exists {
+					delete(resourceList, resourceName)
+				}
+				if _, exists := container.Resources.Requests[resourceName]; exists {
+					delete(resourceList, resourceName)
+				}
+			}
+		}
 	}
 
-	return patch
+	for resourceName, quantity := range resourceList {
+		setResource(target, resourceName, quantity, quantity)
+	}
 }
 
-func updateResourcePatch(patch []types.JSONPatchOperation, Containers []corev1.Container, resourceRequests map[string]int64) []types.JSONPatchOperation {
-	var existingrequestsMap map[corev1.ResourceName]resource.Quantity
-	var existingLimitsMap map[corev1.ResourceName]resource.Quantity
-
-	if len(Containers[0].Resources.Requests) == 0 {
-		patch = patchEmptyResources(patch, 0, "requests")
-	} else {
-		existingrequestsMap = Containers[0].Resources.Requests
-	}
-	if len(Containers[0].Resources.Limits) == 0 {
-		patch = patchEmptyResources(patch, 0, "limits")
-	} else {
-		existingLimitsMap = Containers[0].Resources.Limits
+func updateResources(target *corev1.Container, resourceRequests map[string]int64) {
+	if target == nil {
+		glog.Warningf("pod has no containers, skipping injection of resources %v", resourceRequests)
+		return
 	}
 
-	resourceList := *getResourceList(resourceRequests)
+	for resourceName, quantity := range *getResourceList(resourceRequests) {
+		addResourceQuantity(&target.Resources, resourceName, quantity)
+	}
+}
 
-	for resourceName, quantity := range resourceList {
-		reqQuantity := quantity
-		limitQuantity := quantity
-		if value, ok := existingrequestsMap[resourceName]; ok {
-			reqQuantity.Add(value)
+// addResourceQuantity adds the quantity to the resource requirements, a request is set only when the resource is
+// requested or not limited at all, so a request defaulted to the limit by the API server grows with the limit. A
+// limit is set only when the resource is limited or not requested at all.
+func addResourceQuantity(resources *corev1.ResourceRequirements, resourceName corev1.ResourceName, quantity resource.Quantity) {
+	request, hasRequest := resources.Requests[resourceName]
+	limit, hasLimit := resources.Limits[resourceName]
+	if hasRequest || !hasLimit {
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
 		}
-		if value, ok := existingLimitsMap[resourceName]; ok {
-			limitQuantity.Add(value)
+		request.Add(quantity)
+		resources.Requests[resourceName] = request
+	}
+	if hasLimit || !hasRequest {
+		if resources.Limits == nil {
+			resources.Limits = corev1.ResourceList{}
 		}
-		patch = appendResource(patch, resourceName.String(), reqQuantity, limitQuantity)
+		limit.Add(quantity)
+		resources.Limits[resourceName] = limit
 	}
-
-	return patch
 }
 
-func appendResource(patch []types.JSONPatchOperation, resourceName string, reqQuantity, limitQuantity resource.Quantity) []types.JSONPatchOperation {
-	patch = append(patch, types.JSONPatchOperation{
-		Operation: "add",
-		Path:      "/spec/containers/0/resources/requests/" + toSafeJSONPatchKey(resourceName),
-		Value:     reqQuantity,
-	})
-	patch = append(patch, types.JSONPatchOperation{
-		Operation: "add",
-		Path:      "/spec/containers/0/resources/limits/" + toSafeJSONPatchKey(resourceName),
-		Value:     limitQuantity,
-	})
+func setResource(container *corev1.Container, resourceName corev1.ResourceName, reqQuantity, limitQuantity resource.Quantity) {
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	if container.Resources.Limits == nil {
+		container.Resources.Limits = corev1.ResourceList{}
+	}
+	container.Resources.Requests[resourceName] = reqQuantity
+	container.Resources.Limits[resourceName] = limitQuantity
+}
 
-	return patch
+// isByteResource returns true for memory and hugepages, their amounts are in bytes instead of a number of devices
+func isByteResource(resourceName corev1.ResourceName) bool {
+	return resourceName == corev1.ResourceMemory || HugepageRegex.MatchString(string(resourceName))
 }
 
 func getResourceList(resourceRequests map[string]int64) *corev1.ResourceList {
 	resourceList := corev1.ResourceList{}
 	for name, number := range resourceRequests {
-		resourceList[corev1.ResourceName(name)] = *resource.NewQuantity(number, resource.DecimalSI)
+		format := resource.DecimalSI
+		if isByteResource(corev1.ResourceName(name)) {
+			format = resource.BinarySI
+		}
+		resourceList[corev1.ResourceName(name)] = *resource.NewQuantity(number, format)
 	}
 
 	return &resourceList
 }
 
-func appendAddAnnotPatch(patch []types.JSONPatchOperation, pod corev1.Pod, userDefinedPatch []types.JSONPatchOperation) []types.JSONPatchOperation {
+func addUserDefinedAnnotations(pod *corev1.Pod, userDefinedPatch []types.JSONPatchOperation) {
 	annotations := make(map[string]string)
-	patchOp := types.JSONPatchOperation{
-		Operation: "add",
-		Path:      metadataAnnotationsPath,
-		Value:     annotations,
-	}
 
 	for _, p := range userDefinedPatch {
 		if p.Path == metadataAnnotationsPath && p.Operation == patchOperationAdd {
@@ -714,22 +1137,22 @@ func appendAddAnnotPatch(patch []types.JSONPatchOperation, pod corev1.Pod, userD
 		}
 	}
 
-	if len(annotations) > 0 {
-		// attempt to add existing pod annotation but do not override
-		for k, v := range pod.ObjectMeta.Annotations {
-			if _, exists := annotations[k]; !exists {
-				annotations[k] = v
-			}
-		}
-		patch = append(patch, patchOp)
+	if len(annotations) == 0 {
+		return
 	}
 
-	return patch
+	// user defined annotations take precedence over the existing pod annotations
+	if pod.ObjectMeta.Annotations == nil {
+		pod.ObjectMeta.Annotations = make(map[string]string)
+	}
+	for k, v := range annotations {
+		pod.ObjectMeta.Annotations[k] = v
+	}
 }
 
-func appendUserDefinedPatch(patch []types.JSONPatchOperation, pod corev1.Pod, userDefinedPatch []types.JSONPatchOperation) []types.JSONPatchOperation {
+func applyUserDefinedPatch(pod *corev1.Pod, userDefinedPatch []types.JSONPatchOperation) {
 	//Add operation for annotations is currently only supported
-	return appendAddAnnotPatch(patch, pod, userDefinedPatch)
+	addUserDefinedAnnotations(pod, userDefinedPatch)
 }
 
 func getNetworkSelections(annotationKey string, pod corev1.Pod, userDefinedPatch []types.JSONPatchOperation) (string, bool) {
@@ -759,10 +1182,11 @@ func getNetworkSelections(annotationKey string, pod corev1.Pod, userDefinedPatch
 	return "", false
 }
 
-func processHugepagesForDownwardAPI(patch []types.JSONPatchOperation, containers []corev1.Container) ([]types.JSONPatchOperation, []hugepageResourceData) {
+func processHugepagesForDownwardAPI(containers []corev1.Container, addContainerName bool) []hugepageResourceData {
 	var hugepageResourceList []hugepageResourceData
 
-	for containerIndex, container := range containers {
+	for containerIndex := range containers {
+		container := &containers[containerIndex]
 		found := false
 
 		// Check requests
@@ -774,6 +1198,7 @@ func processHugepagesForDownwardAPI(patch []types.JSONPatchOperation, containers
 						ResourceName:  "requests." + string(resourceName),
 						ContainerName: container.Name,
 						Path:          fmt.Sprintf("hugepages_%s_request_%s", strings.ReplaceAll(hugepageSize, "i", ""), container.Name),
+						EnvName:       fmt.Sprintf("HUGEPAGES_%s_REQUEST", strings.ToUpper(strings.ReplaceAll(hugepageSize, "i", ""))),
 					}
 					hugepageResourceList = append(hugepageResourceList, hugepageResource)
 					found = true
@@ -790,6 +1215,7 @@ func processHugepagesForDownwardAPI(patch []types.JSONPatchOperation, containers
 						ResourceName:  "limits." + string(resourceName),
 						ContainerName: container.Name,
 						Path:          fmt.Sprintf("hugepages_%s_limit_%s", strings.ReplaceAll(hugepageSize, "i", ""), container.Name),
+						EnvName:       fmt.Sprintf("HUGEPAGES_%s_LIMIT", strings.ToUpper(strings.ReplaceAll(hugepageSize, "i", ""))),
 					}
 					hugepageResourceList = append(hugepageResourceList, hugepageResource)
 					found = true
@@ -797,21 +1223,25 @@ func processHugepagesForDownwardAPI(patch []types.JSONPatchOperation, containers
 			}
 		}
 
-		// If Hugepages are being added to Downward API, add the
+		// If Hugepages are being added to Downward API files, add the
 		// 'container.Name' as an environment variable to the container
 		// so container knows its name and can process hugepages properly.
-		if found {
-			patch = createEnvPatch(patch, &container, containerIndex,
-				types.EnvNameContainerName, container.Name)
+		if found && addContainerName {
+			addEnvVar(container, types.EnvNameContainerName, container.Name)
 		}
 	}
 
-	return patch, hugepageResourceList
+	return hugepageResourceList
 }
 
 // MutateHandler handles AdmissionReview requests and sends responses back to the K8s API server
-func MutateHandler(w http.ResponseWriter, req *http.Request) {
+func (wh *Webhook) MutateHandler(w http.ResponseWriter, req *http.Request) {
+	controlSwitches := wh.config.ControlSwitches()
 	glog.Infof("Received mutation request. Features status: %s", controlSwitches.GetAllFeaturesState())
+	start := wh.clock.Now()
+	defer func() {
+		glog.V(2).Infof("mutation request processed in %v", wh.clock.Since(start))
+	}()
 	var err error
 
 	/* read AdmissionReview from the HTTP request */
@@ -823,74 +1253,48 @@ func MutateHandler(w http.ResponseWriter, req *http.Request) {
 
 	/* read pod annotations */
 	/* if networks missing skip everything */
-	pod, err := deserializePod(ar)
+	pod, err := wh.deserializePod(req.Context(), ar)
 	if err != nil {
 		handleValidationError(w, ar, err)
 		return
 	}
 	glog.Infof("AdmissionReview request received for pod %s/%s", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
 
-	userDefinedPatch, err := userDefinedInjections.CreateUserDefinedPatch(pod)
+	userDefinedPatch, err := wh.config.UserDefinedInjections().CreateUserDefinedPatch(pod)
 	if err != nil {
 		glog.Warningf("failed to create user-defined injection patch for pod %s/%s, err: %v",
 			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
 	}
 
-	defaultNetSelection, defExist := getNetworkSelections(defaultNetworkAnnotationKey, pod, userDefinedPatch)
-	additionalNetSelections, addExists := getNetworkSelections(networksAnnotationKey, pod, userDefinedPatch)
-
-	if defExist || addExists {
-		/* map of resources request needed by a pod and a number of them */
-		resourceRequests := make(map[string]int64)
-
-		/* map of node labels on which pod needs to be scheduled*/
-		desiredNsMap := make(map[string]string)
+	networks, exists, err := getPodNetworks(pod, userDefinedPatch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		if defaultNetSelection != "" {
-			defNetwork, err := parsePodNetworkSelections(defaultNetSelection, pod.ObjectMeta.Namespace)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			if len(defNetwork) == 1 {
-				resourceRequests, desiredNsMap, err = parseNetworkAttachDefinition(defNetwork[0], resourceRequests, desiredNsMap)
-				if err != nil {
-					err = prepareAdmissionReviewResponse(false, err.Error(), ar)
-					if err != nil {
-						glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
-							pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
-						http.Error(w, err.Error(), http.StatusBadRequest)
-						return
-					}
-					writeResponse(w, ar)
-					return
-				}
-			}
+	if exists {
+		requirements, err := wh.getNetworkRequirements(req.Context(), pod.ObjectMeta.Namespace, networks)
+		if apierrors.IsNotFound(err) && controlSwitches.GetMissingNetAttachDefAction() == controlswitches.MissingNetAttachDefActionGate {
+			wh.admitGatedPod(w, ar, &pod, err)
+			return
+		}
+		if err == nil && !isDryRun(ar) {
+			/* templates of the claims have to exist before the pod is created */
+			err = wh.ensureResourceClaimTemplates(req.Context(), pod.ObjectMeta.Namespace, requirements.resourceClaims)
 		}
-		if additionalNetSelections != "" {
-			/* unmarshal list of network selection objects */
-			networks, err := parsePodNetworkSelections(additionalNetSelections, pod.ObjectMeta.Namespace)
+		if err != nil {
+			err = prepareAdmissionReviewResponse(false, err.Error(), ar)
 			if err != nil {
+				glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
+					pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			for _, n := range networks {
-				resourceRequests, desiredNsMap, err = parseNetworkAttachDefinition(n, resourceRequests, desiredNsMap)
-				if err != nil {
-					err = prepareAdmissionReviewResponse(false, err.Error(), ar)
-					if err != nil {
-						glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
-							pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
-						http.Error(w, err.Error(), http.StatusBadRequest)
-						return
-					}
-					writeResponse(w, ar)
-					return
-				}
-			}
-			glog.Infof("pod %s/%s has resource requests: %v and node selectors: %v", pod.ObjectMeta.Namespace,
-				pod.ObjectMeta.Name, resourceRequests, desiredNsMap)
+			writeResponse(w, ar)
+			return
 		}
+		glog.Infof("pod %s/%s has resource requests: %v, node selectors: %v and resource claims: %v", pod.ObjectMeta.Namespace,
+			pod.ObjectMeta.Name, requirements.resourceRequests, requirements.nodeSelectors, requirements.resourceClaims)
 
 		/* patch with custom resources requests and limits */
 		err = prepareAdmissionReviewResponse(true, "allowed", ar)
@@ -900,34 +1304,23 @@ func MutateHandler(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		var patch []types.JSONPatchOperation
-		if len(resourceRequests) == 0 {
-			glog.Infof("pod %s/%s doesn't need any custom network resources", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
-		} else {
-			if controlSwitches.IsHonorExistingResourcesEnabled() {
-				patch = updateResourcePatch(patch, pod.Spec.Containers, resourceRequests)
-			} else {
-				patch = createResourcePatch(patch, pod.Spec.Containers, resourceRequests)
-			}
+		mutatedPod, err := wh.mutatePod(req.Context(), &pod, requirements, userDefinedPatch)
+		if err != nil {
+			glog.Errorf("error mutating pod %s/%s, error: %v", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+			handleValidationError(w, ar, err)
+			return
+		}
+		ar.Response.Warnings = requirements.warnings
 
-			// Determine if hugepages are being requested for a given container,
-			// and if so, expose the value to the container via Downward API.
-			var hugepageResourceList []hugepageResourceData
-			if controlSwitches.IsHugePagedownAPIEnabled() {
-				patch, hugepageResourceList = processHugepagesForDownwardAPI(patch, pod.Spec.Containers)
-			}
-			patch = createVolPatch(patch, hugepageResourceList, &pod)
-			patch = appendUserDefinedPatch(patch, pod, userDefinedPatch)
+		patch, err := createPodPatch(ar.Request.Object.Raw, &pod, mutatedPod)
+		if err != nil {
+			glog.Errorf("error creating patch for pod %s/%s, error: %v",
+				pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		patch = createNodeSelectorPatch(patch, pod.Spec.NodeSelector, desiredNsMap)
 		glog.Infof("patch after all mutations: %v for pod %s/%s", patch, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
-
-		patchBytes, _ := json.Marshal(patch)
-		ar.Response.Patch = patchBytes
-		ar.Response.PatchType = func() *admissionv1.PatchType {
-			pt := admissionv1.PatchTypeJSONPatch
-			return &pt
-		}()
+		setResponsePatch(ar, patch)
 	} else {
 		/* network annotation not provided or empty */
 		glog.Infof("pod %s/%s spec doesn't have network annotations. Skipping...", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
@@ -943,9 +1336,66 @@ func MutateHandler(w http.ResponseWriter, req *http.Request) {
 	writeResponse(w, ar)
 }
 
-// SetNetAttachDefCache sets up the net attach def cache service
-func SetNetAttachDefCache(cache netcache.NetAttachDefCacheService) {
-	nadCache = cache
+// mutatePod runs the mutators with the requirements of the pod networks and returns the mutated copy of the pod,
+// warnings about the mutated pod are added to the requirements
+func (wh *Webhook) mutatePod(ctx context.Context, pod *corev1.Pod, requirements *networkRequirements,
+	userDefinedPatch []types.JSONPatchOperation) (*corev1.Pod, error) {
+	state := &MutationState{
+		ControlSwitches:  wh.config.ControlSwitches(),
+		ResourceRequests: requirements.resourceRequests,
+		NodeSelectors:    requirements.nodeSelectors,
+		ResourceClaims:   requirements.resourceClaims,
+		Capabilities:     requirements.capabilities,
+		NetworkResources: requirements.networkResources,
+		UserDefinedPatch: userDefinedPatch,
+	}
+	mutatedPod, err := wh.mutators.Mutate(ctx, pod, state)
+	if err != nil {
+		return nil, err
+	}
+	requirements.warnings = append(requirements.warnings, state.Warnings...)
+	/* deny with a clear reason instead of a patch the API server would refuse */
+	if err := validatePodLevelResources(mutatedPod); err != nil {
+		return nil, err
+	}
+	if err := wh.checkGuaranteedQoS(mutatedPod, requirements); err != nil {
+		return nil, err
+	}
+	if warning := wh.checkCapabilitiesPodSecurity(ctx, mutatedPod.Namespace, requirements.capabilities); warning != "" {
+		glog.Warningf("pod %s/%s: %s", mutatedPod.Namespace, mutatedPod.Name, warning)
+		requirements.warnings = append(requirements.warnings, warning)
+	}
+	return mutatedPod, nil
+}
+
+// admitGatedPod admits pod which references net-attach-defs that don't exist yet with the scheduling gate, the
+// SchedulingGateController deletes it once the net-attach-defs are created, so its controller creates it again
+func (wh *Webhook) admitGatedPod(w http.ResponseWriter, ar *admissionv1.AdmissionReview, pod *corev1.Pod, reason error) {
+	glog.Infof("pod %s/%s is admitted with scheduling gate %s: %v", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name,
+		networksSchedulingGate, reason)
+	gatedPod := pod.DeepCopy()
+	addSchedulingGate(gatedPod)
+
+	patch, err := createPodPatch(ar.Request.Object.Raw, pod, gatedPod)
+	if err != nil {
+		glog.Errorf("error creating patch for pod %s/%s, error: %v", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := prepareAdmissionReviewResponse(true, "allowed", ar); err != nil {
+		glog.Errorf("error preparing AdmissionReview response for pod %s/%s, error: %v",
+			pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ar.Response.Warnings = []string{fmt.Sprintf("pod is not scheduled until its networks exist: %v", reason)}
+	if metav1.GetControllerOf(pod) == nil {
+		/* network resources can't be added to the gated pod, only a pod created again gets them */
+		ar.Response.Warnings = append(ar.Response.Warnings,
+			"pod has no controller, it stays gated and has to be created again once its networks exist")
+	}
+	setResponsePatch(ar, patch)
+	writeResponse(w, ar)
 }
 
 // SetupInClusterClient setups K8s client to communicate with the API server
@@ -955,9 +1405,22 @@ func SetupInClusterClient() kubernetes.Interface {
 	if err != nil {
 		glog.Fatal(err)
 	}
-	clientset, err = kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		glog.Fatal(err)
 	}
 	return clientset
 }
+
+// SetupInClusterMetadataClient setups metadata client used to look up objects of arbitrary kinds
+func SetupInClusterMetadataClient() metadata.Interface {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		glog.Fatal(err)
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	return metadataClient
+}
@@ -16,23 +16,36 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
 	cniv1 "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	evanphx "gopkg.in/evanphx/json-patch.v4"
 	"gopkg.in/k8snetworkplumbingwg/multus-cni.v4/pkg/types"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/controlswitches"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/resourcemappings"
+	netcache "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/tools"
 	nritypes "github.com/k8snetworkplumbingwg/network-resources-injector/pkg/types"
+	"github.com/k8snetworkplumbingwg/network-resources-injector/pkg/userdefinedinjections"
 )
 
 func createBool(value bool) *bool {
@@ -43,13 +56,121 @@ func createString(value string) *string {
 	return &value
 }
 
-func deserializeNetworkAttachmentDefinition(ar *admissionv1.AdmissionReview) (cniv1.NetworkAttachmentDefinition, error) {
-	/* unmarshal NetworkAttachmentDefinition from AdmissionReview request */
-	netAttachDef := cniv1.NetworkAttachmentDefinition{}
-	err := json.Unmarshal(ar.Request.Object.Raw, &netAttachDef)
+// fakeNetAttachDefCache keeps annotations of the net-attach-defs in memory, lookups of the failing
+// net-attach-defs time out and lastKnown holds annotations of net-attach-defs retrieved before
+type fakeNetAttachDefCache struct {
+	annotations map[string]map[string]string
+	failing     map[string]bool
+	lastKnown   map[string]map[string]string
+	staleness   time.Duration
+}
+
+func (c *fakeNetAttachDefCache) Start() {}
+
+func (c *fakeNetAttachDefCache) Stop() {}
+
+func (c *fakeNetAttachDefCache) Get(ctx context.Context, namespace string, networkName string) (*cniv1.NetworkAttachmentDefinition, error) {
+	netAttachDef, _, err := c.Lookup(ctx, namespace, networkName)
 	return netAttachDef, err
 }
 
+func (c *fakeNetAttachDefCache) Lookup(_ context.Context, namespace string, networkName string) (*cniv1.NetworkAttachmentDefinition, time.Duration, error) {
+	if c.failing[namespace+"/"+networkName] {
+		return nil, 0, apierrors.NewTimeoutError("request did not complete in time", 0)
+	}
+	annotations, exists := c.annotations[namespace+"/"+networkName]
+	if !exists {
+		return nil, 0, apierrors.NewNotFound(cniv1.Resource("network-attachment-definitions"), networkName)
+	}
+	return &cniv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: networkName, Annotations: annotations},
+	}, c.staleness, nil
+}
+
+func (c *fakeNetAttachDefCache) GetLastKnown(namespace string, networkName string) (*cniv1.NetworkAttachmentDefinition, bool) {
+	annotations, exists := c.lastKnown[namespace+"/"+networkName]
+	if !exists {
+		return nil, false
+	}
+	return &cniv1.NetworkAttachmentDefinition{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: networkName, Annotations: annotations},
+	}, true
+}
+
+func (c *fakeNetAttachDefCache) List() []*cniv1.NetworkAttachmentDefinition {
+	var netAttachDefs []*cniv1.NetworkAttachmentDefinition
+	for key := range c.annotations {
+		namespace, networkName, _ := strings.Cut(key, "/")
+		netAttachDef, _ := c.Get(context.Background(), namespace, networkName)
+		netAttachDefs = append(netAttachDefs, netAttachDef)
+	}
+	return netAttachDefs
+}
+
+func (c *fakeNetAttachDefCache) Stats() netcache.CacheStats {
+	return netcache.CacheStats{Size: len(c.annotations)}
+}
+
+func (c *fakeNetAttachDefCache) AddHandler(_ netcache.NetAttachDefHandler) {}
+
+// fakeOwnerResolver keeps namespaces of the owners indexed by UID
+type fakeOwnerResolver struct {
+	namespaces map[k8stypes.UID]string
+}
+
+func (r *fakeOwnerResolver) Start() {}
+
+func (r *fakeOwnerResolver) Stop() {}
+
+func (r *fakeOwnerResolver) GetNamespace(_ context.Context, ownerRef metav1.OwnerReference) (string, error) {
+	if namespace, exists := r.namespaces[ownerRef.UID]; exists {
+		return namespace, nil
+	}
+	if ownerRef.UID == "forbidden-uid" {
+		return "", apierrors.NewForbidden(schema.GroupResource{Group: "example.com", Resource: "foos"}, "", errors.New("no RBAC"))
+	}
+	return "", errors.New("owner not found")
+}
+
+func newTestWebhook(switches *controlswitches.ControlSwitches, annotations map[string]map[string]string) *Webhook {
+	wh, err := New(Options{
+		Client:            fake.NewSimpleClientset(),
+		NetAttachDefCache: &fakeNetAttachDefCache{annotations: annotations},
+		OwnerResolver:     &fakeOwnerResolver{namespaces: map[k8stypes.UID]string{"rs-uid": "default"}},
+		Config: NewConfigProvider(switches, userdefinedinjections.CreateUserInjectionsStructure(),
+			resourcemappings.CreateResourceNameMappingsStructure()),
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return wh
+}
+
+func newAdmissionRequest(path string, object metav1.Object) *http.Request {
+	rawObject, err := json.Marshal(object)
+	Expect(err).NotTo(HaveOccurred())
+	ar := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "fake-uid",
+			Namespace: object.GetNamespace(),
+			Object:    runtime.RawExtension{Raw: rawObject},
+		},
+	}
+	body, err := json.Marshal(ar)
+	Expect(err).NotTo(HaveOccurred())
+	req := httptest.NewRequest("POST", "https://fakewebhook"+path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func defaultDownwardAPIConfig() *downwardAPIConfig {
+	return &downwardAPIConfig{
+		volumeName: nritypes.DownwardAPIVolumeName,
+		mountPath:  nritypes.DownwardAPIMountPath,
+		items: []string{controlswitches.DownwardAPIItemLabels, controlswitches.DownwardAPIItemAnnotations,
+			controlswitches.DownwardAPIItemHugepages, controlswitches.DownwardAPIItemNetworkResourcesMap},
+	}
+}
+
 var _ = Describe("Webhook", func() {
 	Describe("Preparing Admission Review Response", func() {
 		Context("Admission Review Request is nil", func() {
@@ -98,7 +219,7 @@ var _ = Describe("Webhook", func() {
 			It("should return an error", func() {
 				ar := &admissionv1.AdmissionReview{}
 				ar.Request = &admissionv1.AdmissionRequest{}
-				_, err := deserializePod(ar)
+				_, err := (&Webhook{}).deserializePod(context.Background(), ar)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -123,18 +244,35 @@ var _ = Describe("Webhook", func() {
 		})
 	})
 
+	Describe("Creating webhook", func() {
+		It("should require all dependencies", func() {
+			_, err := New(Options{})
+			Expect(err).To(HaveOccurred())
+			_, err = New(Options{Client: fake.NewSimpleClientset()})
+			Expect(err).To(HaveOccurred())
+			_, err = New(Options{Client: fake.NewSimpleClientset(), NetAttachDefCache: &fakeNetAttachDefCache{}})
+			Expect(err).To(HaveOccurred())
+			_, err = New(Options{Client: fake.NewSimpleClientset(), NetAttachDefCache: &fakeNetAttachDefCache{}, OwnerResolver: &fakeOwnerResolver{}})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
 	Describe("Handling requests", func() {
+		var wh *Webhook
+
 		BeforeEach(func() {
-			structure := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString(""))
+			structure := controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
 			structure.InitControlSwitches()
-			SetControlSwitches(structure)
+			wh = newTestWebhook(structure, map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			})
 		})
 
 		Context("Request body is empty", func() {
 			It("mutate - should return an error", func() {
 				req := httptest.NewRequest("POST", "https://fakewebhook/mutate", nil)
 				w := httptest.NewRecorder()
-				MutateHandler(w, req)
+				wh.ServeHTTP(w, req)
 				resp := w.Result()
 				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
 			})
@@ -145,11 +283,194 @@ var _ = Describe("Webhook", func() {
 				req := httptest.NewRequest("POST", "https://fakewebhook/mutate", bytes.NewBufferString("fake-body"))
 				req.Header.Set("Content-Type", "invalid-type")
 				w := httptest.NewRecorder()
-				MutateHandler(w, req)
+				wh.ServeHTTP(w, req)
 				resp := w.Result()
 				Expect(resp.StatusCode).To(Equal(http.StatusUnsupportedMediaType))
 			})
 		})
+
+		Context("Request is not routed to a handler", func() {
+			It("should return not found for unknown paths", func() {
+				req := httptest.NewRequest("POST", "https://fakewebhook/unknown", nil)
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, req)
+				Expect(w.Result().StatusCode).To(Equal(http.StatusNotFound))
+			})
+
+			It("should refuse other methods than POST", func() {
+				req := httptest.NewRequest("GET", "https://fakewebhook/mutate", nil)
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, req)
+				Expect(w.Result().StatusCode).To(Equal(http.StatusMethodNotAllowed))
+			})
+		})
+
+		Context("Pod with network annotation", func() {
+			It("mutate - should request network resources", func() {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "test",
+						Namespace:   "default",
+						Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net,sriov-net"},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+				Expect(w.Result().StatusCode).To(Equal(http.StatusOK))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeTrue())
+
+				patch, err := evanphx.DecodePatch(ar.Response.Patch)
+				Expect(err).NotTo(HaveOccurred())
+				rawPod, _ := json.Marshal(pod)
+				patchedRawPod, err := patch.Apply(rawPod)
+				Expect(err).NotTo(HaveOccurred())
+				patchedPod := corev1.Pod{}
+				Expect(json.Unmarshal(patchedRawPod, &patchedPod)).To(Succeed())
+				Expect(patchedPod.Spec.Containers[0].Resources.Requests).To(HaveKeyWithValue(corev1.ResourceName("intel.com/sriov"), resource.MustParse("2")))
+				Expect(patchedPod.Spec.Containers[0].Resources.Limits).To(HaveKeyWithValue(corev1.ResourceName("intel.com/sriov"), resource.MustParse("2")))
+			})
+
+			It("mutate - should request all resources of bonded network", func() {
+				wh = newTestWebhook(wh.config.ControlSwitches(), map[string]map[string]string{
+					"default/bond-net": {"k8s.v1.cni.cncf.io/resources": "intel.com/pf_a,intel.com/pf_b"},
+					"default/multiqueue-net": {
+						"k8s.v1.cni.cncf.io/resourceName":  "intel.com/pf_a",
+						"k8s.v1.cni.cncf.io/resourceCount": "2",
+					},
+				})
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "test",
+						Namespace:   "default",
+						Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "bond-net,multiqueue-net"},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeTrue())
+				patch, err := evanphx.DecodePatch(ar.Response.Patch)
+				Expect(err).NotTo(HaveOccurred())
+				rawPod, _ := json.Marshal(pod)
+				patchedRawPod, err := patch.Apply(rawPod)
+				Expect(err).NotTo(HaveOccurred())
+				patchedPod := corev1.Pod{}
+				Expect(json.Unmarshal(patchedRawPod, &patchedPod)).To(Succeed())
+				Expect(patchedPod.Spec.Containers[0].Resources.Limits).To(Equal(corev1.ResourceList{
+					"intel.com/pf_a": resource.MustParse("3"),
+					"intel.com/pf_b": resource.MustParse("1"),
+				}))
+			})
+
+			It("mutate - should request mapped resource names", func() {
+				wh.config.ResourceNameMappings().Mappings = []resourcemappings.ResourceNameMapping{
+					{From: "intel.com/sriov", To: []string{"intel.com/pf_a", "intel.com/pf_b"}},
+				}
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "test",
+						Namespace:   "default",
+						Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeTrue())
+				patch, err := evanphx.DecodePatch(ar.Response.Patch)
+				Expect(err).NotTo(HaveOccurred())
+				rawPod, _ := json.Marshal(pod)
+				patchedRawPod, err := patch.Apply(rawPod)
+				Expect(err).NotTo(HaveOccurred())
+				patchedPod := corev1.Pod{}
+				Expect(json.Unmarshal(patchedRawPod, &patchedPod)).To(Succeed())
+				Expect(patchedPod.Spec.Containers[0].Resources.Limits).To(Equal(corev1.ResourceList{
+					"intel.com/pf_a": resource.MustParse("1"),
+					"intel.com/pf_b": resource.MustParse("1"),
+				}))
+			})
+
+			It("mutate - should deny pods with unknown networks", func() {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "test",
+						Namespace:   "default",
+						Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "unknown-net"},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeFalse())
+				Expect(ar.Response.Result.Message).To(ContainSubstring("default/unknown-net"))
+			})
+
+			It("mutate - should resolve namespace of the pod from its owner", func() {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName:    "test-",
+						Annotations:     map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+						OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "test", UID: "rs-uid"}},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeTrue())
+				Expect(ar.Response.Patch).NotTo(BeEmpty())
+			})
+
+			It("mutate - should deny pods without namespace when their owner can't be listed", func() {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName:    "test-",
+						Annotations:     map[string]string{"k8s.v1.cni.cncf.io/networks": "default/sriov-net"},
+						OwnerReferences: []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "Foo", Name: "test", UID: "forbidden-uid"}},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeFalse())
+				Expect(ar.Response.Result.Message).To(ContainSubstring("grant list permission"))
+			})
+
+			It("mutate - should deny pods with unknown owners and without namespace", func() {
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName:    "test-",
+						Annotations:     map[string]string{"k8s.v1.cni.cncf.io/networks": "sriov-net"},
+						OwnerReferences: []metav1.OwnerReference{{APIVersion: "example.com/v1", Kind: "Foo", Name: "test", UID: "foo-uid"}},
+					},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test", Image: "busybox"}}},
+				}
+				w := httptest.NewRecorder()
+				wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+
+				ar := admissionv1.AdmissionReview{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+				Expect(ar.Response.Allowed).To(BeFalse())
+			})
+		})
 	})
 
 	Describe("Dynamic Hugepages Detection", func() {
@@ -184,8 +505,29 @@ var _ = Describe("Webhook", func() {
 							},
 						},
 					}
-					patch := addVolDownwardAPI([]nritypes.JSONPatchOperation{}, []hugepageResourceData{}, pod)
-					Expect(patch).To(BeEmpty())
+					addVolDownwardAPI(pod, defaultDownwardAPIConfig(), []hugepageResourceData{})
+					Expect(pod.Spec.Volumes).To(HaveLen(1))
+					Expect(pod.Spec.Volumes[0].DownwardAPI).To(BeNil())
+				})
+
+				It("should merge missing items into existing podnetinfo Downward API volume", func() {
+					labelsItem := corev1.DownwardAPIVolumeFile{Path: "labels", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['app']"}}
+					pod := &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Labels:      map[string]string{"app": "test"},
+							Annotations: map[string]string{"note": "test"},
+						},
+						Spec: corev1.PodSpec{
+							Volumes: []corev1.Volume{{Name: "podnetinfo", VolumeSource: corev1.VolumeSource{
+								DownwardAPI: &corev1.DownwardAPIVolumeSource{Items: []corev1.DownwardAPIVolumeFile{labelsItem}},
+							}}},
+						},
+					}
+					addVolDownwardAPI(pod, defaultDownwardAPIConfig(), []hugepageResourceData{})
+					Expect(pod.Spec.Volumes).To(HaveLen(1))
+					Expect(pod.Spec.Volumes[0].DownwardAPI.Items).To(HaveLen(2))
+					Expect(pod.Spec.Volumes[0].DownwardAPI.Items[0]).To(Equal(labelsItem))
+					Expect(pod.Spec.Volumes[0].DownwardAPI.Items[1].Path).To(Equal(nritypes.AnnotationsPath))
 				})
 
 				It("should inject when podnetinfo volume does not exist", func() {
@@ -193,8 +535,10 @@ var _ = Describe("Webhook", func() {
 						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "test"}},
 						Spec:       corev1.PodSpec{Volumes: []corev1.Volume{}},
 					}
-					patch := addVolDownwardAPI([]nritypes.JSONPatchOperation{}, []hugepageResourceData{}, pod)
-					Expect(patch).NotTo(BeEmpty())
+					addVolDownwardAPI(pod, defaultDownwardAPIConfig(), []hugepageResourceData{})
+					Expect(pod.Spec.Volumes).To(HaveLen(1))
+					Expect(pod.Spec.Volumes[0].Name).To(Equal("podnetinfo"))
+					Expect(pod.Spec.Volumes[0].DownwardAPI.Items).To(HaveLen(1))
 				})
 			})
 
@@ -208,16 +552,31 @@ var _ = Describe("Webhook", func() {
 							},
 						},
 					}
-					patch := addVolumeMount([]nritypes.JSONPatchOperation{}, containers)
-					Expect(patch).To(BeEmpty())
+					addVolumeMount(containers, defaultDownwardAPIConfig())
+					Expect(containers[0].VolumeMounts).To(HaveLen(1))
+				})
+
+				It("should skip containers with another volume mounted at the mount path", func() {
+					containers := []corev1.Container{
+						{
+							Name: "test",
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "config", MountPath: nritypes.DownwardAPIMountPath},
+							},
+						},
+					}
+					warnings := addVolumeMount(containers, defaultDownwardAPIConfig())
+					Expect(containers[0].VolumeMounts).To(HaveLen(1))
+					Expect(warnings).To(ConsistOf("container test already mounts volume config at /etc/podnetinfo, Downward API volume podnetinfo is not mounted"))
 				})
 
 				It("should inject mount when podnetinfo mount does not exist", func() {
 					containers := []corev1.Container{
 						{Name: "test", VolumeMounts: []corev1.VolumeMount{}},
 					}
-					patch := addVolumeMount([]nritypes.JSONPatchOperation{}, containers)
-					Expect(patch).NotTo(BeEmpty())
+					addVolumeMount(containers, defaultDownwardAPIConfig())
+					Expect(containers[0].VolumeMounts).To(HaveLen(1))
+					Expect(containers[0].VolumeMounts[0].MountPath).To(Equal(nritypes.DownwardAPIMountPath))
 				})
 			})
 		})
@@ -235,16 +594,15 @@ var _ = Describe("Webhook", func() {
 					},
 				}
 				containers := []corev1.Container{container}
-				initialPatch := []nritypes.JSONPatchOperation{}
 
-				updatedPatch, hugepageResourceList := processHugepagesForDownwardAPI(initialPatch, containers)
+				hugepageResourceList := processHugepagesForDownwardAPI(containers, true)
 
 				Expect(len(hugepageResourceList)).To(Equal(1))
 				Expect(hugepageResourceList[0].ResourceName).To(Equal("requests.hugepages-1Gi"))
 				Expect(hugepageResourceList[0].ContainerName).To(Equal("test-container"))
 				Expect(hugepageResourceList[0].Path).To(Equal("hugepages_1G_request_test-container"))
-				// Verify that environment variable patch was added
-				Expect(len(updatedPatch)).To(BeNumerically(">", 0))
+				// Verify that environment variable was added
+				Expect(containers[0].Env).To(ConsistOf(corev1.EnvVar{Name: nritypes.EnvNameContainerName, Value: "test-container"}))
 			})
 
 			It("should detect multiple hugepage sizes", func() {
@@ -261,9 +619,8 @@ var _ = Describe("Webhook", func() {
 				}
 
 				containers := []corev1.Container{container}
-				initialPatch := []nritypes.JSONPatchOperation{}
 
-				_, hugepageResourceList := processHugepagesForDownwardAPI(initialPatch, containers)
+				hugepageResourceList := processHugepagesForDownwardAPI(containers, true)
 				Expect(len(hugepageResourceList)).To(Equal(4))
 
 				// Verify all hugepage sizes are detected
@@ -290,9 +647,8 @@ var _ = Describe("Webhook", func() {
 				}
 
 				containers := []corev1.Container{container}
-				initialPatch := []nritypes.JSONPatchOperation{}
 
-				_, hugepageResourceList := processHugepagesForDownwardAPI(initialPatch, containers)
+				hugepageResourceList := processHugepagesForDownwardAPI(containers, true)
 				Expect(len(hugepageResourceList)).To(Equal(0))
 			})
 		})
@@ -310,9 +666,8 @@ var _ = Describe("Webhook", func() {
 				}
 
 				containers := []corev1.Container{container}
-				initialPatch := []nritypes.JSONPatchOperation{}
 
-				_, hugepageResourceList := processHugepagesForDownwardAPI(initialPatch, containers)
+				hugepageResourceList := processHugepagesForDownwardAPI(containers, true)
 				Expect(len(hugepageResourceList)).To(Equal(2))
 
 				// Check both limits are detected
@@ -349,9 +704,8 @@ var _ = Describe("Webhook", func() {
 				}
 
 				containers := []corev1.Container{container}
-				initialPatch := []nritypes.JSONPatchOperation{}
 
-				_, hugepageResourceList := processHugepagesForDownwardAPI(initialPatch, containers)
+				hugepageResourceList := processHugepagesForDownwardAPI(containers, true)
 				Expect(len(hugepageResourceList)).To(Equal(3))
 
 				// Verify all are detected
@@ -382,9 +736,8 @@ var _ = Describe("Webhook", func() {
 				}
 
 				containers := []corev1.Container{container}
-				initialPatch := []nritypes.JSONPatchOperation{}
 
-				_, hugepageResourceList := processHugepagesForDownwardAPI(initialPatch, containers)
+				hugepageResourceList := processHugepagesForDownwardAPI(containers, true)
 				Expect(len(hugepageResourceList)).To(Equal(0))
 			})
 		})
@@ -583,3 +936,203 @@ var _ = Describe("Webhook", func() {
 		),
 	)
 })
+
+var _ = Describe("Net-attach-def lookup failures", func() {
+	var (
+		switches *controlswitches.ControlSwitches
+		nadCache *fakeNetAttachDefCache
+		pod      *corev1.Pod
+	)
+
+	mutate := func() *admissionv1.AdmissionResponse {
+		wh, err := New(Options{
+			Client:            fake.NewSimpleClientset(),
+			NetAttachDefCache: nadCache,
+			OwnerResolver:     &fakeOwnerResolver{},
+			Config: NewConfigProvider(switches, userdefinedinjections.CreateUserInjectionsStructure(),
+				resourcemappings.CreateResourceNameMappingsStructure()),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		w := httptest.NewRecorder()
+		wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+		ar := admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+		return ar.Response
+	}
+
+	patchedResources := func(response *admissionv1.AdmissionResponse) []corev1.ResourceName {
+		patch, err := evanphx.DecodePatch(response.Patch)
+		Expect(err).NotTo(HaveOccurred())
+		podJSON, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		patchedJSON, err := patch.Apply(podJSON)
+		Expect(err).NotTo(HaveOccurred())
+		patched := corev1.Pod{}
+		Expect(json.Unmarshal(patchedJSON, &patched)).To(Succeed())
+		var resourceNames []corev1.ResourceName
+		for resourceName := range patched.Spec.Containers[0].Resources.Requests {
+			resourceNames = append(resourceNames, resourceName)
+		}
+		return resourceNames
+	}
+
+	BeforeEach(func() {
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "slow-net,sriov-net"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		switches = controlswitches.SetupControlSwitchesUnitTests(createBool(false), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		nadCache = &fakeNetAttachDefCache{
+			annotations: map[string]map[string]string{
+				"default/sriov-net": {"k8s.v1.cni.cncf.io/resourceName": "intel.com/sriov"},
+			},
+			failing:   map[string]bool{"default/slow-net": true},
+			lastKnown: map[string]map[string]string{},
+		}
+	})
+
+	It("should deny the pod by default", func() {
+		switches.InitControlSwitches()
+		response := mutate()
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(ContainSubstring("default/slow-net"))
+	})
+
+	It("should admit the pod without the network and with a warning", func() {
+		switches.SetLookupFailurePolicyUnitTests(controlswitches.LookupFailurePolicyAdmit)
+		switches.InitControlSwitches()
+		response := mutate()
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(ConsistOf(ContainSubstring("net-attach-def default/slow-net are not injected")))
+		Expect(patchedResources(response)).To(ConsistOf(corev1.ResourceName("intel.com/sriov")))
+	})
+
+	It("should inject the network from the last known net-attach-def", func() {
+		nadCache.lastKnown["default/slow-net"] = map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/slow"}
+		switches.SetLookupFailurePolicyUnitTests(controlswitches.LookupFailurePolicyUseCached)
+		switches.InitControlSwitches()
+		response := mutate()
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(ConsistOf(ContainSubstring("last known net-attach-def default/slow-net is used")))
+		Expect(patchedResources(response)).To(ConsistOf(corev1.ResourceName("intel.com/sriov"), corev1.ResourceName("intel.com/slow")))
+	})
+
+	It("should deny the pod when there is no last known net-attach-def", func() {
+		switches.SetLookupFailurePolicyUnitTests(controlswitches.LookupFailurePolicyUseCached)
+		switches.InitControlSwitches()
+		Expect(mutate().Allowed).To(BeFalse())
+	})
+
+	It("should apply the policy of the pod namespace", func() {
+		switches.InitControlSwitches()
+		switches.ProcessControlSwitchesConfigMap(&corev1.ConfigMap{
+			Data: map[string]string{"config.json": `{"lookupFailurePolicies": {"default": "admit"}}`},
+		})
+		Expect(mutate().Allowed).To(BeTrue())
+	})
+
+	It("should not apply the policy to net-attach-defs which don't exist", func() {
+		switches.SetLookupFailurePolicyUnitTests(controlswitches.LookupFailurePolicyAdmit)
+		switches.InitControlSwitches()
+		delete(nadCache.failing, "default/slow-net")
+		Expect(mutate().Allowed).To(BeFalse())
+	})
+
+	It("should warn when the net-attach-defs may be stale", func() {
+		switches.InitControlSwitches()
+		delete(nadCache.failing, "default/slow-net")
+		nadCache.annotations["default/slow-net"] = map[string]string{"k8s.v1.cni.cncf.io/resourceName": "intel.com/slow"}
+		nadCache.staleness = 90 * time.Second
+		response := mutate()
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(ConsistOf(
+			ContainSubstring("default/slow-net may be stale for 1m30s"),
+			ContainSubstring("default/sriov-net may be stale for 1m30s")))
+	})
+})
+
+var _ = Describe("Net-attach-def hugepages", func() {
+	It("should request hugepages and memory of every attachment and expose them via Downward API", func() {
+		switches := controlswitches.SetupControlSwitchesUnitTests(createBool(true), createBool(false), createString("k8s.v1.cni.cncf.io/resourceName"))
+		switches.InitControlSwitches()
+		wh := newTestWebhook(switches, map[string]map[string]string{
+			"default/dpdk-net": {
+				"k8s.v1.cni.cncf.io/resourceName": "intel.com/dpdk",
+				"k8s.v1.cni.cncf.io/hugepages":    "hugepages-1Gi=1Gi",
+				"k8s.v1.cni.cncf.io/memory":       "512Mi",
+			},
+		})
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test",
+				Namespace:   "default",
+				Annotations: map[string]string{"k8s.v1.cni.cncf.io/networks": "dpdk-net,dpdk-net"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+
+		w := httptest.NewRecorder()
+		wh.ServeHTTP(w, newAdmissionRequest("/mutate", pod))
+		ar := admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &ar)).To(Succeed())
+		Expect(ar.Response.Allowed).To(BeTrue())
+
+		patch, err := evanphx.DecodePatch(ar.Response.Patch)
+		Expect(err).NotTo(HaveOccurred())
+		podJSON, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		patchedJSON, err := patch.Apply(podJSON)
+		Expect(err).NotTo(HaveOccurred())
+		patched := corev1.Pod{}
+		Expect(json.Unmarshal(patchedJSON, &patched)).To(Succeed())
+
+		resources := patched.Spec.Containers[0].Resources
+		for _, list := range []corev1.ResourceList{resources.Requests, resources.Limits} {
+			Expect(list).To(HaveKeyWithValue(corev1.ResourceName("hugepages-1Gi"), resource.MustParse("2Gi")))
+			Expect(list).To(HaveKeyWithValue(corev1.ResourceMemory, resource.MustParse("1Gi")))
+			Expect(list).To(HaveKeyWithValue(corev1.ResourceName("intel.com/dpdk"), resource.MustParse("2")))
+		}
+		Expect(patched.Spec.Volumes).To(HaveLen(1))
+		paths := []string{}
+		for _, item := range patched.Spec.Volumes[0].DownwardAPI.Items {
+			paths = append(paths, item.Path)
+		}
+		Expect(paths).To(ContainElements("hugepages_1G_request_app", "hugepages_1G_limit_app"))
+	})
+
+	It("should add hugepages and memory on top of the resources of the container", func() {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "app",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{"memory": resource.MustParse("256Mi")},
+				Limits:   corev1.ResourceList{"hugepages-1Gi": resource.MustParse("1Gi"), "intel.com/dpdk": resource.MustParse("1")},
+			},
+		}}}}
+		addResources(pod, &pod.Spec.Containers[0], map[string]int64{
+			"hugepages-1Gi": 1 << 30, "memory": 512 << 20, "intel.com/dpdk": 2,
+		})
+
+		resources := pod.Spec.Containers[0].Resources
+		Expect(resources.Requests).To(HaveLen(1))
+		Expect(resources.Requests.Memory().String()).To(Equal("768Mi"))
+		Expect(resources.Limits).To(HaveLen(2))
+		Expect(resources.Limits.Name("hugepages-1Gi", resource.BinarySI).String()).To(Equal("2Gi"))
+		Expect(resources.Limits.Name("intel.com/dpdk", resource.DecimalSI).String()).To(Equal("1"))
+	})
+
+	It("should not add limits to resources which are only requested when honoring existing resources", func() {
+		container := &corev1.Container{Name: "app", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{"intel.com/sriov": resource.MustParse("1")},
+		}}
+		updateResources(container, map[string]int64{"intel.com/sriov": 1, "intel.com/dpdk": 1})
+
+		Expect(container.Resources.Requests.Name("intel.com/sriov", resource.DecimalSI).String()).To(Equal("2"))
+		Expect(container.Resources.Requests.Name("intel.com/dpdk", resource.DecimalSI).String()).To(Equal("1"))
+		Expect(container.Resources.Limits).To(HaveLen(1))
+		Expect(container.Resources.Limits.Name("intel.com/dpdk", resource.DecimalSI).String()).To(Equal("1"))
+	})
+})
@@ -20,6 +20,7 @@ set -e
 # Set our known directories and parameters.
 BASE_DIR="$(cd "$(dirname "$0")"/..; pwd)"
 NAMESPACE="kube-system"
+ENABLE_VALIDATION="false"
 
 # Give help text for parameters.
 function usage()
@@ -27,6 +28,7 @@ function usage()
     echo -e "./webhook-deployment.sh"
     echo -e "\t-h --help"
     echo -e "\t--namespace=${NAMESPACE}"
+    echo -e "\t--enable-validation"
 }
 
 
@@ -42,6 +44,9 @@ while [ "$1" != "" ]; do
         --namespace)
             NAMESPACE=$VALUE
             ;;
+        --enable-validation)
+            ENABLE_VALIDATION="true"
+            ;;
         *)
             echo "ERROR: unknown parameter \"$PARAM\""
             usage
@@ -57,6 +62,12 @@ cat "${BASE_DIR}/deployments/webhook.yaml" | \
 	"${BASE_DIR}/scripts/webhook-patch-ca-bundle.sh" | \
 	sed -e "s|\${NAMESPACE}|${NAMESPACE}|g" | \
 	kubectl -n "${NAMESPACE}" create -f -
+if [ "${ENABLE_VALIDATION}" == "true" ]; then
+    cat "${BASE_DIR}/deployments/validating-webhook.yaml" | \
+	    "${BASE_DIR}/scripts/webhook-patch-ca-bundle.sh" | \
+	    sed -e "s|\${NAMESPACE}|${NAMESPACE}|g" | \
+	    kubectl -n "${NAMESPACE}" create -f -
+fi
 
 kubectl -n "${NAMESPACE}" create -f "${BASE_DIR}/deployments/auth.yaml"
 kubectl -n "${NAMESPACE}" create -f "${BASE_DIR}/deployments/server.yaml"